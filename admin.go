@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	api "github.com/Fepozopo/chirpy/api"
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/config"
+	database "github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/webhooks"
+)
+
+const usersUsage = `usage: chirpy users <command> [-json] [arguments]
+
+Commands:
+  create -email <email> [-password <password>] [-red]
+  list
+  delete <user>
+  grant-red <user>
+  revoke-red <user>
+  reset-password [-password <password>] <user>
+  revoke-tokens <user>
+
+A user is given by ID or email. Without -password a random password is
+generated and printed. Deleting a user sends the chirp.deleted webhook for
+each of their chirps, but open streams are not notified.`
+
+const chirpsUsage = `usage: chirpy chirps delete [-json] <chirp ID>
+
+Deleting a chirp sends the chirp.deleted webhook, but open streams are not
+notified.`
+
+const seedUsage = `usage: chirpy seed [-json] [-users <n>] [-chirps <n per user>] [-password <password>]`
+
+// printer writes the results of admin commands, either as tables and messages
+// meant for people or as JSON meant for scripts.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// users writes users as a table or a JSON array. Password hashes are never
+// written.
+func (p printer) users(users []database.User) error {
+	if p.json {
+		mapped := make([]api.MappedUser, len(users))
+		for i, user := range users {
+			mapped[i] = mapUser(user)
+		}
+		return p.encode(mapped)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tCHIRPY RED\tCREATED AT")
+	for _, user := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", user.ID, user.Email, user.IsChirpyRed, user.CreatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// result writes v as JSON, or the formatted message otherwise.
+func (p printer) result(v any, format string, args ...any) error {
+	if p.json {
+		return p.encode(v)
+	}
+	_, err := fmt.Fprintf(p.w, format+"\n", args...)
+	return err
+}
+
+func (p printer) encode(v any) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func mapUser(user database.User) api.MappedUser {
+	return api.MappedUser{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+	}
+}
+
+// parseArgs parses the flags of an admin command, which may appear before or
+// after its positional arguments, and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// usageError prints the usage of an admin command and returns its exit code.
+func usageError(usage string) int {
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// runAdmin connects to the database and runs an admin command. Errors are
+// written to stderr, so that they never mix with JSON output on stdout.
+func runAdmin(cfg config.Config, run func(ctx context.Context, q *database.Queries) error) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := func() error {
+		db, err := openDB(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return run(ctx, database.New(db))
+	}()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chirpy:", err)
+		return 1
+	}
+	return 0
+}
+
+// findUser looks up a user by ID or email.
+func findUser(ctx context.Context, q *database.Queries, ref string) (database.User, error) {
+	var user database.User
+	var err error
+	if id, parseErr := uuid.Parse(ref); parseErr == nil {
+		user, err = q.GetUser(ctx, id)
+	} else {
+		user, err = q.GetUserByEmail(ctx, ref)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, fmt.Errorf("user %s not found", ref)
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to find user %s: %w", ref, err)
+	}
+	return user, nil
+}
+
+// randomPassword generates a password for users created or reset without one.
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate a password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// runUsers runs the users command, which manages user accounts.
+func runUsers(cfg config.Config, args []string) int {
+	if len(args) == 0 {
+		return usageError(usersUsage)
+	}
+	command, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("users "+command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "")
+	email := fs.String("email", "", "")
+	password := fs.String("password", "", "")
+	red := fs.Bool("red", false, "")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return usageError(usersUsage)
+	}
+
+	// Every command except create and list takes exactly one user
+	switch command {
+	case "create":
+		if len(positional) != 0 || *email == "" {
+			return usageError(usersUsage)
+		}
+	case "list":
+		if len(positional) != 0 {
+			return usageError(usersUsage)
+		}
+	case "delete", "grant-red", "revoke-red", "reset-password", "revoke-tokens":
+		if len(positional) != 1 {
+			return usageError(usersUsage)
+		}
+	default:
+		return usageError(usersUsage)
+	}
+	out := printer{w: os.Stdout, json: *asJSON}
+
+	// Passwords chosen by the operator must meet the same policy as the API's
+	// and are hashed the same way
+	policy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chirpy:", err)
+		return 1
+	}
+	hasher := newPasswordHasher(cfg)
+
+	return runAdmin(cfg, func(ctx context.Context, q *database.Queries) error {
+		var user database.User
+		if len(positional) == 1 {
+			var err error
+			if user, err = findUser(ctx, q, positional[0]); err != nil {
+				return err
+			}
+		}
+
+		switch command {
+		case "create":
+			return createUser(ctx, q, out, policy, hasher, *email, *password, *red)
+
+		case "list":
+			users, err := q.ListUsers(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list users: %w", err)
+			}
+			return out.users(users)
+
+		case "delete":
+			// Chirps, refresh tokens and webhook subscriptions are deleted by
+			// cascade, so look up the chirps to report first
+			chirps, err := q.GetUserChirps(ctx, database.GetUserChirpsParams{
+				UserID:   user.ID,
+				ViewerID: uuid.NullUUID{UUID: user.ID, Valid: true},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to list the user's chirps: %w", err)
+			}
+			if _, err := q.DeleteUser(ctx, user.ID); err != nil {
+				return fmt.Errorf("failed to delete user: %w", err)
+			}
+			for _, chirp := range chirps {
+				if err := emitChirpDeleted(ctx, q, chirp, user); err != nil {
+					return err
+				}
+			}
+			return out.result(map[string]any{"deleted_user_id": user.ID}, "Deleted user %s (%s)", user.ID, user.Email)
+
+		case "grant-red", "revoke-red":
+			if _, err := q.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{
+				ID:          user.ID,
+				IsChirpyRed: command == "grant-red",
+			}); err != nil {
+				return fmt.Errorf("failed to update user: %w", err)
+			}
+			user, err := q.GetUser(ctx, user.ID)
+			if err != nil {
+				return fmt.Errorf("failed to find user: %w", err)
+			}
+			return out.users([]database.User{user})
+
+		case "reset-password":
+			return resetPassword(ctx, q, out, policy, hasher, user, *password)
+
+		case "revoke-tokens":
+			revoked, err := q.RevokeUserRefreshTokens(ctx, user.ID)
+			if err != nil {
+				return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+			}
+			return out.result(map[string]any{"user_id": user.ID, "revoked_tokens": revoked}, "Revoked %d refresh tokens of %s", revoked, user.Email)
+		}
+		return nil
+	})
+}
+
+func createUser(ctx context.Context, q *database.Queries, out printer, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, email, password string, red bool) error {
+	generated := password == ""
+	if generated {
+		var err error
+		if password, err = randomPassword(); err != nil {
+			return err
+		}
+	} else if err := policy.Check(password, email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hasher.Hash(ctx, password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	user, err := q.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if red {
+		if _, err := q.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{ID: user.ID, IsChirpyRed: true}); err != nil {
+			return fmt.Errorf("failed to grant Chirpy Red: %w", err)
+		}
+		user.IsChirpyRed = true
+	}
+
+	if !generated {
+		return out.users([]database.User{user})
+	}
+	return out.result(struct {
+		api.MappedUser
+		Password string `json:"password"`
+	}{mapUser(user), password}, "Created user %s (%s) with password %s", user.ID, user.Email, password)
+}
+
+// resetPassword sets a new password and revokes the user's refresh tokens, so
+// that existing sessions end once their access tokens expire.
+func resetPassword(ctx context.Context, q *database.Queries, out printer, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, user database.User, password string) error {
+	generated := password == ""
+	if generated {
+		var err error
+		if password, err = randomPassword(); err != nil {
+			return err
+		}
+	} else if err := policy.Check(password, user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hasher.Hash(ctx, password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if _, err := q.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID:             user.ID,
+		HashedPassword: hashedPassword,
+	}); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	revoked, err := q.RevokeUserRefreshTokens(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	result := map[string]any{"user_id": user.ID, "revoked_tokens": revoked}
+	if !generated {
+		return out.result(result, "Reset the password of %s and revoked %d refresh tokens", user.Email, revoked)
+	}
+	result["password"] = password
+	return out.result(result, "Reset the password of %s to %s and revoked %d refresh tokens", user.Email, password, revoked)
+}
+
+// runChirps runs the chirps command, which moderates chirps.
+func runChirps(cfg config.Config, args []string) int {
+	if len(args) == 0 || args[0] != "delete" {
+		return usageError(chirpsUsage)
+	}
+
+	fs := flag.NewFlagSet("chirps delete", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "")
+
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil || len(positional) != 1 {
+		return usageError(chirpsUsage)
+	}
+	chirpID, err := uuid.Parse(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chirpy: invalid chirp ID %q\n", positional[0])
+		return 2
+	}
+	out := printer{w: os.Stdout, json: *asJSON}
+
+	return runAdmin(cfg, func(ctx context.Context, q *database.Queries) error {
+		chirp, err := q.GetChirp(ctx, chirpID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("chirp %s not found", chirpID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find chirp: %w", err)
+		}
+
+		author, err := q.GetUser(ctx, chirp.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to find the author: %w", err)
+		}
+
+		if err := q.DeleteChirp(ctx, chirp.ID); err != nil {
+			return fmt.Errorf("failed to delete chirp: %w", err)
+		}
+		if err := emitChirpDeleted(ctx, q, chirp, author); err != nil {
+			return err
+		}
+		return out.result(map[string]any{"deleted_chirp_id": chirp.ID}, "Deleted chirp %s by %s", chirp.ID, chirp.UserID)
+	})
+}
+
+// emitChirpDeleted queues the chirp.deleted webhook of a deleted chirp, like
+// the API does. The deliveries are sent by the dispatcher of a running server.
+func emitChirpDeleted(ctx context.Context, q *database.Queries, chirp database.Chirp, author database.User) error {
+	owner, data := api.ChirpDeletedWebhook(chirp, author)
+	if err := webhooks.NewDispatcher(q).Emit(ctx, webhooks.EventChirpDeleted, owner, data); err != nil {
+		return fmt.Errorf("deleted chirp %s, but failed to queue its webhook: %w", chirp.ID, err)
+	}
+	return nil
+}
+
+// seedWords are combined into the bodies of seeded chirps.
+var seedWords = strings.Fields(`just shipped a new feature today and the coffee was
+great but the build is broken again so back to debugging with my cat who loves
+golang postgres chirping about the weather`)
+
+// runSeed runs the seed command, which fills a development database with fake
+// users and chirps. It refuses to run unless PLATFORM is "dev".
+func runSeed(cfg config.Config, platform string, args []string) int {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "")
+	userCount := fs.Int("users", 10, "")
+	chirpCount := fs.Int("chirps", 5, "")
+	password := fs.String("password", "password", "")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 || *userCount < 0 || *chirpCount < 0 {
+		return usageError(seedUsage)
+	}
+	if platform != "dev" {
+		fmt.Fprintln(os.Stderr, `chirpy: seeding is only allowed when PLATFORM is "dev"`)
+		return 1
+	}
+	out := printer{w: os.Stdout, json: *asJSON}
+
+	return runAdmin(cfg, func(ctx context.Context, q *database.Queries) error {
+		// Every seeded user shares the same password, so it is only hashed once. It
+		// is not checked against the password policy, as seeded data is only used
+		// in development.
+		hashedPassword, err := newPasswordHasher(cfg).Hash(ctx, *password)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+
+		users := make([]database.User, 0, *userCount)
+		for i := 0; i < *userCount; i++ {
+			suffix, err := randomPassword()
+			if err != nil {
+				return err
+			}
+			user, err := q.CreateUser(ctx, database.CreateUserParams{
+				Email:          fmt.Sprintf("seed-%s@example.com", strings.ToLower(suffix[:8])),
+				HashedPassword: hashedPassword,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create user: %w", err)
+			}
+			users = append(users, user)
+
+			for j := 0; j < *chirpCount; j++ {
+				body, err := randomChirpBody()
+				if err != nil {
+					return err
+				}
+				if _, err := q.CreateChirp(ctx, database.CreateChirpParams{Body: body, UserID: user.ID}); err != nil {
+					return fmt.Errorf("failed to create chirp: %w", err)
+				}
+			}
+		}
+		return out.users(users)
+	})
+}
+
+// randomChirpBody returns between 4 and 12 random seed words.
+func randomChirpBody() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(9))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate a chirp: %w", err)
+	}
+	words := make([]string, 4+n.Int64())
+	for i := range words {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(seedWords))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate a chirp: %w", err)
+		}
+		words[i] = seedWords[index.Int64()]
+	}
+	return strings.Join(words, " "), nil
+}
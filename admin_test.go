@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	database "github.com/Fepozopo/chirpy/internal/database"
+)
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "")
+	password := fs.String("password", "", "")
+
+	positional, err := parseArgs(fs, []string{"-password", "secret", "user@example.com", "-json"})
+	if err != nil {
+		t.Fatalf("Failed to parse arguments: %v", err)
+	}
+	if len(positional) != 1 || positional[0] != "user@example.com" {
+		t.Errorf("Expected one positional argument, got %v", positional)
+	}
+	if !*asJSON || *password != "secret" {
+		t.Errorf("Expected flags after the positional argument to be parsed, got json=%t password=%q", *asJSON, *password)
+	}
+}
+
+func TestPrinterUsers(t *testing.T) {
+	users := []database.User{{
+		ID:             uuid.New(),
+		CreatedAt:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Email:          "user@example.com",
+		HashedPassword: "$2a$12$hash",
+		IsChirpyRed:    true,
+	}}
+
+	var table strings.Builder
+	if err := (printer{w: &table}).users(users); err != nil {
+		t.Fatalf("Failed to print table: %v", err)
+	}
+	if !strings.Contains(table.String(), "user@example.com  true        2024-01-02T03:04:05Z") {
+		t.Errorf("Unexpected table:\n%s", table.String())
+	}
+
+	var out strings.Builder
+	if err := (printer{w: &out, json: true}).users(users); err != nil {
+		t.Fatalf("Failed to print JSON: %v", err)
+	}
+	if strings.Contains(out.String(), "$2a$") {
+		t.Fatal("JSON output should not include the password hash")
+	}
+	var decoded []map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON output: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0]["email"] != "user@example.com" || decoded[0]["is_chirpy_red"] != true {
+		t.Errorf("Unexpected JSON output: %s", out.String())
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// HandleBlockUser makes the authenticated user block the user with the ID in
+// the path. Blocking works both ways: it ends the follows between the two
+// users, and neither can follow, mention, reply to or message the other until
+// the block is lifted. Blocking a user twice has no further effect. It responds
+// with a 204 status code.
+func (cfg *ApiConfig) HandleBlockUser(w http.ResponseWriter, r *http.Request) {
+	blockerID, blockedID, ok := cfg.relationRequest(w, r, "block")
+	if !ok {
+		return
+	}
+	if !cfg.checkUserExists(w, r, blockedID) {
+		return
+	}
+
+	rows, err := cfg.DbQueries.BlockUser(r.Context(), database.BlockUserParams{
+		BlockerID: blockerID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to block user", err)
+		return
+	}
+	if rows > 0 {
+		// Let the open streams of both users drop the other
+		cfg.publishRelation(r.Context(), stream.EventUserBlocked, blockerID, blockedID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUnblockUser lifts the authenticated user's block of the user with the
+// ID in the path. The follows the block ended are not restored. It responds
+// with a 204 status code, or a 404 status code if they did not block the user.
+func (cfg *ApiConfig) HandleUnblockUser(w http.ResponseWriter, r *http.Request) {
+	blockerID, blockedID, ok := cfg.relationRequest(w, r, "block")
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.UnblockUser(r.Context(), database.UnblockUserParams{
+		BlockerID: blockerID,
+		BlockedID: blockedID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to unblock user", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "You have not blocked this user", nil)
+		return
+	}
+	cfg.publishRelation(r.Context(), stream.EventUserUnblocked, blockerID, blockedID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListBlocks responds with the users the authenticated user blocked,
+// most recently blocked first.
+func (cfg *ApiConfig) HandleListBlocks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.ListBlockedUsers(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get blocked users", err)
+		return
+	}
+	users := make([]MappedRelatedUser, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, mapRelatedUser(row.ID, row.Username, row.DisplayName, row.CreatedAt))
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// HandleMuteUser makes the authenticated user mute the user with the ID in the
+// path. Muting only works one way: the muted user's chirps and actions are
+// hidden from the authenticated user, who can still follow them and talk to
+// them. Muting a user twice has no further effect. It responds with a 204
+// status code.
+func (cfg *ApiConfig) HandleMuteUser(w http.ResponseWriter, r *http.Request) {
+	muterID, mutedID, ok := cfg.relationRequest(w, r, "mute")
+	if !ok {
+		return
+	}
+	if !cfg.checkUserExists(w, r, mutedID) {
+		return
+	}
+
+	rows, err := cfg.DbQueries.MuteUser(r.Context(), database.MuteUserParams{
+		MuterID: muterID,
+		MutedID: mutedID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to mute user", err)
+		return
+	}
+	if rows > 0 {
+		cfg.publishRelation(r.Context(), stream.EventUserMuted, muterID, mutedID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUnmuteUser makes the authenticated user stop muting the user with the
+// ID in the path. It responds with a 204 status code, or a 404 status code if
+// they did not mute the user.
+func (cfg *ApiConfig) HandleUnmuteUser(w http.ResponseWriter, r *http.Request) {
+	muterID, mutedID, ok := cfg.relationRequest(w, r, "mute")
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.UnmuteUser(r.Context(), database.UnmuteUserParams{
+		MuterID: muterID,
+		MutedID: mutedID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to unmute user", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "You have not muted this user", nil)
+		return
+	}
+	cfg.publishRelation(r.Context(), stream.EventUserUnmuted, muterID, mutedID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListMutes responds with the users the authenticated user muted, most
+// recently muted first.
+func (cfg *ApiConfig) HandleListMutes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.ListMutedUsers(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get muted users", err)
+		return
+	}
+	users := make([]MappedRelatedUser, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, mapRelatedUser(row.ID, row.Username, row.DisplayName, row.CreatedAt))
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// publishRelation publishes an event about a block or mute, which only updates
+// the open streams of the two users.
+func (cfg *ApiConfig) publishRelation(ctx context.Context, eventType string, userID, otherID uuid.UUID) {
+	if cfg.Stream == nil && cfg.Events == nil {
+		return
+	}
+	data, err := json.Marshal(RelationEvent{UserID: userID, OtherID: otherID})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode stream event", "event", eventType, "error", err)
+		return
+	}
+	cfg.publish(ctx, stream.Event{
+		Type:     eventType,
+		AuthorID: userID,
+		Data:     data,
+	})
+}
+
+func mapRelatedUser(id uuid.UUID, username sql.NullString, displayName string, since time.Time) MappedRelatedUser {
+	user := MappedRelatedUser{ID: id, DisplayName: displayName, Since: since}
+	if username.Valid {
+		user.Username = &username.String
+	}
+	return user
+}
+
+// checkNotBlocked checks that the user neither blocked nor was blocked by any
+// of the others. Otherwise it responds with a 403 status code and the detail,
+// and returns false.
+func (cfg *ApiConfig) checkNotBlocked(w http.ResponseWriter, r *http.Request, userID uuid.UUID, otherIDs []uuid.UUID, detail string) bool {
+	blocked, err := cfg.DbQueries.IsBlockedWithAny(r.Context(), database.IsBlockedWithAnyParams{
+		UserID:   userID,
+		OtherIds: otherIDs,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up blocks", err)
+		return false
+	}
+	if blocked {
+		respondWithError(w, r, CodeForbidden, detail, nil)
+		return false
+	}
+	return true
+}
+
+// checkChirpNotBlocked checks that the viewer, if any, and the author of the
+// chirp did not block each other, which chirp_visible_to does not consider.
+// Otherwise it responds with a 404 status code, as for chirps the viewer may
+// not see, and returns false.
+func (cfg *ApiConfig) checkChirpNotBlocked(w http.ResponseWriter, r *http.Request, viewerID uuid.NullUUID, chirp database.Chirp) bool {
+	if !viewerID.Valid || viewerID.UUID == chirp.UserID {
+		return true
+	}
+	blocked, err := cfg.DbQueries.IsBlockedWithAny(r.Context(), database.IsBlockedWithAnyParams{
+		UserID:   viewerID.UUID,
+		OtherIds: []uuid.UUID{chirp.UserID},
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up blocks", err)
+		return false
+	}
+	if blocked {
+		respondWithError(w, r, CodeNotFound, "Failed to find chirp with ID: "+chirp.ID.String(), nil)
+		return false
+	}
+	return true
+}
+
+// checkUserExists checks that there is a user with the ID. Otherwise it
+// responds with a 404 status code and returns false.
+func (cfg *ApiConfig) checkUserExists(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	if _, err := cfg.DbQueries.GetUser(r.Context(), userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, CodeNotFound, "Failed to find user with ID: "+userID.String(), err)
+			return false
+		}
+		respondWithError(w, r, CodeInternal, "Failed to get user", err)
+		return false
+	}
+	return true
+}
@@ -1,38 +1,97 @@
 package api
 
 import (
-	"sync/atomic"
+	"database/sql"
+	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/blobstore"
 	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/eventbus"
+	"github.com/Fepozopo/chirpy/internal/metrics"
+	"github.com/Fepozopo/chirpy/internal/migrations"
+	"github.com/Fepozopo/chirpy/internal/stream"
+	"github.com/Fepozopo/chirpy/internal/webhooks"
 	"github.com/google/uuid"
 )
 
 type ApiConfig struct {
-	fileserverHits atomic.Int32
-	DbQueries      *database.Queries
-	Platform       string `env:"PLATFORM"`
-	TokenSecret    string `env:"TOKEN_SECRET"`
-	StripeKey      string `env:"STRIPE_KEY"`
+	DB              *sql.DB
+	DbQueries       *database.Queries
+	Metrics         *metrics.Metrics
+	Migrations      *migrations.Migrator
+	Webhooks        *webhooks.Dispatcher
+	PasswordPolicy  *auth.PasswordPolicy
+	PasswordHasher  *auth.PasswordHasher
+	Blobs           blobstore.BlobStore
+	MediaMaxBytes   int64
+	ThumbnailSize   int
+	Stream          *stream.Broker
+	Events          eventbus.Bus
+	StreamHeartbeat time.Duration
+	Platform        string `env:"PLATFORM"`
+	TokenSecret     string `env:"TOKEN_SECRET" secret:"true" validate:"required,min=32"`
+	StripeKey       string `env:"STRIPE_KEY" secret:"true"`
+	AdminKey        string `env:"ADMIN_KEY" secret:"true"`
+
+	// Open WebSocket connections, closed by CloseWebSockets
+	socketsMu     sync.Mutex
+	sockets       map[*webSocket]struct{}
+	socketsClosed bool
+
+	// Shutdown channels of the open event streams, closed by CloseStreams
+	streamsMu     sync.Mutex
+	streams       map[chan struct{}]struct{}
+	streamsClosed bool
 }
 
+// CreateChirpRequest is the body of a new chirp. Visibility defaults to
+// public, and a content warning marks the chirp as sensitive.
 type CreateChirpRequest struct {
-	Body   string    `json:"body"`
-	UserID uuid.UUID `json:"user_id"`
+	Body           string        `json:"body" validate:"required,max=140"`
+	UserID         uuid.UUID     `json:"user_id"`
+	MediaIDs       []uuid.UUID   `json:"media_ids" validate:"max=4"`
+	ReplyToID      uuid.NullUUID `json:"reply_to_id"`
+	Visibility     string        `json:"visibility" validate:"oneof=public unlisted followers mentioned"`
+	ContentWarning string        `json:"content_warning" validate:"max=100"`
+	Sensitive      bool          `json:"sensitive"`
+}
+
+// Problem is an RFC 7807 problem details object, the body of every error
+// response.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
+// FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
 }
 
 type CreateUserRequest struct {
-	Email          string `json:"email"`
-	HashedPassword string `json:"password"`
+	Email          string `json:"email" validate:"required,email,max=254"`
+	HashedPassword string `json:"password" validate:"required"`
 }
 
 type LoginUserRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
 type NewJWT struct {
@@ -40,11 +99,36 @@ type NewJWT struct {
 }
 
 type UpdateUserRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UpdateProfileRequest holds the profile fields to update; absent fields keep
+// their values.
+type UpdateProfileRequest struct {
+	Username      *string      `json:"username" validate:"username"`
+	DisplayName   *string      `json:"display_name" validate:"max=50"`
+	Bio           *string      `json:"bio" validate:"max=160"`
+	Location      *string      `json:"location" validate:"max=30"`
+	Website       *string      `json:"website" validate:"url,max=2048"`
+	AvatarMediaID OptionalUUID `json:"avatar_media_id"`
+	IsProtected   *bool        `json:"is_protected"`
+}
+
+// OptionalUUID is a nullable UUID field of a request body that tells an absent
+// field from a null one: Set is true if the field was present.
+type OptionalUUID struct {
+	uuid.NullUUID
+	Set bool
+}
+
+func (o *OptionalUUID) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return o.NullUUID.UnmarshalJSON(data)
 }
 
 type StripeEvent struct {
+	ID    string `json:"id,omitempty"`
 	Event string `json:"event"`
 	Data  struct {
 		UserID uuid.UUID `json:"user_id"`
@@ -61,10 +145,221 @@ type MappedUser struct {
 	RefreshToken string    `json:"refresh_token,omitempty"`
 }
 
+type MappedProfile struct {
+	ID             uuid.UUID    `json:"id"`
+	CreatedAt      time.Time    `json:"created_at"`
+	Username       *string      `json:"username"`
+	DisplayName    string       `json:"display_name"`
+	Bio            string       `json:"bio"`
+	Location       string       `json:"location"`
+	Website        string       `json:"website"`
+	Avatar         *MappedMedia `json:"avatar"`
+	IsChirpyRed    bool         `json:"is_chirpy_red"`
+	IsProtected    bool         `json:"is_protected"`
+	ChirpCount     int64        `json:"chirp_count"`
+	FollowerCount  int64        `json:"follower_count"`
+	FollowingCount int64        `json:"following_count"`
+}
+
+// MappedChirp is a chirp. Clients should collapse sensitive chirps behind their
+// content warning, if any.
 type MappedChirp struct {
+	ID             uuid.UUID     `json:"id"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	Body           string        `json:"body"`
+	UserID         uuid.UUID     `json:"user_id"`
+	ReplyToID      *uuid.UUID    `json:"reply_to_id"`
+	Visibility     string        `json:"visibility"`
+	ContentWarning string        `json:"content_warning"`
+	Sensitive      bool          `json:"sensitive"`
+	Media          []MappedMedia `json:"media,omitempty"`
+}
+
+type MappedMedia struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+type MappedWebhookEvent struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+type CreateWebhookSubscriptionRequest struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Events []string `json:"events" validate:"required,oneof=chirp.created chirp.deleted user.upgraded"`
+}
+
+type MappedWebhookSubscription struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+type MappedWebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	ResponseStatus int32           `json:"response_status,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// FollowEvent is the data of the real-time events about follows.
+type FollowEvent struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	FolloweeID uuid.UUID `json:"followee_id"`
+}
+
+// RelationEvent is the data of the real-time events about blocks and mutes:
+// UserID blocked, unblocked, muted or unmuted OtherID.
+type RelationEvent struct {
+	UserID  uuid.UUID `json:"user_id"`
+	OtherID uuid.UUID `json:"other_id"`
+}
+
+// NotificationEvent is the data of the real-time event of a notification
+// created or joined by another actor.
+type NotificationEvent struct {
+	NotificationID uuid.UUID  `json:"notification_id"`
+	Type           string     `json:"type"`
+	ActorID        uuid.UUID  `json:"actor_id"`
+	ChirpID        *uuid.UUID `json:"chirp_id"`
+}
+
+// MappedNotification is a notification of one or more actors doing the same
+// thing: mentioning, following, liking or replying to the user. Actors has the
+// most recent of them and ActorCount counts all of them.
+type MappedNotification struct {
+	ID         uuid.UUID     `json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Type       string        `json:"type"`
+	ChirpID    *uuid.UUID    `json:"chirp_id"`
+	Read       bool          `json:"read"`
+	ReadAt     *time.Time    `json:"read_at"`
+	Actors     []MappedActor `json:"actors"`
+	ActorCount int64         `json:"actor_count"`
+	Summary    string        `json:"summary"`
+}
+
+// MappedActor is a user who caused a notification.
+type MappedActor struct {
+	ID          uuid.UUID `json:"id"`
+	Username    *string   `json:"username"`
+	DisplayName string    `json:"display_name"`
+}
+
+// MappedRelatedUser is a user the authenticated user blocked or muted, or who
+// asked to follow them, and since when.
+type MappedRelatedUser struct {
+	ID          uuid.UUID `json:"id"`
+	Username    *string   `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Since       time.Time `json:"since"`
+}
+
+// NotificationPage is a page of notifications, newest first. NextCursor
+// fetches the next page and is empty on the last one.
+type NotificationPage struct {
+	Notifications []MappedNotification `json:"notifications"`
+	NextCursor    string               `json:"next_cursor,omitempty"`
+}
+
+type UnreadCountResponse struct {
+	UnreadCount int64 `json:"unread_count"`
+}
+
+type CreateConversationRequest struct {
+	MemberIDs []uuid.UUID `json:"member_ids" validate:"required,max=9"`
+}
+
+type SendMessageRequest struct {
+	Body string `json:"body" validate:"required,max=140"`
+}
+
+// MappedConversation is a direct-message conversation. Direct conversations
+// have two members; group conversations up to ten. UnreadCount counts the
+// messages of the other members the authenticated user has not read.
+type MappedConversation struct {
+	ID          uuid.UUID      `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	Direct      bool           `json:"direct"`
+	Members     []MappedMember `json:"members"`
+	UnreadCount int64          `json:"unread_count"`
+}
+
+// MappedMember is a member of a conversation. LastReadAt is the creation time
+// of the latest message they read.
+type MappedMember struct {
+	ID          uuid.UUID  `json:"id"`
+	Username    *string    `json:"username"`
+	DisplayName string     `json:"display_name"`
+	LastReadAt  *time.Time `json:"last_read_at"`
+}
+
+// MappedMessage is a direct message. ReadBy lists the other members who have
+// read it.
+type MappedMessage struct {
+	ID             uuid.UUID   `json:"id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	SenderID       uuid.UUID   `json:"sender_id"`
+	Body           string      `json:"body"`
+	ReadBy         []uuid.UUID `json:"read_by"`
+}
+
+// MessagePage is a page of messages, newest first. NextCursor fetches the next
+// page and is empty on the last one.
+type MessagePage struct {
+	Messages   []MappedMessage `json:"messages"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// WebSocketClientMessage is a message sent by a WebSocket client: "subscribe"
+// or "unsubscribe" with a channel, "auth" with a new access token, or "ping".
+type WebSocketClientMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// WebSocketServerMessage is a message sent to a WebSocket client. Events have
+// the channel, event type, ID and data of the event; errors have a code and a
+// message.
+type WebSocketServerMessage struct {
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	ID        uint64          `json:"id,omitempty,string"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Code      string          `json:"code,omitempty"`
+	Message   string          `json:"message,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// ErrorCode is a stable, machine-readable identifier of an error. Clients
+// should branch on the code rather than on the human-readable detail, which may
+// change.
+type ErrorCode string
+
+// Error codes returned in the "code" member of problem responses.
+const (
+	CodeInvalidBody        ErrorCode = "invalid_body"
+	CodeBodyTooLarge       ErrorCode = "body_too_large"
+	CodeInvalidParameter   ErrorCode = "invalid_parameter"
+	CodeValidationFailed   ErrorCode = "validation_failed"
+	CodeMissingCredentials ErrorCode = "missing_credentials"
+	CodeInvalidCredentials ErrorCode = "invalid_credentials"
+	CodeInvalidToken       ErrorCode = "invalid_token"
+	CodeTokenExpired       ErrorCode = "token_expired"
+	CodeInvalidAPIKey      ErrorCode = "invalid_api_key"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeAdminDisabled      ErrorCode = "admin_disabled"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeConflict           ErrorCode = "conflict"
+	CodeInternal           ErrorCode = "internal_error"
+)
+
+// errorStatus maps every error code to the HTTP status code it is returned with.
+var errorStatus = map[ErrorCode]int{
+	CodeInvalidBody:        http.StatusBadRequest,
+	CodeBodyTooLarge:       http.StatusRequestEntityTooLarge,
+	CodeInvalidParameter:   http.StatusBadRequest,
+	CodeValidationFailed:   http.StatusBadRequest,
+	CodeMissingCredentials: http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeInvalidToken:       http.StatusUnauthorized,
+	CodeTokenExpired:       http.StatusUnauthorized,
+	CodeInvalidAPIKey:      http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeAdminDisabled:      http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeConflict:           http.StatusConflict,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status code the error code is returned with.
+func (c ErrorCode) Status() int {
+	if status, ok := errorStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// problemTypePrefix is prepended to the error code to form the problem type URI.
+const problemTypePrefix = "urn:chirpy:problem:"
+
+// problemContentType is the media type of problem responses (RFC 7807).
+const problemContentType = "application/problem+json"
+
+// respondWithError writes an RFC 7807 problem response for the given error code,
+// with the code's status and the given client-facing detail. Any field errors
+// are listed in the response's "errors" member. The underlying cause, if any,
+// is never sent to the client; it is attached to the request's access log entry
+// instead, or logged directly when the request is not going through
+// MiddlewareLogging.
+func respondWithError(w http.ResponseWriter, r *http.Request, code ErrorCode, detail string, cause error, fields ...FieldError) {
+	status := code.Status()
+	if cause != nil {
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			info.Err = cause
+			info.ErrMessage = detail
+		} else {
+			slog.ErrorContext(r.Context(), detail, "status", status, "error", cause)
+		}
+	}
+
+	problem := Problem{
+		Type:      problemTypePrefix + string(code),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestID(r.Context()),
+		Errors:    fields,
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
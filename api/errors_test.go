@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRespondWithError(t *testing.T) {
+	cfg := &ApiConfig{}
+	handler := cfg.MiddlewareRequestID(cfg.MiddlewareLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithError(w, r, CodeValidationFailed, "Chirp is too long", errors.New("internal cause"), FieldError{
+			Field:   "body",
+			Code:    "too_long",
+			Message: "must be at most 140 characters long",
+		})
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", nil)
+	req.Header.Set("X-Request-ID", "test-request")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("Expected problem+json content type, got %q", got)
+	}
+
+	var problem Problem
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	want := Problem{
+		Type:      "urn:chirpy:problem:validation_failed",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "Chirp is too long",
+		Instance:  "/api/chirps",
+		Code:      CodeValidationFailed,
+		RequestID: "test-request",
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "body" || problem.Errors[0].Code != "too_long" {
+		t.Errorf("Expected one field error for body, got %+v", problem.Errors)
+	}
+	problem.Errors = nil
+	if !reflect.DeepEqual(problem, want) {
+		t.Errorf("Expected %+v, got %+v", want, problem)
+	}
+}
+
+func TestErrorCodeStatus(t *testing.T) {
+	for code := range errorStatus {
+		if code.Status() < 400 {
+			t.Errorf("Expected an error status for %s, got %d", code, code.Status())
+		}
+	}
+	if got := ErrorCode("unknown").Status(); got != http.StatusInternalServerError {
+		t.Errorf("Expected unknown codes to map to 500, got %d", got)
+	}
+}
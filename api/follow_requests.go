@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// HandleListFollowRequests responds with the users who asked to follow the
+// authenticated user, most recent request first.
+func (cfg *ApiConfig) HandleListFollowRequests(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.ListFollowRequests(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get follow requests", err)
+		return
+	}
+	users := make([]MappedRelatedUser, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, mapRelatedUser(row.ID, row.Username, row.DisplayName, row.CreatedAt))
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// HandleApproveFollowRequest approves the request of the user with the ID in
+// the path to follow the authenticated user: they become a follower and are
+// notified. It responds with a 204 status code, or a 404 status code if there
+// is no such request.
+func (cfg *ApiConfig) HandleApproveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	requesterID, ok := pathUUID(w, r, "userID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.ApproveFollowRequest(r.Context(), database.ApproveFollowRequestParams{
+		RequesterID: requesterID,
+		TargetID:    userID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to approve follow request", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "This user has not asked to follow you", nil)
+		return
+	}
+	cfg.followApproved(r.Context(), requesterID, userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeclineFollowRequest declines the request of the user with the ID in
+// the path to follow the authenticated user, without telling them. It responds
+// with a 204 status code, or a 404 status code if there is no such request.
+func (cfg *ApiConfig) HandleDeclineFollowRequest(w http.ResponseWriter, r *http.Request) {
+	requesterID, ok := pathUUID(w, r, "userID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.DeleteFollowRequest(r.Context(), database.DeleteFollowRequestParams{
+		RequesterID: requesterID,
+		TargetID:    userID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to decline follow request", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "This user has not asked to follow you", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// approveAllFollowRequests turns every pending request to follow the user into
+// a follow, as when they stop being protected. Failures are only logged, and
+// the requests stay pending.
+func (cfg *ApiConfig) approveAllFollowRequests(ctx context.Context, userID uuid.UUID) {
+	followerIDs, err := cfg.DbQueries.ApproveAllFollowRequests(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to approve follow requests", "user_id", userID, "error", err)
+		return
+	}
+	for _, followerID := range followerIDs {
+		cfg.followApproved(ctx, followerID, userID)
+	}
+}
+
+// followApproved tells the real-time APIs about a follow that was approved and
+// notifies the new follower.
+func (cfg *ApiConfig) followApproved(ctx context.Context, followerID, followeeID uuid.UUID) {
+	cfg.publishFollow(ctx, stream.EventUserFollowed, followerID, followeeID)
+	cfg.notify(ctx, followerID, followeeID, NotificationFollowAccepted, uuid.NullUUID{})
+}
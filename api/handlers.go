@@ -1,70 +1,112 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 
 	"github.com/Fepozopo/chirpy/internal/auth"
 	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/metrics"
+	"github.com/Fepozopo/chirpy/internal/migrations"
+	"github.com/Fepozopo/chirpy/internal/stream"
+	"github.com/Fepozopo/chirpy/internal/webhooks"
 )
 
-// HandleHealthz is a simple health-check endpoint that responds with a 200 OK and the
-// plain text string "OK" to indicate that the server is running.
+// HandleHealthz is the liveness check. It responds with a 200 OK and the plain text
+// string "OK" as long as the server is running, without touching the database, so
+// that a database outage does not get the process restarted.
 func (cfg *ApiConfig) HandleHealthz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK\n"))
 }
 
+// HandleReadyz is the readiness check. It responds with a 200 OK once the database
+// is reachable and has every migration embedded in this build applied, and with a
+// 503 Service Unavailable otherwise. The body reports the result of every check
+// as a fixed string, since the endpoint is public; the causes are only logged.
+func (cfg *ApiConfig) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	response := ReadinessResponse{
+		Status: "ok",
+		Checks: map[string]string{},
+	}
+
+	if err := cfg.DB.PingContext(ctx); err != nil {
+		slog.ErrorContext(r.Context(), "Readiness check failed to reach the database", "error", err)
+		response.Status = "unavailable"
+		response.Checks["database"] = "unavailable"
+		response.Checks["migrations"] = "skipped"
+	} else {
+		response.Checks["database"] = "ok"
+		if err := cfg.Migrations.Check(ctx); err != nil {
+			slog.ErrorContext(r.Context(), "Readiness check failed to check the migrations", "error", err)
+			response.Status = "unavailable"
+			response.Checks["migrations"] = "unavailable"
+			if errors.Is(err, migrations.ErrSchemaBehind) {
+				response.Checks["migrations"] = "behind"
+			}
+		} else {
+			response.Checks["migrations"] = "ok"
+		}
+	}
+
+	if response.Status != "ok" {
+		respondWithJSON(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
 // HandleMetrics responds with a simple HTML page displaying the current value of the
-// file server hit counter.
+// file server hit counter and the number of chirps created, read from the same
+// registry that is served in the Prometheus format at /metrics.
 func (cfg *ApiConfig) HandleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	hits := cfg.fileserverHits.Load()
+	var hits, chirps float64
+	if cfg.Metrics != nil {
+		hits = metrics.Value(cfg.Metrics.FileserverHits)
+		chirps = metrics.Value(cfg.Metrics.ChirpsCreated)
+	}
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "<html>\n<body>\n<h1>Welcome, Chirpy Admin</h1>\n<p>Chirpy has been visited %d times!</p>\n</body>\n</html>\n", hits)
+	fmt.Fprintf(w, "<html>\n<body>\n<h1>Welcome, Chirpy Admin</h1>\n<p>Chirpy has been visited %.0f times!</p>\n<p>%.0f chirps have been created since the server started.</p>\n</body>\n</html>\n", hits, chirps)
 }
 
 // HandleReset is a special admin-only endpoint that can only be accessed in a local
-// development environment. It resets the server's hits counter to 0 and deletes all
-// users in the database. It responds with a 200 OK and a plaintext message
-// indicating that the hits counter has been reset to 0.
+// development environment. It deletes all users in the database and responds with
+// a 200 OK and a plaintext message. The metrics are monotonic and are not reset.
 func (cfg *ApiConfig) HandleReset(w http.ResponseWriter, r *http.Request) {
-	godotenv.Load()
-	platform := os.Getenv("PLATFORM")
-	apiCfg := ApiConfig{
-		Platform: platform,
-	}
 	// Ensure this endpoint can only be accessed in a local development environment
-	if apiCfg.Platform != "dev" {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("403 Forbidden\n"))
+	if cfg.Platform != "dev" {
+		respondWithError(w, r, CodeForbidden, "Reset is only available on the dev platform", nil)
 		return
 	}
 
 	// Delete all users in the database
 	err := cfg.DbQueries.DeleteAllUsers(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed delete users"})
+		respondWithError(w, r, CodeInternal, "Failed delete users", err)
 		return
 	}
 
-	// Reset the server hits count to 0
-	cfg.fileserverHits.Store(0)
-
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Hits reset to 0 and all users deleted\n"))
+	w.Write([]byte("All users deleted\n"))
 }
 
 // HandleCreateChirp processes a request to create a new chirp. It parses the request
@@ -76,66 +118,134 @@ func (cfg *ApiConfig) HandleReset(w http.ResponseWriter, r *http.Request) {
 func (cfg *ApiConfig) HandleCreateChirp(w http.ResponseWriter, r *http.Request) {
 	// Parse the JSON body of the request into a CreateChirpRequest struct
 	var createChirpRequest CreateChirpRequest
-	if err := json.NewDecoder(r.Body).Decode(&createChirpRequest); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+	if !decodeRequest(w, r, &createChirpRequest) {
 		return
 	}
 
-	// Get the Bearer token from the request headers
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid authorization header"})
-		return
-	}
-
-	// Validate the JWT
-	userID, err := auth.ValidateJWT(token, cfg.TokenSecret)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid JWT"})
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
 
 	// Set the user ID in the request body
 	createChirpRequest.UserID = userID
 
-	// Check if the chirp exceeds the 140 character limit
-	if len(createChirpRequest.Body) > 140 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Chirp is too long"})
-		return
+	// Replace profane words with ****
+	createChirpRequest.Body = censorProfanity(createChirpRequest.Body)
+
+	// Check the attached media before creating the chirp
+	mediaIDs, ok := cfg.checkAttachableMedia(w, r, userID, createChirpRequest.MediaIDs)
+	if !ok {
+		return
+	}
+
+	// A reply must answer an existing chirp the user may see
+	var parent database.Chirp
+	if createChirpRequest.ReplyToID.Valid {
+		var err error
+		parent, err = cfg.DbQueries.GetVisibleChirp(r.Context(), database.GetVisibleChirpParams{
+			ID:       createChirpRequest.ReplyToID.UUID,
+			ViewerID: uuid.NullUUID{UUID: userID, Valid: true},
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, CodeValidationFailed, "Request body is invalid", nil, FieldError{
+				Field:   "reply_to_id",
+				Code:    "invalid_chirp",
+				Message: "must be the ID of an existing chirp",
+			})
+			return
+		}
+		if err != nil {
+			respondWithError(w, r, CodeInternal, "Failed to get chirp", err)
+			return
+		}
+		if parent.UserID != userID && !cfg.checkNotBlocked(w, r, userID, []uuid.UUID{parent.UserID}, "You cannot reply to a user you blocked or who blocked you") {
+			return
+		}
 	}
 
-	// Define the list of profane words to replace
-	profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
-
-	// Replace profane words with **** in a case-insensitive manner
-	cleanedBody := createChirpRequest.Body
-	caser := cases.Title(language.English)
-	for _, word := range profaneWords {
-		cleanedBody = strings.ReplaceAll(cleanedBody, word, "****")
-		cleanedBody = strings.ReplaceAll(cleanedBody, caser.String(word), "****")
-		cleanedBody = strings.ReplaceAll(cleanedBody, strings.ToUpper(word), "****")
+	// The chirps of protected users only reach their followers
+	author, err := cfg.DbQueries.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get user", err)
+		return
 	}
-	createChirpRequest.Body = cleanedBody
 
 	// If the chirp is valid, save it in the database
-	chirp, err := cfg.DbQueries.CreateChirp(r.Context(), database.CreateChirpParams(createChirpRequest))
+	visibility := createChirpRequest.Visibility
+	if visibility == "" {
+		visibility = stream.VisibilityPublic
+	}
+	chirp, err := cfg.DbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:           createChirpRequest.Body,
+		UserID:         createChirpRequest.UserID,
+		ReplyToID:      createChirpRequest.ReplyToID,
+		Visibility:     visibility,
+		ContentWarning: createChirpRequest.ContentWarning,
+		Sensitive:      createChirpRequest.Sensitive || createChirpRequest.ContentWarning != "",
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to create chirp"})
+		respondWithError(w, r, CodeInternal, "Failed to create chirp", err)
 		return
 	}
 
+	// Attach the media, which fails if another chirp took them in the meantime
+	var attached []MappedMedia
+	if len(mediaIDs) > 0 {
+		rows, err := cfg.DbQueries.AttachMedia(r.Context(), database.AttachMediaParams{
+			ChirpID: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+			Ids:     mediaIDs,
+			UserID:  userID,
+		})
+		if err != nil || rows != int64(len(mediaIDs)) {
+			if deleteErr := cfg.DbQueries.DeleteChirp(r.Context(), chirp.ID); deleteErr != nil {
+				slog.ErrorContext(r.Context(), "Failed to delete chirp without its media", "chirp_id", chirp.ID, "error", deleteErr)
+			}
+			if err != nil {
+				respondWithError(w, r, CodeInternal, "Failed to attach media", err)
+			} else {
+				respondWithError(w, r, CodeConflict, "Media were attached to another chirp", nil)
+			}
+			return
+		}
+		media, err := cfg.chirpMedia(r.Context(), chirp.ID)
+		if err != nil {
+			respondWithError(w, r, CodeInternal, "Failed to look up media", err)
+			return
+		}
+		attached = media[chirp.ID]
+	}
+
 	// Map the chirp struct to a MappedChirp struct to control the JSON keys
-	mappedChirp := MappedChirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
+	mappedChirp := mapChirp(chirp, attached)
+
+	// The mentioned users may see the chirp whatever its visibility. Failing
+	// to record them only hides chirps that are not public from them.
+	mentioned := cfg.mentionedUsers(r.Context(), mappedChirp)
+	if len(mentioned) > 0 {
+		err = cfg.DbQueries.CreateChirpMentions(r.Context(), database.CreateChirpMentionsParams{
+			ChirpID: chirp.ID,
+			UserIds: mentioned,
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record mentions", "chirp_id", chirp.ID, "error", err)
+		}
+	}
+
+	cfg.Metrics.ChirpCreated()
+	cfg.emitWebhook(r.Context(), webhooks.EventChirpCreated, chirpWebhookOwner(mappedChirp, author), mappedChirp)
+	cfg.publishChirp(r.Context(), stream.EventChirpCreated, mappedChirp, author, mentioned)
+
+	// Tell the author of the parent about the reply, if they may see it, and
+	// everyone else about the mention
+	chirpID := uuid.NullUUID{UUID: chirp.ID, Valid: true}
+	if chirp.ReplyToID.Valid && cfg.chirpVisibleTo(r.Context(), chirp.ID, parent.UserID) {
+		cfg.notify(r.Context(), parent.UserID, userID, NotificationReply, chirp.ReplyToID)
+	}
+	for _, mentionedID := range mentioned {
+		if !chirp.ReplyToID.Valid || mentionedID != parent.UserID {
+			cfg.notify(r.Context(), mentionedID, userID, NotificationMention, chirpID)
+		}
 	}
 
 	// If creating the record goes well, respond with a 201 status code and the full chirp resource
@@ -144,21 +254,68 @@ func (cfg *ApiConfig) HandleCreateChirp(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(mappedChirp)
 }
 
+// mapChirp maps a chirp and its media to a MappedChirp to control the JSON keys.
+func mapChirp(chirp database.Chirp, media []MappedMedia) MappedChirp {
+	return MappedChirp{
+		ID:             chirp.ID,
+		CreatedAt:      chirp.CreatedAt,
+		UpdatedAt:      chirp.UpdatedAt,
+		Body:           chirp.Body,
+		UserID:         chirp.UserID,
+		ReplyToID:      nullUUIDPtr(chirp.ReplyToID),
+		Visibility:     chirp.Visibility,
+		ContentWarning: chirp.ContentWarning,
+		Sensitive:      chirp.Sensitive,
+		Media:          media,
+	}
+}
+
+// chirpVisibleTo reports whether the user may see the chirp. Lookup failures
+// are logged and count as not.
+func (cfg *ApiConfig) chirpVisibleTo(ctx context.Context, chirpID, userID uuid.UUID) bool {
+	_, err := cfg.DbQueries.GetVisibleChirp(ctx, database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, "Failed to check who may see a chirp", "chirp_id", chirpID, "error", err)
+	}
+	return err == nil
+}
+
+// profaneWords are the words censorProfanity replaces.
+var profaneWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// censorProfanity replaces the profane words in the body of a chirp or message
+// with ****, whether they are written in lower case, title case or upper case.
+func censorProfanity(body string) string {
+	caser := cases.Title(language.English)
+	for _, word := range profaneWords {
+		body = strings.ReplaceAll(body, word, "****")
+		body = strings.ReplaceAll(body, caser.String(word), "****")
+		body = strings.ReplaceAll(body, strings.ToUpper(word), "****")
+	}
+	return body
+}
+
 // HandleCreateUser creates a new user from the email address in the request body
-// and returns the user's ID, email, and timestamps in the response body.
+// and returns the user's ID, email, and timestamps in the response body. The
+// password must meet the password policy, otherwise it responds with a 400
+// status code explaining which rule it breaks.
 func (cfg *ApiConfig) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	// Parse the JSON body of the request into a CreateUserRequest struct
 	var createUserRequest CreateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&createUserRequest); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+	if !decodeRequest(w, r, &createUserRequest) {
+		return
+	}
+	if !cfg.checkPassword(w, r, createUserRequest.HashedPassword, createUserRequest.Email) {
 		return
 	}
 
 	// Hash the provided password
-	hashedPassword, err := auth.HashPassword(createUserRequest.HashedPassword)
+	hashedPassword, err := cfg.PasswordHasher.Hash(r.Context(), createUserRequest.HashedPassword)
 	if err != nil {
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to hash provided password"})
+		respondWithError(w, r, CodeInternal, "Failed to hash provided password", err)
 		return
 	}
 	createUserRequest.HashedPassword = hashedPassword
@@ -166,8 +323,7 @@ func (cfg *ApiConfig) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	// Create a new user in the database
 	user, err := cfg.DbQueries.CreateUser(r.Context(), database.CreateUserParams(createUserRequest))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to create user"})
+		respondWithError(w, r, CodeInternal, "Failed to create user", err)
 		return
 	}
 
@@ -186,70 +342,109 @@ func (cfg *ApiConfig) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(mappedUser)
 }
 
+// maxChirpsLimit is the largest page of chirps that can be requested.
+const maxChirpsLimit = 1000
+
 // HandleGetAllChirps retrieves all chirps from the database and returns them as a
 // JSON object in the response. The query parameter "author_id" can be used to
 // retrieve all chirps for the given author. The query parameter "sort" can be used
-// to sort the chirps in ascending or descending order of their creation date.
-// If there is an error retrieving the chirps, it responds with an appropriate error
-// status and message. If the chirps are successfully retrieved, it responds with a
-// 200 OK status and a valid JSON response.
+// to sort the chirps in ascending ("asc", the default) or descending ("desc")
+// order of their creation date, and "limit" and "offset" to return one page of
+// them; without a limit, every chirp is returned. With an access token, the
+// chirps of users the caller blocked or muted, or who blocked them, are left
+// out. Invalid query parameters are rejected with a 400 status code. If there
+// is an error retrieving the chirps, it responds with an appropriate error
+// status and message. If the chirps are successfully retrieved, it responds
+// with a 200 OK status and a valid JSON response.
 func (cfg *ApiConfig) HandleGetAllChirps(w http.ResponseWriter, r *http.Request) {
-
 	// Check if the author_id and/or the sort query parameter is provided
-	authorID := r.URL.Query().Get("author_id")
-	sort := r.URL.Query().Get("sort")
+	authorID, ok := queryUUID(w, r, "author_id")
+	if !ok {
+		return
+	}
+	sort, ok := queryEnum(w, r, "sort", "asc", "desc")
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", 1, maxChirpsLimit)
+	if !ok {
+		return
+	}
+	offset, ok := queryInt(w, r, "offset", 0, math.MaxInt32)
+	if !ok {
+		return
+	}
+	maxResults := sql.NullInt32{Int32: int32(limit.Int64), Valid: limit.Valid}
+	skip := int32(offset.Int64)
+
+	// Logged in users do not see the chirps of the users they blocked or
+	// muted, or who blocked them
+	viewerID, ok := cfg.optionalViewer(w, r)
+	if !ok {
+		return
+	}
 
 	var chirps []database.Chirp
 	var err error
 	if sort == "desc" {
-		if authorID != "" {
+		if authorID.Valid {
 			// Get all chirps for the given author
-			chirps, err = cfg.DbQueries.GetUserChirpsDESC(r.Context(), uuid.MustParse(authorID))
+			chirps, err = cfg.DbQueries.GetUserChirpsDESC(r.Context(), database.GetUserChirpsDESCParams{
+				UserID:     authorID.UUID,
+				ViewerID:   viewerID,
+				Skip:       skip,
+				MaxResults: maxResults,
+			})
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to get chirps for author"})
+				respondWithError(w, r, CodeInternal, "Failed to get chirps for author", err)
 				return
 			}
 		} else {
 			// Get all chirps from the database
-			chirps, err = cfg.DbQueries.GetAllChirpsDESC(r.Context())
+			chirps, err = cfg.DbQueries.GetAllChirpsDESC(r.Context(), database.GetAllChirpsDESCParams{ViewerID: viewerID, Skip: skip, MaxResults: maxResults})
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to get all chirps"})
+				respondWithError(w, r, CodeInternal, "Failed to get all chirps", err)
 				return
 			}
 		}
 	} else {
-		if authorID != "" {
+		if authorID.Valid {
 			// Get all chirps for the given author
-			chirps, err = cfg.DbQueries.GetUserChirps(r.Context(), uuid.MustParse(authorID))
+			chirps, err = cfg.DbQueries.GetUserChirps(r.Context(), database.GetUserChirpsParams{
+				UserID:     authorID.UUID,
+				ViewerID:   viewerID,
+				Skip:       skip,
+				MaxResults: maxResults,
+			})
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to get chirps for author"})
+				respondWithError(w, r, CodeInternal, "Failed to get chirps for author", err)
 				return
 			}
 		} else {
 			// Get all chirps from the database
-			chirps, err = cfg.DbQueries.GetAllChirps(r.Context())
+			chirps, err = cfg.DbQueries.GetAllChirps(r.Context(), database.GetAllChirpsParams{ViewerID: viewerID, Skip: skip, MaxResults: maxResults})
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to get all chirps"})
+				respondWithError(w, r, CodeInternal, "Failed to get all chirps", err)
 				return
 			}
 		}
 	}
 
+	// Look up the media attached to the chirps in one query
+	chirpIDs := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+	}
+	attached, err := cfg.chirpMedia(r.Context(), chirpIDs...)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get chirp media", err)
+		return
+	}
+
 	// Map the chirps to the MappedChirp struct to control the JSON keys
 	var mappedChirps []MappedChirp
 	for _, chirp := range chirps {
-		mappedChirp := MappedChirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		}
-		mappedChirps = append(mappedChirps, mappedChirp)
+		mappedChirps = append(mappedChirps, mapChirp(chirp, attached[chirp.ID]))
 	}
 
 	// Respond with 200 OK and a valid response if successful
@@ -265,33 +460,40 @@ func (cfg *ApiConfig) HandleGetAllChirps(w http.ResponseWriter, r *http.Request)
 // as a JSON object in the response. It maps the database chirp record to the
 // MappedChirp struct to ensure consistent JSON keys. If the chirp is found,
 // it responds with a 200 OK status and a valid JSON response. If the chirp is
-// not found, it responds with a 404 status and an error message.
+// not found, or the caller may not see it because of its visibility, its
+// author being protected or a block between them, it responds with a 404
+// status and an error message.
 func (cfg *ApiConfig) HandleGetChirp(w http.ResponseWriter, r *http.Request) {
-	// Get the chirp ID from the path parameter and convert it to a UUID object
-	pathParameter := r.PathValue("chirpID")
-	chirpID, err := uuid.Parse(pathParameter)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid chirp ID"})
+	// Get the chirp ID from the path parameter
+	chirpID, ok := pathUUID(w, r, "chirpID")
+	if !ok {
+		return
+	}
+	viewerID, ok := cfg.optionalViewer(w, r)
+	if !ok {
 		return
 	}
 
 	// Get the requested chirp from the database
-	chirp, err := cfg.DbQueries.GetChirp(r.Context(), chirpID)
+	chirp, err := cfg.DbQueries.GetVisibleChirp(r.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: viewerID,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to find chirp with ID: " + pathParameter})
+		respondWithError(w, r, CodeNotFound, "Failed to find chirp with ID: "+chirpID.String(), err)
+		return
+	}
+	if !cfg.checkChirpNotBlocked(w, r, viewerID, chirp) {
+		return
+	}
+	attached, err := cfg.chirpMedia(r.Context(), chirp.ID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get chirp media", err)
 		return
 	}
 
 	// Map the chirp struct to a MappedChirp struct to control the JSON keys
-	mappedChirp := MappedChirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	}
+	mappedChirp := mapChirp(chirp, attached[chirp.ID])
 
 	// If the chirp is found, respond with a 200 OK code and the found chirp
 	w.Header().Set("Content-Type", "application/json")
@@ -311,32 +513,31 @@ func (cfg *ApiConfig) HandleGetChirp(w http.ResponseWriter, r *http.Request) {
 func (cfg *ApiConfig) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 	// Parse the JSON body of the request into a LoginUserRequest struct
 	var loginUserRequest LoginUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&loginUserRequest); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+	if !decodeRequest(w, r, &loginUserRequest) {
 		return
 	}
 
 	// Match the user email to an email in the database
 	user, err := cfg.DbQueries.GetUserByEmail(r.Context(), loginUserRequest.Email)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Incorrect email or password"})
+		cfg.Metrics.AuthFailure("invalid_credentials")
+		respondWithError(w, r, CodeInvalidCredentials, "Incorrect email or password", err)
 		return
 	}
 
 	// Check to see if their password matches the stored hash
-	if err := auth.CheckPasswordHash(loginUserRequest.Password, user.HashedPassword); err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Incorrect email or password"})
+	if err := cfg.PasswordHasher.Verify(r.Context(), loginUserRequest.Password, user.HashedPassword); err != nil {
+		cfg.Metrics.AuthFailure("invalid_credentials")
+		respondWithError(w, r, CodeInvalidCredentials, "Incorrect email or password", err)
 		return
 	}
+	setRequestUserID(r, user.ID)
+	cfg.upgradePasswordHash(r.Context(), user, loginUserRequest.Password)
 
 	// Set the expiration time for the access token (JWT) to 1 hour
 	token, err := auth.MakeJWT(user.ID, cfg.TokenSecret, 3600*time.Second)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to generate JWT"})
+		respondWithError(w, r, CodeInternal, "Failed to generate JWT", err)
 		return
 	}
 	w.Header().Set("Authorization", "Bearer "+token)
@@ -344,8 +545,7 @@ func (cfg *ApiConfig) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 	// Create a refresh token and insert it into the database
 	makeRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to generate refresh token"})
+		respondWithError(w, r, CodeInternal, "Failed to generate refresh token", err)
 		return
 	}
 
@@ -354,9 +554,8 @@ func (cfg *ApiConfig) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 		UserID: user.ID,
 	}
 
-	if cfg.DbQueries.CreateRefreshToken(r.Context(), createRefreshToken) != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to add refresh token to database"})
+	if err := cfg.DbQueries.CreateRefreshToken(r.Context(), createRefreshToken); err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to add refresh token to database", err)
 		return
 	}
 
@@ -377,6 +576,32 @@ func (cfg *ApiConfig) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(mappedUser)
 }
 
+// upgradePasswordHash replaces the user's password hash if it was created with
+// another algorithm or other parameters than the configured ones. The password
+// has just been verified, so it can be hashed again. Failures are logged rather
+// than failing the login, as the old hash keeps working.
+func (cfg *ApiConfig) upgradePasswordHash(ctx context.Context, user database.User, password string) {
+	if !cfg.PasswordHasher.NeedsRehash(user.HashedPassword) {
+		return
+	}
+
+	hashedPassword, err := cfg.PasswordHasher.Hash(ctx, password)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	// Only replace the hash that was verified, in case the password was changed
+	// in the meantime
+	if _, err := cfg.DbQueries.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		ID:      user.ID,
+		OldHash: user.HashedPassword,
+		NewHash: hashedPassword,
+	}); err != nil {
+		slog.ErrorContext(ctx, "Failed to store rehashed password", "user_id", user.ID, "error", err)
+	}
+}
+
 // HandleRefresh processes a request to refresh a user's access token using
 // their refresh token. It expects the refresh token to be provided in the
 // Authorization header of the request. If the token is missing or invalid,
@@ -387,28 +612,28 @@ func (cfg *ApiConfig) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 func (cfg *ApiConfig) HandleRefresh(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Missing refresh token"})
+		cfg.Metrics.AuthFailure("missing_token")
+		respondWithError(w, r, CodeMissingCredentials, "Missing refresh token", err)
 		return
 	}
 
 	user, err := cfg.DbQueries.GetUserFromRefreshToken(r.Context(), refreshToken)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid refresh token"})
+		cfg.Metrics.AuthFailure("invalid_refresh_token")
+		respondWithError(w, r, CodeInvalidToken, "Invalid refresh token", err)
 		return
 	}
 
 	if user.RevokedAt.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Refresh token is expired"})
+		cfg.Metrics.AuthFailure("invalid_refresh_token")
+		respondWithError(w, r, CodeTokenExpired, "Refresh token is expired", nil)
 		return
 	}
+	setRequestUserID(r, user.ID)
 
 	token, err := auth.MakeJWT(user.ID, cfg.TokenSecret, 3600*time.Second)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to generate JWT"})
+		respondWithError(w, r, CodeInternal, "Failed to generate JWT", err)
 		return
 	}
 
@@ -425,15 +650,14 @@ func (cfg *ApiConfig) HandleRefresh(w http.ResponseWriter, r *http.Request) {
 func (cfg *ApiConfig) HandleRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Missing refresh token"})
+		cfg.Metrics.AuthFailure("missing_token")
+		respondWithError(w, r, CodeMissingCredentials, "Missing refresh token", err)
 		return
 	}
 
 	err = cfg.DbQueries.RevokeRefreshToken(r.Context(), refreshToken)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to revoke refresh token"})
+		respondWithError(w, r, CodeInternal, "Failed to revoke refresh token", err)
 		return
 	}
 
@@ -444,37 +668,29 @@ func (cfg *ApiConfig) HandleRevoke(w http.ResponseWriter, r *http.Request) {
 // It expects the user to provide an access token in the Authorization header,
 // and the new email and password in the request body. If the access token is
 // missing or invalid, it responds with a 401 status code and an appropriate
-// error message. It hashes the new password and updates the user in the
+// error message. If the new password does not meet the password policy, it
+// responds with a 400 status code. It hashes the new password and updates the user in the
 // database with the new email and hashed password. If there is an error
 // storing the update, it responds with a 500 status code and an appropriate
 // error message. Otherwise, it responds with a 200 status code and the newly
 // updated User resource.
 func (cfg *ApiConfig) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Missing access token"})
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.TokenSecret)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid access token"})
+	var updateUserRequest UpdateUserRequest
+	if !decodeRequest(w, r, &updateUserRequest) {
 		return
 	}
-
-	var updateUserRequest UpdateUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateUserRequest); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+	if !cfg.checkPassword(w, r, updateUserRequest.Password, updateUserRequest.Email) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(updateUserRequest.Password)
+	hashedPassword, err := cfg.PasswordHasher.Hash(r.Context(), updateUserRequest.Password)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to hash password"})
+		respondWithError(w, r, CodeInternal, "Failed to hash password", err)
 		return
 	}
 
@@ -486,8 +702,7 @@ func (cfg *ApiConfig) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := cfg.DbQueries.UpdateUser(r.Context(), updateUserParams)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to update user"})
+		respondWithError(w, r, CodeInternal, "Failed to update user", err)
 		return
 	}
 
@@ -512,93 +727,119 @@ func (cfg *ApiConfig) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 // status and message. If the chirp is successfully deleted, it responds with a 204
 // No Content status.
 func (cfg *ApiConfig) HandleDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	// Get the chirp ID from the path parameter and convert it to a UUID object
-	pathParameter := r.PathValue("chirpID")
-	chirpID, err := uuid.Parse(pathParameter)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid chirp ID"})
+	// Get the chirp ID from the path parameter
+	chirpID, ok := pathUUID(w, r, "chirpID")
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid authorization header"})
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.TokenSecret)
+	chirp, err := cfg.DbQueries.GetChirp(r.Context(), chirpID)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid JWT"})
+		respondWithError(w, r, CodeNotFound, "Chirp not found", err)
 		return
 	}
 
-	chirp, err := cfg.DbQueries.GetChirp(r.Context(), chirpID)
+	if chirp.UserID != userID {
+		respondWithError(w, r, CodeForbidden, "You can only delete your own chirps", nil)
+		return
+	}
+
+	// The deletion of a protected user's chirp only reaches their followers
+	author, err := cfg.DbQueries.GetUser(r.Context(), userID)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Chirp not found"})
+		respondWithError(w, r, CodeInternal, "Failed to get user", err)
 		return
 	}
 
-	if chirp.UserID != userID {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "You can only delete your own chirps"})
+	// The media rows and mentions are deleted along with the chirp, so look
+	// them up first
+	media, err := cfg.DbQueries.ListChirpMedia(r.Context(), []uuid.UUID{chirpID})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get chirp media", err)
+		return
+	}
+	mentioned, err := cfg.DbQueries.ListChirpMentions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get chirp mentions", err)
 		return
 	}
 
 	err = cfg.DbQueries.DeleteChirp(r.Context(), chirpID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to delete chirp"})
+		respondWithError(w, r, CodeInternal, "Failed to delete chirp", err)
 		return
 	}
+	cfg.deleteMediaBlobs(r.Context(), media)
+
+	owner, deletedChirp := ChirpDeletedWebhook(chirp, author)
+	cfg.emitWebhook(r.Context(), webhooks.EventChirpDeleted, owner, deletedChirp)
+	cfg.publishChirp(r.Context(), stream.EventChirpDeleted, deletedChirp, author, mentioned)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// HandleStripeEvent handles a Stripe event and upgrades the corresponding user
-// to a Chirpy Red subscription if the event is user.upgraded. It expects the
-// event to be passed in the request body as a StripeEvent struct, and expects
-// the Stripe API key to be passed in the Authorization header. If the event
-// is invalid, it responds with a 400 status code and an appropriate error
-// message. If the API key is invalid, it responds with a 403 status code and
-// an appropriate error message. If the user is not found, it responds with a
-// 404 status code and an appropriate error message. If the event is not
-// user.upgraded, it responds with a 204 status code. Otherwise, it upgrades
-// the user and responds with a 204 status code.
+// HandleStripeEvent handles a Polka webhook event. It expects the Polka API key
+// in the Authorization header and the event in the request body as a StripeEvent
+// struct. Every authenticated event is recorded in the webhook_events inbox keyed
+// by its provider event ID, so a retried delivery of an event that was already
+// processed is acknowledged without being applied a second time. If the API key
+// is invalid, it responds with a 401 status code. If the body is invalid, it
+// responds with a 400 status code. If the user is not found, the event is marked
+// as failed and it responds with a 404 status code. Otherwise it responds with a
+// 204 status code.
 func (cfg *ApiConfig) HandleStripeEvent(w http.ResponseWriter, r *http.Request) {
-	var stripeEvent StripeEvent
-	err := json.NewDecoder(r.Body).Decode(&stripeEvent)
+	apiKey, err := auth.GetAPIKey(r.Header)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		cfg.Metrics.AuthFailure("invalid_api_key")
+		respondWithError(w, r, CodeMissingCredentials, "Authorization header is invalid", err)
 		return
 	}
 
-	if stripeEvent.Event != "user.upgraded" {
-		w.WriteHeader(http.StatusNoContent)
+	if apiKey != cfg.StripeKey {
+		cfg.Metrics.AuthFailure("invalid_api_key")
+		respondWithError(w, r, CodeInvalidAPIKey, "Invalid API key", nil)
 		return
 	}
 
-	apiKey, err := auth.GetAPIKey(r.Header)
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Authorization header is invalid"})
+		respondWithDecodeError(w, r, err)
 		return
 	}
 
-	if apiKey != cfg.StripeKey {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid API key"})
+	var stripeEvent StripeEvent
+	if err := json.Unmarshal(payload, &stripeEvent); err != nil {
+		respondWithError(w, r, CodeInvalidBody, "Invalid request body", err)
+		return
+	}
+
+	// Record the event in the inbox, or load the existing record if this is a retry
+	event, err := cfg.recordWebhookEvent(r.Context(), webhookProviderPolka, webhookEventID(r.Header, stripeEvent, payload), stripeEvent.Event, payload)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to record event", err)
 		return
 	}
 
-	err = cfg.DbQueries.UpgradeUserToChirpyRed(r.Context(), stripeEvent.Data.UserID)
+	// Claim the event for processing. If it cannot be claimed it has already been
+	// processed (or is being processed by a concurrent delivery), so acknowledge it.
+	// An event whose processing was cut short is claimed again after its lease.
+	event, err = cfg.claimWebhookEvent(r.Context(), event.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to find user"})
+		respondWithError(w, r, CodeInternal, "Failed to claim event", err)
+		return
+	}
+
+	if code, err := cfg.processWebhookEvent(r.Context(), event); err != nil {
+		respondWithError(w, r, code, err.Error(), err)
 		return
 	}
 
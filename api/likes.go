@@ -0,0 +1,87 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+)
+
+// HandleLikeChirp makes the authenticated user like the chirp with the ID in
+// the path and notifies its author. Liking a chirp twice has no further
+// effect. It responds with a 204 status code, or a 404 status code if there is
+// no such chirp, it belongs to a protected user they do not follow or either
+// of them blocked the other.
+func (cfg *ApiConfig) HandleLikeChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, ok := pathUUID(w, r, "chirpID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	viewerID := uuid.NullUUID{UUID: userID, Valid: true}
+	chirp, err := cfg.DbQueries.GetVisibleChirp(r.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: viewerID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, CodeNotFound, "Failed to find chirp with ID: "+chirpID.String(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get chirp", err)
+		return
+	}
+	if !cfg.checkChirpNotBlocked(w, r, viewerID, chirp) {
+		return
+	}
+
+	rows, err := cfg.DbQueries.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to like chirp", err)
+		return
+	}
+	if rows > 0 {
+		cfg.notify(r.Context(), chirp.UserID, userID, NotificationLike, uuid.NullUUID{UUID: chirpID, Valid: true})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUnlikeChirp makes the authenticated user stop liking the chirp with the
+// ID in the path. It responds with a 204 status code, or a 404 status code if
+// they did not like the chirp.
+func (cfg *ApiConfig) HandleUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, ok := pathUUID(w, r, "chirpID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to unlike chirp", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "You do not like this chirp", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
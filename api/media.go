@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/blobstore"
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/media"
+)
+
+// Defaults of the media settings, used when they are not configured.
+const (
+	defaultMediaMaxBytes = 10 << 20
+	defaultThumbnailSize = 320
+)
+
+// multipartOverhead is the room left in an upload's body for the multipart
+// boundaries and headers around the file.
+const multipartOverhead = 64 << 10
+
+// mediaCacheControl lets clients and proxies cache media that everyone may see
+// forever, as the content stored under a media ID never changes. Other media
+// must not be stored by shared caches, which would serve them to anyone.
+const (
+	mediaCacheControl        = "public, max-age=31536000, immutable"
+	privateMediaCacheControl = "private, no-store"
+)
+
+// mapMedia maps an uploaded image to a MappedMedia to control the JSON keys.
+func mapMedia(m database.Media) MappedMedia {
+	return MappedMedia{
+		ID:           m.ID,
+		CreatedAt:    m.CreatedAt,
+		ContentType:  m.ContentType,
+		Size:         m.Size,
+		Width:        m.Width,
+		Height:       m.Height,
+		URL:          "/api/media/" + m.ID.String(),
+		ThumbnailURL: "/api/media/" + m.ID.String() + "/thumbnail",
+	}
+}
+
+// chirpMedia returns the media attached to each of the chirps.
+func (cfg *ApiConfig) chirpMedia(ctx context.Context, chirpIDs ...uuid.UUID) (map[uuid.UUID][]MappedMedia, error) {
+	attached := map[uuid.UUID][]MappedMedia{}
+	if len(chirpIDs) == 0 {
+		return attached, nil
+	}
+	rows, err := cfg.DbQueries.ListChirpMedia(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		attached[row.ChirpID.UUID] = append(attached[row.ChirpID.UUID], mapMedia(row))
+	}
+	return attached, nil
+}
+
+// checkAttachableMedia checks that every media ID of a new chirp refers to an
+// upload of the user that is neither attached to another chirp yet nor used as
+// their avatar. It returns the IDs without duplicates, or responds with a
+// problem and returns false.
+func (cfg *ApiConfig) checkAttachableMedia(w http.ResponseWriter, r *http.Request, userID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, bool) {
+	unique := make([]uuid.UUID, 0, len(ids))
+	seen := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, true
+	}
+
+	count, err := cfg.DbQueries.CountAttachableMedia(r.Context(), database.CountAttachableMediaParams{
+		Ids:    unique,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up media", err)
+		return nil, false
+	}
+	if count != int64(len(unique)) {
+		respondWithError(w, r, CodeValidationFailed, "Request body is invalid", nil, FieldError{
+			Field:   "media_ids",
+			Code:    "invalid_media",
+			Message: "must be media you uploaded that are not attached to another chirp or used as your avatar",
+		})
+		return nil, false
+	}
+	return unique, true
+}
+
+// deleteMediaBlobs deletes the stored files of media whose rows are gone.
+// Failures are only logged, as they leave nothing but unreachable files.
+func (cfg *ApiConfig) deleteMediaBlobs(ctx context.Context, rows []database.Media) {
+	if cfg.Blobs == nil {
+		return
+	}
+	for _, row := range rows {
+		for _, key := range []string{row.StorageKey, row.ThumbnailKey} {
+			if err := cfg.Blobs.Delete(ctx, key); err != nil {
+				slog.ErrorContext(ctx, "Failed to delete media file", "media_id", row.ID, "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// HandleUploadMedia stores an image uploaded as the "file" field of a
+// multipart/form-data body. The image must be a JPEG, PNG or GIF no larger than
+// the configured size. It is stored without its metadata, along with a
+// thumbnail, and can then be attached to a chirp by passing its ID in the
+// chirp's "media_ids". It responds with a 201 status code and the media
+// resource.
+func (cfg *ApiConfig) HandleUploadMedia(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if cfg.Blobs == nil {
+		respondWithError(w, r, CodeInternal, "Media uploads are not configured", nil)
+		return
+	}
+	maxBytes := cfg.MediaMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMediaMaxBytes
+	}
+	thumbnailSize := cfg.ThumbnailSize
+	if thumbnailSize <= 0 {
+		thumbnailSize = defaultThumbnailSize
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	data, err := readUploadedFile(r, "file", maxBytes)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errFileTooLarge), errors.As(err, &maxBytesErr):
+		respondWithError(w, r, CodeBodyTooLarge, fmt.Sprintf("File must not be larger than %d bytes", maxBytes), err)
+		return
+	case err != nil:
+		respondWithError(w, r, CodeInvalidBody, "Request body must be multipart/form-data with a file field", err)
+		return
+	}
+
+	img, err := media.Process(data, thumbnailSize)
+	if err != nil {
+		fieldErr := FieldError{Field: "file", Code: "invalid_image", Message: "must be a valid image"}
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			fieldErr = FieldError{Field: "file", Code: "unsupported_type", Message: "must be a JPEG, PNG or GIF image"}
+		case errors.Is(err, media.ErrTooManyPixels):
+			fieldErr = FieldError{Field: "file", Code: "too_many_pixels", Message: fmt.Sprintf("must have at most %d pixels, and at most %d across all frames of an animation", media.MaxPixels, media.MaxAnimationPixels)}
+		}
+		respondWithError(w, r, CodeValidationFailed, "File is not a supported image", err, fieldErr)
+		return
+	}
+
+	id := uuid.New()
+	params := database.CreateMediaParams{
+		ID:                   id,
+		UserID:               userID,
+		ContentType:          img.ContentType,
+		Size:                 int64(len(img.Data)),
+		Width:                int32(img.Width),
+		Height:               int32(img.Height),
+		StorageKey:           "originals/" + id.String(),
+		ThumbnailContentType: img.ThumbnailContentType,
+		ThumbnailKey:         "thumbnails/" + id.String(),
+	}
+	if err := cfg.Blobs.Put(r.Context(), params.StorageKey, bytes.NewReader(img.Data), params.Size, img.ContentType); err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to store file", err)
+		return
+	}
+	if err := cfg.Blobs.Put(r.Context(), params.ThumbnailKey, bytes.NewReader(img.Thumbnail), int64(len(img.Thumbnail)), img.ThumbnailContentType); err != nil {
+		cfg.deleteMediaBlobs(r.Context(), []database.Media{{ID: id, StorageKey: params.StorageKey, ThumbnailKey: params.ThumbnailKey}})
+		respondWithError(w, r, CodeInternal, "Failed to store thumbnail", err)
+		return
+	}
+
+	row, err := cfg.DbQueries.CreateMedia(r.Context(), params)
+	if err != nil {
+		cfg.deleteMediaBlobs(r.Context(), []database.Media{{ID: id, StorageKey: params.StorageKey, ThumbnailKey: params.ThumbnailKey}})
+		respondWithError(w, r, CodeInternal, "Failed to save media", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mapMedia(row))
+}
+
+// errFileTooLarge is returned by readUploadedFile for files that are too large.
+var errFileTooLarge = errors.New("file is too large")
+
+// readUploadedFile reads the multipart/form-data field with the given name,
+// without buffering other fields, and returns errFileTooLarge if it is larger
+// than maxBytes.
+func readUploadedFile(r *http.Request, field string, maxBytes int64) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("missing %q field", field)
+			}
+			return nil, err
+		}
+		if part.FormName() != field {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		data, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > maxBytes {
+			return nil, errFileTooLarge
+		}
+		return data, nil
+	}
+}
+
+// HandleGetMedia responds with an uploaded image. Avatars are visible to
+// everyone, the images of a chirp to whoever may see the chirp and other
+// uploads only to their uploader; media the viewer may not see respond with a
+// 404 status code.
+func (cfg *ApiConfig) HandleGetMedia(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, false)
+}
+
+// HandleGetMediaThumbnail responds with the thumbnail of an uploaded image,
+// which is visible to the same users as the image.
+func (cfg *ApiConfig) HandleGetMediaThumbnail(w http.ResponseWriter, r *http.Request) {
+	cfg.serveMedia(w, r, true)
+}
+
+func (cfg *ApiConfig) serveMedia(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	mediaID, ok := pathUUID(w, r, "mediaID")
+	if !ok {
+		return
+	}
+	viewer, ok := cfg.optionalViewer(w, r)
+	if !ok {
+		return
+	}
+	row, err := cfg.DbQueries.GetVisibleMedia(r.Context(), database.GetVisibleMediaParams{
+		ID:       mediaID,
+		ViewerID: viewer,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, CodeNotFound, "Failed to find media with ID: "+mediaID.String(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get media", err)
+		return
+	}
+	if cfg.Blobs == nil {
+		respondWithError(w, r, CodeInternal, "Media uploads are not configured", nil)
+		return
+	}
+
+	key, contentType := row.StorageKey, row.ContentType
+	if thumbnail {
+		key, contentType = row.ThumbnailKey, row.ThumbnailContentType
+	}
+	blob, err := cfg.Blobs.Get(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		respondWithError(w, r, CodeNotFound, "Media file is missing", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to read media file", err)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if row.IsPublic {
+		w.Header().Set("Cache-Control", mediaCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", privateMediaCacheControl)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.FormatInt(row.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, blob); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to send media file", "media_id", row.ID, "error", err)
+	}
+}
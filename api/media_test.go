@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/blobstore"
+)
+
+// TestHandleUploadMediaRejects covers the uploads rejected before anything is
+// stored, so it needs no database.
+func TestHandleUploadMediaRejects(t *testing.T) {
+	const secret = "a-test-token-secret-that-is-long-enough"
+	token, err := auth.MakeJWT(uuid.New(), secret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ApiConfig{TokenSecret: secret, Blobs: blobs, MediaMaxBytes: 1024}
+
+	multipartBody := func(field string, data []byte) (string, string) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile(field, "upload")
+		part.Write(data)
+		writer.Close()
+		return body.String(), writer.FormDataContentType()
+	}
+	text, textType := multipartBody("file", []byte("just some text"))
+	large, largeType := multipartBody("file", bytes.Repeat([]byte{0xFF}, 2048))
+	other, otherType := multipartBody("image", []byte("just some text"))
+
+	tests := []struct {
+		name        string
+		token       string
+		body        string
+		contentType string
+		wantCode    ErrorCode
+	}{
+		{name: "no token", body: text, contentType: textType, wantCode: CodeMissingCredentials},
+		{name: "not multipart", token: token, body: `{"file": "x"}`, contentType: "application/json", wantCode: CodeInvalidBody},
+		{name: "missing file", token: token, body: other, contentType: otherType, wantCode: CodeInvalidBody},
+		{name: "too large", token: token, body: large, contentType: largeType, wantCode: CodeBodyTooLarge},
+		{name: "not an image", token: token, body: text, contentType: textType, wantCode: CodeValidationFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/media", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			cfg.HandleUploadMedia(rec, req)
+
+			var problem Problem
+			if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+				t.Fatalf("Failed to decode problem: %v", err)
+			}
+			if problem.Code != tt.wantCode || rec.Code != tt.wantCode.Status() {
+				t.Errorf("Expected %s, got %d %s: %s", tt.wantCode, rec.Code, problem.Code, problem.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
+// directKey returns the key that identifies the direct conversation between
+// two users, whichever of them starts it.
+func directKey(a, b uuid.UUID) string {
+	ids := []string{a.String(), b.String()}
+	slices.Sort(ids)
+	return strings.Join(ids, ":")
+}
+
+// HandleCreateConversation starts a conversation between the authenticated
+// user and the users in member_ids: a direct conversation with one other user,
+// or a group conversation with up to nine. Starting a direct conversation that
+// already exists responds with it and a 200 status code; otherwise it responds
+// with the new conversation and a 201 status code. Users cannot start a
+// conversation with users they blocked or who blocked them, between members
+// who blocked each other, or with protected users they do not follow.
+func (cfg *ApiConfig) HandleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var createConversationRequest CreateConversationRequest
+	if !decodeRequest(w, r, &createConversationRequest) {
+		return
+	}
+
+	// Ignore duplicates and the user themselves, who is always a member
+	var others []uuid.UUID
+	for _, id := range createConversationRequest.MemberIDs {
+		if id != userID && !slices.Contains(others, id) {
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 {
+		respondWithError(w, r, CodeValidationFailed, "Request body is invalid", nil, FieldError{
+			Field:   "member_ids",
+			Code:    "self",
+			Message: "must include a user other than you",
+		})
+		return
+	}
+	count, err := cfg.DbQueries.CountExistingUsers(r.Context(), others)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up members", err)
+		return
+	}
+	if count != int64(len(others)) {
+		respondWithError(w, r, CodeValidationFailed, "Request body is invalid", nil, FieldError{
+			Field:   "member_ids",
+			Code:    "invalid_user",
+			Message: "must be the IDs of existing users",
+		})
+		return
+	}
+	if !cfg.checkNotBlocked(w, r, userID, others, "You cannot message a user you blocked or who blocked you") {
+		return
+	}
+	// Members who blocked each other could never message the group
+	blocked, err := cfg.DbQueries.AnyBlockedAmong(r.Context(), others)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up blocks", err)
+		return
+	}
+	if blocked {
+		respondWithError(w, r, CodeForbidden, "You cannot start a conversation between users who blocked each other", nil)
+		return
+	}
+	unreachable, err := cfg.DbQueries.AnyProtectedNotFollowed(r.Context(), database.AnyProtectedNotFollowedParams{
+		UserID:   userID,
+		OtherIds: others,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up members", err)
+		return
+	}
+	if unreachable {
+		respondWithError(w, r, CodeForbidden, "You cannot start a conversation with a protected user you do not follow", nil)
+		return
+	}
+
+	var key sql.NullString
+	if len(others) == 1 {
+		key = sql.NullString{String: directKey(userID, others[0]), Valid: true}
+		existing, err := cfg.DbQueries.GetConversationByDirectKey(r.Context(), key)
+		if err == nil {
+			cfg.respondWithConversation(w, r, userID, existing.ID, http.StatusOK)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, CodeInternal, "Failed to look up conversation", err)
+			return
+		}
+	}
+
+	conversation, err := cfg.DbQueries.CreateConversation(r.Context(), database.CreateConversationParams{
+		DirectKey: key,
+		MemberIds: append([]uuid.UUID{userID}, others...),
+	})
+	if isUniqueViolation(err) {
+		// The other user started the same direct conversation meanwhile
+		existing, err := cfg.DbQueries.GetConversationByDirectKey(r.Context(), key)
+		if err != nil {
+			respondWithError(w, r, CodeInternal, "Failed to look up conversation", err)
+			return
+		}
+		cfg.respondWithConversation(w, r, userID, existing.ID, http.StatusOK)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to create conversation", err)
+		return
+	}
+
+	cfg.respondWithConversation(w, r, userID, conversation.ID, http.StatusCreated)
+}
+
+// respondWithConversation responds with one of the user's conversations.
+func (cfg *ApiConfig) respondWithConversation(w http.ResponseWriter, r *http.Request, userID, conversationID uuid.UUID, status int) {
+	conversations, err := cfg.userConversations(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get conversation", err)
+		return
+	}
+	for _, conversation := range conversations {
+		if conversation.ID == conversationID {
+			respondWithJSON(w, status, conversation)
+			return
+		}
+	}
+	respondWithError(w, r, CodeNotFound, "Failed to find conversation with ID: "+conversationID.String(), nil)
+}
+
+// HandleListConversations responds with the authenticated user's
+// conversations, the one with the latest message first.
+func (cfg *ApiConfig) HandleListConversations(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversations, err := cfg.userConversations(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get conversations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, conversations)
+}
+
+// userConversations returns the conversations of a user with their members.
+func (cfg *ApiConfig) userConversations(ctx context.Context, userID uuid.UUID) ([]MappedConversation, error) {
+	rows, err := cfg.DbQueries.ListConversationsForMember(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	members, err := cfg.conversationMembers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]MappedConversation, 0, len(rows))
+	for _, row := range rows {
+		conversations = append(conversations, MappedConversation{
+			ID:          row.ID,
+			CreatedAt:   row.CreatedAt,
+			UpdatedAt:   row.UpdatedAt,
+			Direct:      row.DirectKey.Valid,
+			Members:     members[row.ID],
+			UnreadCount: row.UnreadCount,
+		})
+	}
+	return conversations, nil
+}
+
+// conversationMembers returns the members of the conversations by
+// conversation ID.
+func (cfg *ApiConfig) conversationMembers(ctx context.Context, conversationIDs []uuid.UUID) (map[uuid.UUID][]MappedMember, error) {
+	rows, err := cfg.DbQueries.ListConversationMembers(ctx, conversationIDs)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[uuid.UUID][]MappedMember, len(conversationIDs))
+	for _, row := range rows {
+		member := MappedMember{ID: row.ID, DisplayName: row.DisplayName}
+		if row.Username.Valid {
+			member.Username = &row.Username.String
+		}
+		if row.LastReadAt.Valid {
+			member.LastReadAt = &row.LastReadAt.Time
+		}
+		members[row.ConversationID] = append(members[row.ConversationID], member)
+	}
+	return members, nil
+}
+
+// HandleSendMessage sends a message to the conversation with the ID in the
+// path, of which the authenticated user must be a member. Messages follow the
+// rules of chirps: at most 140 characters, with profane words replaced. The
+// other members receive it in real time, and sending it marks the
+// conversation as read for the sender. It responds with the message and a 201
+// status code, a 403 status code if the user blocked or was blocked by another
+// member, or a 404 status code if the user is not a member.
+func (cfg *ApiConfig) HandleSendMessage(w http.ResponseWriter, r *http.Request) {
+	conversationID, ok := pathUUID(w, r, "conversationID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var sendMessageRequest SendMessageRequest
+	if !decodeRequest(w, r, &sendMessageRequest) {
+		return
+	}
+	if !cfg.checkConversationMember(w, r, conversationID, userID) {
+		return
+	}
+	members, err := cfg.conversationMembers(r.Context(), []uuid.UUID{conversationID})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get conversation members", err)
+		return
+	}
+	var others []uuid.UUID
+	for _, member := range members[conversationID] {
+		if member.ID != userID {
+			others = append(others, member.ID)
+		}
+	}
+	if !cfg.checkNotBlocked(w, r, userID, others, "You cannot message a user you blocked or who blocked you") {
+		return
+	}
+
+	message, err := cfg.DbQueries.CreateMessage(r.Context(), database.CreateMessageParams{
+		ConversationID: conversationID,
+		SenderID:       userID,
+		Body:           censorProfanity(sendMessageRequest.Body),
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to send message", err)
+		return
+	}
+	_, err = cfg.DbQueries.MarkConversationRead(r.Context(), database.MarkConversationReadParams{
+		ReadAt:         message.CreatedAt,
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to mark conversation as read", "conversation_id", conversationID, "error", err)
+	}
+
+	mapped := MappedMessage{
+		ID:             message.ID,
+		CreatedAt:      message.CreatedAt,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Body:           message.Body,
+		ReadBy:         []uuid.UUID{},
+	}
+	cfg.publishMessage(r.Context(), mapped)
+
+	respondWithJSON(w, http.StatusCreated, mapped)
+}
+
+// publishMessage publishes a new message to the real-time APIs, addressed to
+// the members of its conversation other than the sender.
+func (cfg *ApiConfig) publishMessage(ctx context.Context, message MappedMessage) {
+	if cfg.Stream == nil && cfg.Events == nil {
+		return
+	}
+	members, err := cfg.conversationMembers(ctx, []uuid.UUID{message.ConversationID})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up conversation members", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	var recipients []uuid.UUID
+	for _, member := range members[message.ConversationID] {
+		if member.ID != message.SenderID {
+			recipients = append(recipients, member.ID)
+		}
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode stream event", "event", stream.EventMessageCreated, "error", err)
+		return
+	}
+	cfg.publish(ctx, stream.Event{
+		Type:       stream.EventMessageCreated,
+		AuthorID:   message.SenderID,
+		Recipients: recipients,
+		Data:       data,
+	})
+}
+
+// HandleListMessages responds with the messages of the conversation with the
+// ID in the path, newest first, if the authenticated user is a member. The
+// query parameter "limit" sets the page size, 50 by default, and "cursor"
+// continues from the next_cursor of the previous page.
+func (cfg *ApiConfig) HandleListMessages(w http.ResponseWriter, r *http.Request) {
+	conversationID, ok := pathUUID(w, r, "conversationID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", 1, maxMessagesLimit)
+	if !ok {
+		return
+	}
+	if !limit.Valid {
+		limit.Int64 = defaultMessagesLimit
+	}
+	beforeCreatedAt, beforeID, ok := queryCursor(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.checkConversationMember(w, r, conversationID, userID) {
+		return
+	}
+
+	// Fetch one more than the page size to learn whether there is a next page
+	messages, err := cfg.DbQueries.ListMessages(r.Context(), database.ListMessagesParams{
+		ConversationID:  conversationID,
+		BeforeCreatedAt: beforeCreatedAt,
+		BeforeID:        beforeID,
+		MaxResults:      int32(limit.Int64) + 1,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get messages", err)
+		return
+	}
+	page := MessagePage{Messages: []MappedMessage{}}
+	if len(messages) > int(limit.Int64) {
+		messages = messages[:limit.Int64]
+		last := messages[len(messages)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	members, err := cfg.conversationMembers(r.Context(), []uuid.UUID{conversationID})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get conversation members", err)
+		return
+	}
+	for _, message := range messages {
+		page.Messages = append(page.Messages, MappedMessage{
+			ID:             message.ID,
+			CreatedAt:      message.CreatedAt,
+			ConversationID: message.ConversationID,
+			SenderID:       message.SenderID,
+			Body:           message.Body,
+			ReadBy:         readBy(message.SenderID, message.CreatedAt, members[conversationID]),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// readBy returns the IDs of the members other than the sender who have read a
+// message sent at the given time.
+func readBy(senderID uuid.UUID, sentAt time.Time, members []MappedMember) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for _, member := range members {
+		if member.ID != senderID && member.LastReadAt != nil && !member.LastReadAt.Before(sentAt) {
+			ids = append(ids, member.ID)
+		}
+	}
+	return ids
+}
+
+// HandleMarkMessageRead marks the message with the ID in the path, and every
+// earlier message of its conversation, as read by the authenticated user. The
+// other members see it in the message's read_by. Marking an older message than
+// the last one read has no effect. It responds with a 204 status code, or a 404
+// status code if the user is not a member or there is no such message.
+func (cfg *ApiConfig) HandleMarkMessageRead(w http.ResponseWriter, r *http.Request) {
+	conversationID, ok := pathUUID(w, r, "conversationID")
+	if !ok {
+		return
+	}
+	messageID, ok := pathUUID(w, r, "messageID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.checkConversationMember(w, r, conversationID, userID) {
+		return
+	}
+
+	message, err := cfg.DbQueries.GetMessage(r.Context(), messageID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, CodeInternal, "Failed to get message", err)
+		return
+	}
+	if err != nil || message.ConversationID != conversationID {
+		respondWithError(w, r, CodeNotFound, "Failed to find message with ID: "+messageID.String(), err)
+		return
+	}
+
+	_, err = cfg.DbQueries.MarkConversationRead(r.Context(), database.MarkConversationReadParams{
+		ReadAt:         message.CreatedAt,
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to mark message as read", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkConversationMember checks that the user is a member of the
+// conversation. Otherwise it responds with a 404 status code, so that
+// non-members cannot tell whether the conversation exists, and returns false.
+func (cfg *ApiConfig) checkConversationMember(w http.ResponseWriter, r *http.Request, conversationID, userID uuid.UUID) bool {
+	_, err := cfg.DbQueries.GetConversationForMember(r.Context(), database.GetConversationForMemberParams{
+		ID:     conversationID,
+		UserID: userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, CodeNotFound, "Failed to find conversation with ID: "+conversationID.String(), err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get conversation", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDirectKey(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	if directKey(a, b) != directKey(b, a) {
+		t.Error("Expected the same key whichever user starts the conversation")
+	}
+	if directKey(a, b) == directKey(a, uuid.New()) {
+		t.Error("Expected different pairs of users to have different keys")
+	}
+}
+
+func TestReadBy(t *testing.T) {
+	sentAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	before, after := sentAt.Add(-time.Second), sentAt.Add(time.Second)
+	sender, reader, exact, behind, never := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	members := []MappedMember{
+		{ID: sender, LastReadAt: &after},
+		{ID: reader, LastReadAt: &after},
+		{ID: exact, LastReadAt: &sentAt},
+		{ID: behind, LastReadAt: &before},
+		{ID: never},
+	}
+
+	if got, want := readBy(sender, sentAt, members), []uuid.UUID{reader, exact}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := readBy(sender, sentAt, nil); got == nil || len(got) != 0 {
+		t.Errorf("Expected an empty list, got %#v", got)
+	}
+}
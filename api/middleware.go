@@ -1,14 +1,278 @@
 package api
 
 import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/telemetry"
 )
 
 // middlewareMetricsInc wraps the given http.Handler and increments the fileserverHits
 // counter on each request.
 func (cfg *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
+		cfg.Metrics.FileserverHit()
 		next.ServeHTTP(w, r)
 	})
 }
+
+// MiddlewareInstrument wraps the given http.Handler, which is expected to be the
+// ServeMux, and records the count and latency of every request labelled with the
+// matched route pattern, method and response status code.
+func (cfg *ApiConfig) MiddlewareInstrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Metrics == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// The ServeMux sets the pattern of the matched route on the request
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := []string{route, r.Method, strconv.Itoa(recorder.status)}
+		cfg.Metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		cfg.Metrics.HTTPDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the status code
+// written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Flush implements http.Flusher for handlers that stream their response.
+func (rec *statusRecorder) Flush() {
+	rec.wroteHeader = true
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker for handlers that take over the connection,
+// such as the WebSocket handler. The connection is recorded as switching
+// protocols, since the handler writes that response itself.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil && !rec.wroteHeader {
+		rec.status = http.StatusSwitchingProtocols
+		rec.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// requestIDHeader is the header used to propagate the request ID.
+const requestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const requestInfoKey contextKey = iota
+
+// requestInfo collects the details of a request that are only known to the
+// handler, such as the authenticated user and the cause of an error, so they
+// can be included in the access log entry.
+type requestInfo struct {
+	RequestID  string
+	UserID     uuid.UUID
+	Err        error
+	ErrMessage string
+}
+
+// requestInfoFromContext returns the requestInfo stored in the context, or nil
+// if there is none.
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// RequestID returns the ID of the request the context belongs to, or an empty
+// string if it has none.
+func RequestID(ctx context.Context) string {
+	if info := requestInfoFromContext(ctx); info != nil {
+		return info.RequestID
+	}
+	return ""
+}
+
+// setRequestUserID records the authenticated user for the access log.
+func setRequestUserID(r *http.Request, userID uuid.UUID) {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		info.UserID = userID
+	}
+}
+
+// authenticate returns the ID of the user whose access token is in the
+// Authorization header and records them for the access log. If the token is
+// missing or invalid, it responds with a problem and returns false.
+func (cfg *ApiConfig) authenticate(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		cfg.Metrics.AuthFailure("missing_token")
+		respondWithError(w, r, CodeMissingCredentials, "Missing access token", err)
+		return uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.TokenSecret)
+	if err != nil {
+		cfg.Metrics.AuthFailure("invalid_token")
+		respondWithError(w, r, CodeInvalidToken, "Invalid access token", err)
+		return uuid.Nil, false
+	}
+	setRequestUserID(r, userID)
+	return userID, true
+}
+
+// optionalViewer authenticates a request to a public endpoint that
+// personalizes its response for logged in users. It returns a null ID if the
+// request has no Authorization header; if the access token is invalid, it
+// responds with a problem and returns false.
+func (cfg *ApiConfig) optionalViewer(w http.ResponseWriter, r *http.Request) (uuid.NullUUID, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.NullUUID{}, true
+	}
+	userID, ok := cfg.authenticate(w, r)
+	return uuid.NullUUID{UUID: userID, Valid: ok}, ok
+}
+
+// validRequestID reports whether a client supplied request ID is safe to
+// propagate: non-empty, at most 128 characters and printable ASCII only.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// MiddlewareRequestID wraps the given http.Handler and assigns every request an
+// ID. A valid X-Request-ID header sent by the client is propagated, otherwise a
+// new ID is generated. The ID is stored in the request context and echoed in the
+// X-Request-ID response header.
+func (cfg *ApiConfig) MiddlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		info := &requestInfo{RequestID: requestID}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)))
+	})
+}
+
+// MiddlewareLogging wraps the given http.Handler and writes a structured access
+// log entry for every request with its route, status, latency, request ID and
+// authenticated user. Requests that failed with a server error are logged at the
+// error level together with the cause recorded by respondWithError.
+func (cfg *ApiConfig) MiddlewareLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := requestInfoFromContext(r.Context())
+		if info == nil {
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", info.RequestID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", r.Pattern),
+			slog.Int("status", recorder.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", spanContext.TraceID().String()))
+		}
+		if info.UserID != uuid.Nil {
+			attrs = append(attrs, slog.String("user_id", info.UserID.String()))
+		}
+
+		level := slog.LevelInfo
+		if info.Err != nil {
+			attrs = append(attrs, slog.String("error", info.Err.Error()), slog.String("error_message", info.ErrMessage))
+			level = slog.LevelWarn
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// MiddlewareTracing wraps the given http.Handler and records a server span for
+// every request. The W3C trace context of the incoming request is continued if
+// present. Once the ServeMux has matched the request, the span is named after the
+// route pattern.
+func (cfg *ApiConfig) MiddlewareTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := telemetry.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(recorder, r)
+
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
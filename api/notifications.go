@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// Types of notifications.
+const (
+	NotificationMention        = "mention"
+	NotificationFollow         = "follow"
+	NotificationLike           = "like"
+	NotificationReply          = "reply"
+	NotificationFollowRequest  = "follow_request"
+	NotificationFollowAccepted = "follow_accepted"
+)
+
+const (
+	defaultNotificationsLimit = 20
+	maxNotificationsLimit     = 100
+	// maxNotificationActors is how many actors a notification lists; the
+	// others are only counted.
+	maxNotificationActors = 3
+)
+
+// notify tells a user that an actor mentioned, followed, liked or replied to
+// them, asked to follow them or accepted their follow request, and publishes
+// the notification to their WebSocket connections. The notification is grouped
+// with the user's unread notification of the same type about the same chirp,
+// if there is one. Users are not notified of their own actions or of the
+// actions of users hidden from them, and failures are only logged, since the
+// action itself succeeded.
+func (cfg *ApiConfig) notify(ctx context.Context, userID, actorID uuid.UUID, notificationType string, chirpID uuid.NullUUID) {
+	if userID == actorID {
+		return
+	}
+	groupKey := notificationType
+	if chirpID.Valid {
+		groupKey += ":" + chirpID.UUID.String()
+	}
+	notificationID, err := cfg.DbQueries.CreateNotification(ctx, database.CreateNotificationParams{
+		ActorID:  actorID,
+		UserID:   userID,
+		Type:     notificationType,
+		ChirpID:  chirpID,
+		GroupKey: groupKey,
+	})
+	// No rows means the user blocked or muted the actor, or was blocked by them
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create notification", "type", notificationType, "user_id", userID, "error", err)
+		return
+	}
+	cfg.publishNotification(ctx, userID, NotificationEvent{
+		NotificationID: notificationID,
+		Type:           notificationType,
+		ActorID:        actorID,
+		ChirpID:        nullUUIDPtr(chirpID),
+	})
+}
+
+// publishNotification publishes a notification.created event to the user the
+// notification is for.
+func (cfg *ApiConfig) publishNotification(ctx context.Context, userID uuid.UUID, notification NotificationEvent) {
+	if cfg.Stream == nil && cfg.Events == nil {
+		return
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode stream event", "event", stream.EventNotificationCreated, "error", err)
+		return
+	}
+	cfg.publish(ctx, stream.Event{
+		Type:       stream.EventNotificationCreated,
+		AuthorID:   notification.ActorID,
+		Recipients: []uuid.UUID{userID},
+		Data:       data,
+	})
+}
+
+// HandleListNotifications responds with the authenticated user's notifications,
+// most recently updated first, leaving out the users they blocked or muted, or
+// who blocked them. The query parameter "limit" sets the page size,
+// 20 by default, and "cursor" continues from the next_cursor of the previous
+// page. An invalid cursor is rejected with a 400 status code.
+func (cfg *ApiConfig) HandleListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", 1, maxNotificationsLimit)
+	if !ok {
+		return
+	}
+	if !limit.Valid {
+		limit.Int64 = defaultNotificationsLimit
+	}
+	beforeUpdatedAt, beforeID, ok := queryCursor(w, r)
+	if !ok {
+		return
+	}
+
+	// Fetch one more than the page size to learn whether there is a next page
+	rows, err := cfg.DbQueries.ListNotifications(r.Context(), database.ListNotificationsParams{
+		UserID:          userID,
+		BeforeUpdatedAt: beforeUpdatedAt,
+		BeforeID:        beforeID,
+		MaxResults:      int32(limit.Int64) + 1,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get notifications", err)
+		return
+	}
+	page := NotificationPage{Notifications: []MappedNotification{}}
+	if len(rows) > int(limit.Int64) {
+		rows = rows[:limit.Int64]
+		last := rows[len(rows)-1]
+		page.NextCursor = encodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	ids := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	actorRows, err := cfg.DbQueries.ListNotificationActors(r.Context(), database.ListNotificationActorsParams{
+		NotificationIds: ids,
+		UserID:          userID,
+		MaxActors:       maxNotificationActors,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get notification actors", err)
+		return
+	}
+	actors := make(map[uuid.UUID][]MappedActor, len(rows))
+	for _, row := range actorRows {
+		actor := MappedActor{ID: row.ID, DisplayName: row.DisplayName}
+		if row.Username.Valid {
+			actor.Username = &row.Username.String
+		}
+		actors[row.NotificationID] = append(actors[row.NotificationID], actor)
+	}
+
+	for _, row := range rows {
+		notification := MappedNotification{
+			ID:         row.ID,
+			CreatedAt:  row.CreatedAt,
+			UpdatedAt:  row.UpdatedAt,
+			Type:       row.Type,
+			ChirpID:    nullUUIDPtr(row.ChirpID),
+			Read:       row.ReadAt.Valid,
+			Actors:     actors[row.ID],
+			ActorCount: row.ActorCount,
+		}
+		if notification.Actors == nil {
+			notification.Actors = []MappedActor{}
+		}
+		if row.ReadAt.Valid {
+			notification.ReadAt = &row.ReadAt.Time
+		}
+		notification.Summary = notificationSummary(row.Type, notification.Actors, row.ActorCount)
+		page.Notifications = append(page.Notifications, notification)
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// HandleMarkNotificationRead marks the authenticated user's notification with
+// the ID in the path as read. It responds with a 204 status code, or a 404
+// status code if the user has no such notification.
+func (cfg *ApiConfig) HandleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	notificationID, ok := pathUUID(w, r, "notificationID")
+	if !ok {
+		return
+	}
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.MarkNotificationRead(r.Context(), database.MarkNotificationReadParams{
+		ID:     notificationID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to mark notification as read", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "Failed to find notification with ID: "+notificationID.String(), nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMarkAllNotificationsRead marks every notification of the authenticated
+// user as read. It responds with a 204 status code.
+func (cfg *ApiConfig) HandleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.DbQueries.MarkAllNotificationsRead(r.Context(), userID); err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to mark notifications as read", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUnreadNotificationCount responds with the number of unread
+// notifications of the authenticated user.
+func (cfg *ApiConfig) HandleUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.DbQueries.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to count unread notifications", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, UnreadCountResponse{UnreadCount: count})
+}
+
+// notificationSummary describes a notification in a sentence, such as "alice
+// and 4 others liked your chirp". Actors are the most recent actors and count
+// is the number of all of them.
+func notificationSummary(notificationType string, actors []MappedActor, count int64) string {
+	var verb string
+	switch notificationType {
+	case NotificationMention:
+		verb = "mentioned you"
+	case NotificationFollow:
+		verb = "followed you"
+	case NotificationLike:
+		verb = "liked your chirp"
+	case NotificationReply:
+		verb = "replied to your chirp"
+	case NotificationFollowRequest:
+		verb = "asked to follow you"
+	case NotificationFollowAccepted:
+		verb = "accepted your follow request"
+	default:
+		verb = "interacted with you"
+	}
+
+	if len(actors) == 0 {
+		return "Someone " + verb
+	}
+	first := actorName(actors[0])
+	switch {
+	case count <= 1:
+		return first + " " + verb
+	case count == 2 && len(actors) > 1:
+		return first + " and " + actorName(actors[1]) + " " + verb
+	case count == 2:
+		return first + " and 1 other " + verb
+	default:
+		return fmt.Sprintf("%s and %d others %s", first, count-1, verb)
+	}
+}
+
+// actorName returns the name notifications use for an actor: their display
+// name, else their username.
+func actorName(actor MappedActor) string {
+	if name := strings.TrimSpace(actor.DisplayName); name != "" {
+		return name
+	}
+	if actor.Username != nil {
+		return "@" + *actor.Username
+	}
+	return "Someone"
+}
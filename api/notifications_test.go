@@ -0,0 +1,40 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNotificationSummary(t *testing.T) {
+	alice := "alice"
+	actors := []MappedActor{
+		{ID: uuid.New(), Username: &alice, DisplayName: "Alice"},
+		{ID: uuid.New(), Username: &alice},
+		{ID: uuid.New()},
+	}
+
+	tests := []struct {
+		name             string
+		notificationType string
+		actors           []MappedActor
+		count            int64
+		want             string
+	}{
+		{"one actor", NotificationLike, actors[:1], 1, "Alice liked your chirp"},
+		{"two actors", NotificationFollow, actors[:2], 2, "Alice and @alice followed you"},
+		{"many actors", NotificationLike, actors, 5, "Alice and 4 others liked your chirp"},
+		{"deleted actor", NotificationReply, actors[:1], 2, "Alice and 1 other replied to your chirp"},
+		{"no name", NotificationMention, actors[2:], 1, "Someone mentioned you"},
+		{"no actors", NotificationMention, nil, 0, "Someone mentioned you"},
+		{"follow request", NotificationFollowRequest, actors[:2], 2, "Alice and @alice asked to follow you"},
+		{"follow accepted", NotificationFollowAccepted, actors[:1], 1, "Alice accepted your follow request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notificationSummary(tt.notificationType, tt.actors, tt.count); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"net/http"
+	"regexp"
+)
+
+// openAPISpec is the OpenAPI 3.1 specification of every route returned by
+// ApiConfig.Routes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders the specification with Swagger UI.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUIBase is the pinned Swagger UI release docs.html loads. Published
+// npm versions cannot change, and the Content-Security-Policy of the page only
+// allows these files, so no other script runs on the API's origin.
+const swaggerUIBase = "https://unpkg.com/swagger-ui-dist@5.17.14/"
+
+// inlineScriptPattern matches the inline script of docs.html.
+var inlineScriptPattern = regexp.MustCompile(`(?s)<script>(.*?)</script>`)
+
+// docsPolicy is the Content-Security-Policy of the documentation page: the
+// pinned Swagger UI files, the page's own inline script and the specification.
+var docsPolicy = func() string {
+	match := inlineScriptPattern.FindSubmatch(docsPage)
+	if match == nil {
+		panic("docs.html has no inline script")
+	}
+	sum := sha256.Sum256(match[1])
+	return "default-src 'none'; " +
+		"script-src " + swaggerUIBase + "swagger-ui-bundle.js 'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'; " +
+		"style-src " + swaggerUIBase + "swagger-ui.css 'unsafe-inline'; " +
+		"img-src 'self' data:; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+}()
+
+// HandleOpenAPI responds with the OpenAPI specification of the API.
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
+
+// HandleDocs responds with an interactive documentation page for the OpenAPI
+// specification.
+func HandleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsPolicy)
+	w.WriteHeader(http.StatusOK)
+	w.Write(docsPage)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func loadOpenAPISpec(t *testing.T) map[string]any {
+	t.Helper()
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("OpenAPI specification is not valid JSON: %v", err)
+	}
+	return spec
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	if spec["openapi"] != "3.1.0" {
+		t.Errorf("openapi = %v, want 3.1.0", spec["openapi"])
+	}
+	paths, _ := spec["paths"].(map[string]any)
+
+	registered := map[string]bool{}
+	for _, route := range (&ApiConfig{}).Routes() {
+		method, path, found := strings.Cut(route.Pattern, " ")
+		if !found {
+			t.Errorf("Route %q has no method", route.Pattern)
+			continue
+		}
+		registered[strings.ToLower(method)+" "+path] = true
+
+		operations, _ := paths[path].(map[string]any)
+		if _, ok := operations[strings.ToLower(method)]; !ok {
+			t.Errorf("Route %q is missing from the OpenAPI specification", route.Pattern)
+		}
+	}
+
+	// Operations that are documented but not registered are drift as well
+	for path, item := range paths {
+		for method := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+			if !registered[method+" "+path] {
+				t.Errorf("Operation %s %s is documented but not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	var walk func(node any)
+	walk = func(node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			if ref, ok := node["$ref"].(string); ok {
+				var target any = spec
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					object, _ := target.(map[string]any)
+					target = object[part]
+				}
+				if target == nil {
+					t.Errorf("Reference %q does not resolve", ref)
+				}
+			}
+			for _, child := range node {
+				walk(child)
+			}
+		case []any:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		contentType string
+	}{
+		{name: "specification", handler: HandleOpenAPI, contentType: "application/json"},
+		{name: "docs", handler: HandleDocs, contentType: "text/html; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if w.Body.Len() == 0 {
+				t.Error("body is empty")
+			}
+		})
+	}
+}
+
+func TestDocsPageLoadsPinnedAssets(t *testing.T) {
+	// Every external asset must be the pinned release the policy allows
+	for _, match := range regexp.MustCompile(`(?:src|href)="(https://[^"]+)"`).FindAllSubmatch(docsPage, -1) {
+		if !strings.HasPrefix(string(match[1]), swaggerUIBase) {
+			t.Errorf("docs.html loads %s, want only files of %s", match[1], swaggerUIBase)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	HandleDocs(w, httptest.NewRequest(http.MethodGet, "/api/docs", nil))
+	policy := w.Header().Get("Content-Security-Policy")
+	if !strings.Contains(policy, "script-src "+swaggerUIBase+"swagger-ui-bundle.js 'sha256-") {
+		t.Errorf("Content-Security-Policy = %q, want only the pinned bundle and the inline script", policy)
+	}
+}
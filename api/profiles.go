@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// isUniqueViolation reports whether a query failed because it would have
+// broken a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// userProfile returns the public profile of a user, which never includes their
+// email address.
+func (cfg *ApiConfig) userProfile(ctx context.Context, userID uuid.UUID) (MappedProfile, error) {
+	row, err := cfg.DbQueries.GetUserProfile(ctx, userID)
+	if err != nil {
+		return MappedProfile{}, err
+	}
+
+	profile := MappedProfile{
+		ID:             row.ID,
+		CreatedAt:      row.CreatedAt,
+		DisplayName:    row.DisplayName,
+		Bio:            row.Bio,
+		Location:       row.Location,
+		Website:        row.Website,
+		IsChirpyRed:    row.IsChirpyRed,
+		IsProtected:    row.IsProtected,
+		ChirpCount:     row.ChirpCount,
+		FollowerCount:  row.FollowerCount,
+		FollowingCount: row.FollowingCount,
+	}
+	if row.Username.Valid {
+		profile.Username = &row.Username.String
+	}
+	if row.AvatarMediaID.Valid {
+		avatar, err := cfg.DbQueries.GetMedia(ctx, row.AvatarMediaID.UUID)
+		if err != nil {
+			return MappedProfile{}, err
+		}
+		mapped := mapMedia(avatar)
+		profile.Avatar = &mapped
+	}
+	return profile, nil
+}
+
+// HandleGetUser responds with the public profile of the user with the ID in the
+// path: their profile fields, avatar, whether they are protected, and chirp,
+// follower and following counts.
+// It is public and never includes the user's email address.
+func (cfg *ApiConfig) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUUID(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	profile, err := cfg.userProfile(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, CodeNotFound, "Failed to find user with ID: "+userID.String(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get user profile", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// HandleUpdateProfile updates the profile fields of the authenticated user that
+// are in the request body; absent fields keep their values. Usernames are
+// unique regardless of case and stored in lower case; an empty username
+// removes it. The avatar is an image uploaded through POST /api/media that is
+// not attached to a chirp; a null avatar_media_id removes it. Protected users
+// approve their followers, and turning protection off approves every pending
+// follow request. It responds with a 200 status code and the updated public
+// profile.
+func (cfg *ApiConfig) HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var updateProfileRequest UpdateProfileRequest
+	if !decodeRequest(w, r, &updateProfileRequest) {
+		return
+	}
+
+	if avatarID := updateProfileRequest.AvatarMediaID; avatarID.Valid {
+		avatar, err := cfg.DbQueries.GetMedia(r.Context(), avatarID.UUID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, CodeInternal, "Failed to look up avatar", err)
+			return
+		}
+		if err != nil || avatar.UserID != userID || avatar.ChirpID.Valid {
+			respondWithError(w, r, CodeValidationFailed, "Request body is invalid", nil, FieldError{
+				Field:   "avatar_media_id",
+				Code:    "invalid_media",
+				Message: "must be media you uploaded that are not attached to a chirp",
+			})
+			return
+		}
+	}
+
+	params := database.UpdateUserProfileParams{
+		ID:            userID,
+		SetUsername:   updateProfileRequest.Username != nil,
+		DisplayName:   nullString(updateProfileRequest.DisplayName),
+		Bio:           nullString(updateProfileRequest.Bio),
+		Location:      nullString(updateProfileRequest.Location),
+		Website:       nullString(updateProfileRequest.Website),
+		SetAvatar:     updateProfileRequest.AvatarMediaID.Set,
+		AvatarMediaID: updateProfileRequest.AvatarMediaID.NullUUID,
+	}
+	if username := updateProfileRequest.Username; username != nil && *username != "" {
+		params.Username = sql.NullString{String: strings.ToLower(*username), Valid: true}
+	}
+	if isProtected := updateProfileRequest.IsProtected; isProtected != nil {
+		params.IsProtected = sql.NullBool{Bool: *isProtected, Valid: true}
+	}
+	updated, err := cfg.DbQueries.UpdateUserProfile(r.Context(), params)
+	if isUniqueViolation(err) {
+		respondWithError(w, r, CodeConflict, "Username is already taken", err, FieldError{
+			Field:   "username",
+			Code:    "taken",
+			Message: "is already taken",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to update profile", err)
+		return
+	}
+	// Users who stop being protected no longer approve their followers
+	if updated.WasProtected && !updated.IsProtected {
+		cfg.approveAllFollowRequests(r.Context(), userID)
+	}
+
+	profile, err := cfg.userProfile(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get user profile", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// HandleFollowUser makes the authenticated user follow the user with the ID in
+// the path and notifies them. Following a user twice has no further effect. It
+// responds with a 204 status code, or a 403 status code if either user blocked
+// the other. Protected users approve their followers, so following one instead
+// sends them a follow request and responds with a 202 status code.
+func (cfg *ApiConfig) HandleFollowUser(w http.ResponseWriter, r *http.Request) {
+	followerID, followeeID, ok := cfg.relationRequest(w, r, "follow")
+	if !ok {
+		return
+	}
+
+	followee, err := cfg.DbQueries.GetUser(r.Context(), followeeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, r, CodeNotFound, "Failed to find user with ID: "+followeeID.String(), err)
+			return
+		}
+		respondWithError(w, r, CodeInternal, "Failed to get user", err)
+		return
+	}
+
+	if !cfg.checkNotBlocked(w, r, followerID, []uuid.UUID{followeeID}, "You cannot follow a user you blocked or who blocked you") {
+		return
+	}
+
+	if followee.IsProtected {
+		cfg.requestFollow(w, r, followerID, followeeID)
+		return
+	}
+
+	rows, err := cfg.DbQueries.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to follow user", err)
+		return
+	}
+	if rows > 0 {
+		cfg.publishFollow(r.Context(), stream.EventUserFollowed, followerID, followeeID)
+		cfg.notify(r.Context(), followeeID, followerID, NotificationFollow, uuid.NullUUID{})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestFollow sends a protected user a request to follow them and notifies
+// them, unless the requester already follows them. Requesting twice has no
+// further effect. It responds with a 202 status code while the request is
+// pending, or a 204 status code if the requester already follows them.
+func (cfg *ApiConfig) requestFollow(w http.ResponseWriter, r *http.Request, requesterID, targetID uuid.UUID) {
+	following, err := cfg.DbQueries.IsFollowing(r.Context(), database.IsFollowingParams{
+		FollowerID: requesterID,
+		FolloweeID: targetID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to look up follow", err)
+		return
+	}
+	if following {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rows, err := cfg.DbQueries.CreateFollowRequest(r.Context(), database.CreateFollowRequestParams{
+		RequesterID: requesterID,
+		TargetID:    targetID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to request to follow user", err)
+		return
+	}
+	if rows > 0 {
+		cfg.notify(r.Context(), targetID, requesterID, NotificationFollowRequest, uuid.NullUUID{})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleUnfollowUser makes the authenticated user stop following the user with
+// the ID in the path, or withdraw their pending request to follow them. It
+// responds with a 204 status code, or a 404 status code if they neither follow
+// the user nor asked to.
+func (cfg *ApiConfig) HandleUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followerID, followeeID, ok := cfg.relationRequest(w, r, "follow")
+	if !ok {
+		return
+	}
+
+	rows, err := cfg.DbQueries.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to unfollow user", err)
+		return
+	}
+	if rows > 0 {
+		cfg.publishFollow(r.Context(), stream.EventUserUnfollowed, followerID, followeeID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rows, err = cfg.DbQueries.DeleteFollowRequest(r.Context(), database.DeleteFollowRequestParams{
+		RequesterID: followerID,
+		TargetID:    followeeID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to withdraw follow request", err)
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, r, CodeNotFound, "You do not follow this user", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishFollow publishes a follow or unfollow to the real-time APIs, addressed
+// to the followed user.
+func (cfg *ApiConfig) publishFollow(ctx context.Context, eventType string, followerID, followeeID uuid.UUID) {
+	if cfg.Stream == nil && cfg.Events == nil {
+		return
+	}
+	data, err := json.Marshal(FollowEvent{FollowerID: followerID, FolloweeID: followeeID})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode stream event", "event", eventType, "error", err)
+		return
+	}
+	cfg.publish(ctx, stream.Event{
+		Type:       eventType,
+		AuthorID:   followerID,
+		Recipients: []uuid.UUID{followeeID},
+		Data:       data,
+	})
+}
+
+// relationRequest authenticates a request to follow, block or mute the user in
+// the path, or to undo it, and returns the IDs of the authenticated user and
+// the user in the path, who must differ. If the request is invalid, it
+// responds with a problem and returns false.
+func (cfg *ApiConfig) relationRequest(w http.ResponseWriter, r *http.Request, action string) (uuid.UUID, uuid.UUID, bool) {
+	followeeID, ok := pathUUID(w, r, "userID")
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	followerID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if followerID == followeeID {
+		respondWithError(w, r, CodeInvalidParameter, "You cannot "+action+" yourself", nil, FieldError{
+			Field:   "userID",
+			Code:    "self",
+			Message: "must not be your own user ID",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return followerID, followeeID, true
+}
+
+// nullString converts an optional string of a request body, where nil is NULL.
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
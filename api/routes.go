@@ -0,0 +1,74 @@
+package api
+
+import "net/http"
+
+// Route is an API endpoint: a ServeMux pattern such as "GET /api/chirps" and the
+// handler serving it.
+type Route struct {
+	Pattern string
+	Handler http.Handler
+}
+
+// Routes returns every API endpoint. They are registered by main and are all
+// documented in the OpenAPI specification served at /api/openapi.json.
+func (cfg *ApiConfig) Routes() []Route {
+	metricsHandler := http.NotFoundHandler()
+	if cfg.Metrics != nil {
+		metricsHandler = cfg.Metrics.Handler()
+	}
+
+	return []Route{
+		{"GET /api/healthz", http.HandlerFunc(cfg.HandleHealthz)},
+		{"GET /api/readyz", http.HandlerFunc(cfg.HandleReadyz)},
+		{"GET /api/openapi.json", http.HandlerFunc(HandleOpenAPI)},
+		{"GET /api/docs", http.HandlerFunc(HandleDocs)},
+		{"GET /metrics", metricsHandler},
+		{"GET /admin/metrics", http.HandlerFunc(cfg.HandleMetrics)},
+		{"POST /admin/reset", http.HandlerFunc(cfg.HandleReset)},
+		{"POST /api/chirps", http.HandlerFunc(cfg.HandleCreateChirp)},
+		{"POST /api/users", http.HandlerFunc(cfg.HandleCreateUser)},
+		{"GET /api/chirps", http.HandlerFunc(cfg.HandleGetAllChirps)},
+		{"GET /api/chirps/{chirpID}", http.HandlerFunc(cfg.HandleGetChirp)},
+		{"POST /api/login", http.HandlerFunc(cfg.HandleLoginUser)},
+		{"POST /api/refresh", http.HandlerFunc(cfg.HandleRefresh)},
+		{"POST /api/revoke", http.HandlerFunc(cfg.HandleRevoke)},
+		{"PUT /api/users", http.HandlerFunc(cfg.HandleUpdateUser)},
+		{"GET /api/users/{userID}", http.HandlerFunc(cfg.HandleGetUser)},
+		{"PUT /api/profile", http.HandlerFunc(cfg.HandleUpdateProfile)},
+		{"POST /api/users/{userID}/follow", http.HandlerFunc(cfg.HandleFollowUser)},
+		{"DELETE /api/users/{userID}/follow", http.HandlerFunc(cfg.HandleUnfollowUser)},
+		{"POST /api/users/{userID}/block", http.HandlerFunc(cfg.HandleBlockUser)},
+		{"DELETE /api/users/{userID}/block", http.HandlerFunc(cfg.HandleUnblockUser)},
+		{"POST /api/users/{userID}/mute", http.HandlerFunc(cfg.HandleMuteUser)},
+		{"DELETE /api/users/{userID}/mute", http.HandlerFunc(cfg.HandleUnmuteUser)},
+		{"GET /api/blocks", http.HandlerFunc(cfg.HandleListBlocks)},
+		{"GET /api/mutes", http.HandlerFunc(cfg.HandleListMutes)},
+		{"GET /api/follow_requests", http.HandlerFunc(cfg.HandleListFollowRequests)},
+		{"POST /api/follow_requests/{userID}/approve", http.HandlerFunc(cfg.HandleApproveFollowRequest)},
+		{"DELETE /api/follow_requests/{userID}", http.HandlerFunc(cfg.HandleDeclineFollowRequest)},
+		{"DELETE /api/chirps/{chirpID}", http.HandlerFunc(cfg.HandleDeleteChirp)},
+		{"POST /api/chirps/{chirpID}/like", http.HandlerFunc(cfg.HandleLikeChirp)},
+		{"DELETE /api/chirps/{chirpID}/like", http.HandlerFunc(cfg.HandleUnlikeChirp)},
+		{"GET /api/notifications", http.HandlerFunc(cfg.HandleListNotifications)},
+		{"GET /api/notifications/unread_count", http.HandlerFunc(cfg.HandleUnreadNotificationCount)},
+		{"POST /api/notifications/read", http.HandlerFunc(cfg.HandleMarkAllNotificationsRead)},
+		{"POST /api/notifications/{notificationID}/read", http.HandlerFunc(cfg.HandleMarkNotificationRead)},
+		{"POST /api/conversations", http.HandlerFunc(cfg.HandleCreateConversation)},
+		{"GET /api/conversations", http.HandlerFunc(cfg.HandleListConversations)},
+		{"POST /api/conversations/{conversationID}/messages", http.HandlerFunc(cfg.HandleSendMessage)},
+		{"GET /api/conversations/{conversationID}/messages", http.HandlerFunc(cfg.HandleListMessages)},
+		{"POST /api/conversations/{conversationID}/messages/{messageID}/read", http.HandlerFunc(cfg.HandleMarkMessageRead)},
+		{"GET /api/stream", http.HandlerFunc(cfg.HandleStream)},
+		{"GET /api/ws", http.HandlerFunc(cfg.HandleWebSocket)},
+		{"POST /api/media", http.HandlerFunc(cfg.HandleUploadMedia)},
+		{"GET /api/media/{mediaID}", http.HandlerFunc(cfg.HandleGetMedia)},
+		{"GET /api/media/{mediaID}/thumbnail", http.HandlerFunc(cfg.HandleGetMediaThumbnail)},
+		{"POST /api/polka/webhooks", http.HandlerFunc(cfg.HandleStripeEvent)},
+		{"POST /api/webhooks", http.HandlerFunc(cfg.HandleCreateWebhookSubscription)},
+		{"GET /api/webhooks", http.HandlerFunc(cfg.HandleGetWebhookSubscriptions)},
+		{"DELETE /api/webhooks/{subscriptionID}", http.HandlerFunc(cfg.HandleDeleteWebhookSubscription)},
+		{"GET /api/webhooks/{subscriptionID}/deliveries", http.HandlerFunc(cfg.HandleGetWebhookDeliveries)},
+		{"GET /admin/webhooks/events", http.HandlerFunc(cfg.HandleListWebhookEvents)},
+		{"POST /admin/webhooks/events/{eventID}/replay", http.HandlerFunc(cfg.HandleReplayWebhookEvent)},
+	}
+}
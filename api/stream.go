@@ -0,0 +1,477 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// defaultStreamHeartbeat is how often an idle stream sends a heartbeat unless
+// StreamHeartbeat is set.
+const defaultStreamHeartbeat = 15 * time.Second
+
+// streamWriteTimeout bounds every write to a stream. It replaces the server's
+// write timeout, which would otherwise end every stream after a while.
+const streamWriteTimeout = 10 * time.Second
+
+// streamRetry is the reconnection delay, in milliseconds, suggested to clients.
+const streamRetry = 3000
+
+// publishChirp publishes a chirp event to the real-time APIs, addressed to the
+// users mentioned in the chirp and only reaching the users who may see it.
+func (cfg *ApiConfig) publishChirp(ctx context.Context, eventType string, chirp MappedChirp, author database.User, mentioned []uuid.UUID) {
+	if cfg.Stream == nil && cfg.Events == nil {
+		return
+	}
+	data, err := json.Marshal(chirp)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode stream event", "event", eventType, "error", err)
+		return
+	}
+	cfg.publish(ctx, stream.Event{Type: eventType, AuthorID: chirp.UserID, Recipients: mentioned, Visibility: eventVisibility(chirp.Visibility, author), Data: data})
+}
+
+// eventVisibility returns the visibility of a chirp to everyone but its author
+// and the users it mentions: the public and unlisted chirps of protected users
+// are followers-only.
+func eventVisibility(visibility string, author database.User) string {
+	if author.IsProtected && (visibility == stream.VisibilityPublic || visibility == stream.VisibilityUnlisted) {
+		return stream.VisibilityFollowers
+	}
+	return visibility
+}
+
+// chirpWebhookOwner returns the user whose webhook subscriptions receive the
+// events of a chirp: everyone, as a null ID, if the chirp is public and its
+// author is not protected, or only the author otherwise.
+func chirpWebhookOwner(chirp MappedChirp, author database.User) uuid.NullUUID {
+	if eventVisibility(chirp.Visibility, author) == stream.VisibilityPublic {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: author.ID, Valid: true}
+}
+
+// ChirpDeletedWebhook returns the owner and data of the chirp.deleted webhook
+// of a chirp, so that chirps deleted outside the API, such as by the admin
+// commands, are reported to the same subscriptions.
+func ChirpDeletedWebhook(chirp database.Chirp, author database.User) (uuid.NullUUID, MappedChirp) {
+	deleted := mapChirp(chirp, nil)
+	return chirpWebhookOwner(deleted, author), deleted
+}
+
+// publish publishes an event to the real-time APIs of every instance through
+// the event bus, or only to this instance's broker if there is none.
+func (cfg *ApiConfig) publish(ctx context.Context, event stream.Event) {
+	if cfg.Events == nil {
+		cfg.Stream.Publish(event)
+		return
+	}
+	if err := cfg.Events.Publish(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish event to the event bus", "event", event.Type, "error", err)
+	}
+}
+
+// mentionPattern matches an @username mention that is not part of a longer
+// word, such as an email address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_@])@([A-Za-z0-9_]{3,15})\b`)
+
+// extractMentions returns the distinct usernames mentioned in a chirp body, in
+// lower case and in order of appearance.
+func extractMentions(body string) []string {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.ToLower(match[1])
+		if !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames
+}
+
+// mentionedUsers returns the IDs of the users mentioned in a chirp, other than
+// its author, who may learn about the mention: users the author is hidden from
+// are left out, and so are the users who may not see the chirp because it is
+// for followers only, or its author is protected, and they do not follow them.
+// Chirps for mentioned users only reach every mentioned user the author is not
+// hidden from.
+// Mentions of unknown usernames are ignored, and so are lookup failures, which
+// only cost the mentioned users an event and a notification.
+func (cfg *ApiConfig) mentionedUsers(ctx context.Context, chirp MappedChirp) []uuid.UUID {
+	usernames := extractMentions(chirp.Body)
+	if len(usernames) == 0 {
+		return nil
+	}
+	rows, err := cfg.DbQueries.GetUserIDsByUsernames(ctx, usernames)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up mentioned users", "chirp_id", chirp.ID, "error", err)
+		return nil
+	}
+	var ids []uuid.UUID
+	for _, row := range rows {
+		if row.ID != chirp.UserID {
+			ids = append(ids, row.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	mentionable, err := cfg.DbQueries.FilterMentionable(ctx, database.FilterMentionableParams{
+		AuthorID:   chirp.UserID,
+		UserIds:    ids,
+		Visibility: chirp.Visibility,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up who may see the mentions", "chirp_id", chirp.ID, "error", err)
+		return nil
+	}
+	return slices.DeleteFunc(ids, func(id uuid.UUID) bool { return !slices.Contains(mentionable, id) })
+}
+
+// HandleStream streams chirp events as Server-Sent Events: "chirp.created" with
+// the new chirp and "chirp.deleted" with the deleted one. By default it streams
+// every chirp; author_id limits it to one author, and feed=timeline to the
+// authenticated user and the users they follow. Only public chirps are
+// streamed by default, and public or unlisted ones with author_id. The
+// timeline has every chirp the user may see: the chirps of protected users and
+// followers-only chirps too, and chirps for mentioned users only if they are
+// mentioned. Logged in users never receive the chirps of the users they
+// blocked or muted or who blocked them, and follows, blocks and mutes apply to
+// open streams as they happen.
+//
+// Every event has an ID. A client that reconnects with the Last-Event-ID header,
+// or the last_event_id query parameter, first receives the events it missed. If
+// some of them are no longer buffered, a "reset" event tells it to fetch the
+// chirps again. Idle streams send a comment every heartbeat interval, and
+// clients that fall too far behind are disconnected so that they reconnect and
+// resume.
+func (cfg *ApiConfig) HandleStream(w http.ResponseWriter, r *http.Request) {
+	feed, ok := queryEnum(w, r, "feed", "global", "timeline")
+	if !ok {
+		return
+	}
+	authorID, ok := queryUUID(w, r, "author_id")
+	if !ok {
+		return
+	}
+	lastID, ok := lastEventID(w, r)
+	if !ok {
+		return
+	}
+
+	if feed == "timeline" && authorID.Valid {
+		respondWithError(w, r, CodeInvalidParameter, "author_id cannot be combined with the timeline feed", nil, FieldError{
+			Field:   "author_id",
+			Code:    "conflict",
+			Message: "must not be set with feed=timeline",
+		})
+		return
+	}
+	var viewer uuid.NullUUID
+	if feed == "timeline" {
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+		viewer = uuid.NullUUID{UUID: userID, Valid: true}
+	} else if viewer, ok = cfg.optionalViewer(w, r); !ok {
+		return
+	}
+
+	// Only chirp events are streamed, and only the timeline includes the
+	// chirps for followers, whose timelines are made of
+	var filter stream.Filter = stream.Event.IsPublicChirp
+	if authorID.Valid {
+		filter = func(event stream.Event) bool { return event.IsOpenChirp() && event.AuthorID == authorID.UUID }
+	}
+	if viewer.Valid {
+		// The stream follows the viewer's follows, blocks and mutes as they
+		// change, and leaves out the chirps of the users hidden from them
+		rel, err := cfg.loadStreamRelations(r.Context(), viewer.UUID)
+		if err != nil {
+			respondWithError(w, r, CodeInternal, "Failed to get followed and hidden users", err)
+			return
+		}
+		if feed == "timeline" {
+			filter = rel.timelineFilter()
+		} else {
+			filter = rel.hiding(filter)
+		}
+	}
+
+	sub, replay, complete := cfg.Stream.Subscribe(filter, lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	shutdown := cfg.trackStream()
+	defer cfg.untrackStream(shutdown)
+
+	cfg.Metrics.StreamOpened()
+	reason := "client_gone"
+	defer func() { cfg.Metrics.StreamClosed(reason) }()
+
+	rc := http.NewResponseController(w)
+	send := func(message string) bool {
+		// Not every ResponseWriter supports deadlines; the write still works
+		_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprint(w, message); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	message := fmt.Sprintf("retry: %d\n\n", streamRetry)
+	if lastID != 0 && !complete {
+		message += "event: reset\ndata: {}\n\n"
+	}
+	for _, event := range replay {
+		message += formatEvent(event)
+	}
+	if !send(message) {
+		reason = "write_failed"
+		return
+	}
+
+	heartbeat := cfg.StreamHeartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultStreamHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shutdown:
+			reason = "shutdown"
+			return
+		case <-sub.Done():
+			reason = "slow_consumer"
+			return
+		case event := <-sub.Events():
+			if !send(formatEvent(event)) {
+				reason = "write_failed"
+				return
+			}
+		case <-ticker.C:
+			if !send(": heartbeat\n\n") {
+				reason = "write_failed"
+				return
+			}
+		}
+	}
+}
+
+// CloseStreams ends every event stream and makes new ones end right after
+// they open, so that clients reconnect to another instance. The HTTP server
+// waits for streams like any other request, so it must be called when shutting
+// down.
+func (cfg *ApiConfig) CloseStreams() {
+	cfg.streamsMu.Lock()
+	defer cfg.streamsMu.Unlock()
+	cfg.streamsClosed = true
+	for shutdown := range cfg.streams {
+		close(shutdown)
+	}
+	cfg.streams = nil
+}
+
+// trackStream registers an open stream and returns the channel closed when the
+// server shuts down, which is already closed if it is shutting down.
+func (cfg *ApiConfig) trackStream() chan struct{} {
+	cfg.streamsMu.Lock()
+	defer cfg.streamsMu.Unlock()
+	shutdown := make(chan struct{})
+	if cfg.streamsClosed {
+		close(shutdown)
+		return shutdown
+	}
+	if cfg.streams == nil {
+		cfg.streams = map[chan struct{}]struct{}{}
+	}
+	cfg.streams[shutdown] = struct{}{}
+	return shutdown
+}
+
+func (cfg *ApiConfig) untrackStream(shutdown chan struct{}) {
+	cfg.streamsMu.Lock()
+	defer cfg.streamsMu.Unlock()
+	delete(cfg.streams, shutdown)
+}
+
+// streamRelations tracks whom a user with an open stream follows and who is
+// hidden from them. It is loaded when the stream opens and kept up to date by
+// the follow, block and mute events the stream's filter sees.
+type streamRelations struct {
+	userID uuid.UUID
+
+	mu        sync.Mutex
+	followees map[uuid.UUID]bool
+	blocking  map[uuid.UUID]bool // users the user blocked
+	blockedBy map[uuid.UUID]bool // users who blocked the user
+	muting    map[uuid.UUID]bool // users the user muted
+}
+
+// loadStreamRelations loads the users the user follows and the users hidden
+// from them.
+func (cfg *ApiConfig) loadStreamRelations(ctx context.Context, userID uuid.UUID) (*streamRelations, error) {
+	followees, err := cfg.DbQueries.ListFolloweeIDs(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get followed users: %w", err)
+	}
+	hidden, err := cfg.DbQueries.ListHiddenUsers(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hidden users: %w", err)
+	}
+
+	rel := &streamRelations{
+		userID:    userID,
+		followees: make(map[uuid.UUID]bool, len(followees)),
+		blocking:  map[uuid.UUID]bool{},
+		blockedBy: map[uuid.UUID]bool{},
+		muting:    map[uuid.UUID]bool{},
+	}
+	for _, id := range followees {
+		rel.followees[id] = true
+	}
+	for _, row := range hidden {
+		switch row.Reason {
+		case "block":
+			rel.blocking[row.HiddenID] = true
+		case "blocked_by":
+			rel.blockedBy[row.HiddenID] = true
+		case "mute":
+			rel.muting[row.HiddenID] = true
+		}
+	}
+	return rel, nil
+}
+
+// update applies a follow, block or mute event that involves the user. Other
+// events are ignored.
+func (rel *streamRelations) update(event stream.Event) {
+	switch event.Type {
+	case stream.EventUserFollowed, stream.EventUserUnfollowed:
+		var follow FollowEvent
+		if event.AuthorID != rel.userID || json.Unmarshal(event.Data, &follow) != nil {
+			return
+		}
+		rel.mu.Lock()
+		defer rel.mu.Unlock()
+		if event.Type == stream.EventUserFollowed {
+			rel.followees[follow.FolloweeID] = true
+		} else {
+			delete(rel.followees, follow.FolloweeID)
+		}
+
+	case stream.EventUserBlocked, stream.EventUserUnblocked, stream.EventUserMuted, stream.EventUserUnmuted:
+		var relation RelationEvent
+		if json.Unmarshal(event.Data, &relation) != nil {
+			return
+		}
+		rel.mu.Lock()
+		defer rel.mu.Unlock()
+		switch {
+		case event.Type == stream.EventUserBlocked && relation.UserID == rel.userID:
+			// Blocks end the follows between the two users
+			rel.blocking[relation.OtherID] = true
+			delete(rel.followees, relation.OtherID)
+		case event.Type == stream.EventUserBlocked && relation.OtherID == rel.userID:
+			rel.blockedBy[relation.UserID] = true
+			delete(rel.followees, relation.UserID)
+		case event.Type == stream.EventUserUnblocked && relation.UserID == rel.userID:
+			delete(rel.blocking, relation.OtherID)
+		case event.Type == stream.EventUserUnblocked && relation.OtherID == rel.userID:
+			delete(rel.blockedBy, relation.UserID)
+		case event.Type == stream.EventUserMuted && relation.UserID == rel.userID:
+			rel.muting[relation.OtherID] = true
+		case event.Type == stream.EventUserUnmuted && relation.UserID == rel.userID:
+			delete(rel.muting, relation.OtherID)
+		}
+	}
+}
+
+// timelineFilter returns a filter selecting the chirps of the user's timeline
+// that they may see, which keeps the relations up to date.
+func (rel *streamRelations) timelineFilter() stream.Filter {
+	return func(event stream.Event) bool {
+		rel.update(event)
+		return event.ReachesFollower(rel.userID) && rel.inTimeline(event.AuthorID)
+	}
+}
+
+// hiding returns a filter selecting the events of filter whose author is not
+// hidden from the user, which keeps the relations up to date.
+func (rel *streamRelations) hiding(filter stream.Filter) stream.Filter {
+	return func(event stream.Event) bool {
+		rel.update(event)
+		return filter(event) && !rel.hides(event.AuthorID)
+	}
+}
+
+// hides reports whether the user blocked or muted the other user, or was
+// blocked by them.
+func (rel *streamRelations) hides(otherID uuid.UUID) bool {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return rel.blocking[otherID] || rel.blockedBy[otherID] || rel.muting[otherID]
+}
+
+// inTimeline reports whether the chirps of the author belong in the user's
+// timeline: the author is the user, or a user they follow and do not hide.
+func (rel *streamRelations) inTimeline(authorID uuid.UUID) bool {
+	if authorID == rel.userID {
+		return true
+	}
+	rel.mu.Lock()
+	followed := rel.followees[authorID]
+	rel.mu.Unlock()
+	return followed && !rel.hides(authorID)
+}
+
+// lastEventID returns the ID of the last event a reconnecting client received,
+// from the Last-Event-ID header or the last_event_id query parameter, or 0. If
+// it is invalid, it responds with a problem and returns false.
+func lastEventID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	if raw == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		respondWithError(w, r, CodeInvalidParameter, "Invalid last event ID", err, FieldError{
+			Field:   "Last-Event-ID",
+			Code:    "invalid_event_id",
+			Message: "must be an event ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// formatEvent formats an event as a Server-Sent Events message. The data is
+// JSON, which never contains a raw newline.
+func formatEvent(event stream.Event) string {
+	return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
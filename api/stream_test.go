@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// sseMessage is a Server-Sent Events message; comments have only Comment set.
+type sseMessage struct {
+	ID, Event, Data, Comment string
+}
+
+// openStream connects to the stream and returns a function reading its next
+// message.
+func openStream(t *testing.T, url string, header http.Header) (*http.Response, func() sseMessage) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		defer close(lines)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return resp, func() sseMessage {
+		t.Helper()
+		var msg sseMessage
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("Stream ended")
+				}
+				if comment, ok := strings.CutPrefix(line, ": "); ok {
+					msg.Comment = comment
+					continue
+				}
+				field, value, _ := strings.Cut(line, ": ")
+				switch field {
+				case "":
+					if msg != (sseMessage{}) {
+						return msg
+					}
+				case "id":
+					msg.ID = value
+				case "event":
+					msg.Event = value
+				case "data":
+					msg.Data = value
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for a stream message")
+			}
+		}
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	broker := stream.NewBroker(2, 8)
+	cfg := &ApiConfig{Stream: broker, StreamHeartbeat: 50 * time.Millisecond}
+	server := httptest.NewServer(http.HandlerFunc(cfg.HandleStream))
+	defer server.Close()
+
+	alice, bob := uuid.New(), uuid.New()
+	waitForSubscribers := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); broker.Subscribers() != n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d subscribers, got %d", n, broker.Subscribers())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("live events", func(t *testing.T) {
+		resp, next := openStream(t, server.URL+"?author_id="+bob.String(), nil)
+		if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+			t.Fatalf("Expected an event stream, got %q", got)
+		}
+		waitForSubscribers(1)
+
+		broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: alice, Data: []byte(`{"body":"alice"}`)})
+		broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: bob, Visibility: stream.VisibilityFollowers, Data: []byte(`{"body":"followers"}`)})
+		broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: bob, Visibility: stream.VisibilityMentioned, Data: []byte(`{"body":"mentioned"}`)})
+		event := broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: bob, Visibility: stream.VisibilityUnlisted, Data: []byte(`{"body":"bob"}`)})
+
+		msg := next()
+		for msg.Comment != "" {
+			msg = next()
+		}
+		want := sseMessage{ID: strconv.FormatUint(event.ID, 10), Event: stream.EventChirpCreated, Data: `{"body":"bob"}`}
+		if msg != want {
+			t.Errorf("Expected %+v, got %+v", want, msg)
+		}
+		if msg := next(); msg.Comment != "heartbeat" {
+			t.Errorf("Expected a heartbeat, got %+v", msg)
+		}
+	})
+	waitForSubscribers(0)
+
+	t.Run("resume", func(t *testing.T) {
+		first := broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: alice, Data: []byte(`1`)})
+		second := broker.Publish(stream.Event{Type: stream.EventChirpDeleted, AuthorID: alice, Data: []byte(`2`)})
+
+		_, next := openStream(t, server.URL, http.Header{"Last-Event-ID": {strconv.FormatUint(first.ID, 10)}})
+		if msg := next(); msg.ID != strconv.FormatUint(second.ID, 10) || msg.Event != stream.EventChirpDeleted {
+			t.Errorf("Expected the missed event %d, got %+v", second.ID, msg)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		_, next := openStream(t, server.URL+"?last_event_id=1", nil)
+		if msg := next(); msg.Event != "reset" {
+			t.Errorf("Expected a reset event, got %+v", msg)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"?feed=everything", "?author_id=nope", "?last_event_id=-1", "?feed=timeline&author_id=" + bob.String(), "?feed=timeline"} {
+			rec := httptest.NewRecorder()
+			cfg.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/api/stream"+query, nil))
+			if rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s: expected a 400 or 401 status, got %d", query, rec.Code)
+			}
+		}
+	})
+}
+
+func TestStreamShutdown(t *testing.T) {
+	broker := stream.NewBroker(0, 0)
+	cfg := &ApiConfig{Stream: broker, StreamHeartbeat: time.Hour}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(cfg.HandleStream))
+	server.Config.RegisterOnShutdown(cfg.CloseStreams)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	openStream(t, server.URL, nil)
+	for deadline := time.Now().Add(5 * time.Second); broker.Subscribers() != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the stream to subscribe")
+		}
+	}
+
+	// Shutdown waits for open requests, so it only returns once the stream ends
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed after %v: %v", time.Since(start), err)
+	}
+	if broker.Subscribers() != 0 {
+		t.Errorf("Expected the stream to be closed, got %d subscribers", broker.Subscribers())
+	}
+
+	// Streams opened while shutting down end right away
+	rec := httptest.NewRecorder()
+	cfg.HandleStream(rec, httptest.NewRequest(http.MethodGet, "/api/stream", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "retry: ") {
+		t.Errorf("Expected a stream that ends after the retry message, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChirpDeletedWebhook(t *testing.T) {
+	author := database.User{ID: uuid.New()}
+	protected := database.User{ID: author.ID, IsProtected: true}
+	tests := []struct {
+		name       string
+		visibility string
+		author     database.User
+		want       uuid.NullUUID
+	}{
+		{name: "public", visibility: "public", author: author},
+		{name: "followers", visibility: "followers", author: author, want: uuid.NullUUID{UUID: author.ID, Valid: true}},
+		{name: "protected author", visibility: "public", author: protected, want: uuid.NullUUID{UUID: author.ID, Valid: true}},
+	}
+	for _, tt := range tests {
+		chirp := database.Chirp{ID: uuid.New(), UserID: author.ID, Body: "Bye", Visibility: tt.visibility}
+		owner, data := ChirpDeletedWebhook(chirp, tt.author)
+		if owner != tt.want || data.ID != chirp.ID || data.Body != "Bye" {
+			t.Errorf("%s: expected owner %v and the chirp, got %v and %+v", tt.name, tt.want, owner, data)
+		}
+	}
+}
+
+// nextEvent returns the next event queued for a subscription.
+func nextEvent(t *testing.T, sub *stream.Subscription) stream.Event {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	default:
+		t.Fatal("Expected an event to be queued")
+		return stream.Event{}
+	}
+}
+
+func TestStreamRelations(t *testing.T) {
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	relationEvent := func(eventType string, userID, otherID uuid.UUID) stream.Event {
+		data, _ := json.Marshal(RelationEvent{UserID: userID, OtherID: otherID})
+		return stream.Event{Type: eventType, AuthorID: userID, Data: data}
+	}
+	chirp := func(authorID uuid.UUID) stream.Event {
+		return stream.Event{Type: stream.EventChirpCreated, AuthorID: authorID, Data: []byte(`{}`)}
+	}
+
+	// Alice's timeline follows bob and carol; her global feed hides no one yet
+	broker := stream.NewBroker(0, 0)
+	timeline := &streamRelations{
+		userID:    alice,
+		followees: map[uuid.UUID]bool{bob: true, carol: true},
+		blocking:  map[uuid.UUID]bool{},
+		blockedBy: map[uuid.UUID]bool{},
+		muting:    map[uuid.UUID]bool{},
+	}
+	global := &streamRelations{
+		userID:    alice,
+		followees: map[uuid.UUID]bool{},
+		blocking:  map[uuid.UUID]bool{},
+		blockedBy: map[uuid.UUID]bool{},
+		muting:    map[uuid.UUID]bool{},
+	}
+	timelineSub, _, _ := broker.Subscribe(timeline.timelineFilter(), 0)
+	defer timelineSub.Close()
+	globalSub, _, _ := broker.Subscribe(global.hiding(stream.Event.IsPublicChirp), 0)
+	defer globalSub.Close()
+
+	broker.Publish(chirp(bob))
+	if event := nextEvent(t, timelineSub); event.AuthorID != bob {
+		t.Errorf("Expected bob's chirp on the timeline, got %+v", event)
+	}
+	if event := nextEvent(t, globalSub); event.AuthorID != bob {
+		t.Errorf("Expected bob's chirp on the global feed, got %+v", event)
+	}
+
+	// Bob blocking alice and alice muting carol end their chirps on both
+	// streams that are already open, while alice's own chirps still arrive
+	broker.Publish(relationEvent(stream.EventUserBlocked, bob, alice))
+	broker.Publish(relationEvent(stream.EventUserMuted, alice, carol))
+	broker.Publish(chirp(bob))
+	broker.Publish(chirp(carol))
+	own := broker.Publish(chirp(alice))
+	for _, sub := range []*stream.Subscription{timelineSub, globalSub} {
+		if event := nextEvent(t, sub); event.ID != own.ID {
+			t.Errorf("Expected only alice's own chirp after the block and mute, got %+v", event)
+		}
+	}
+
+	// Unblocking does not restore the follow the block ended, but unmuting
+	// restores carol
+	broker.Publish(relationEvent(stream.EventUserUnblocked, bob, alice))
+	broker.Publish(relationEvent(stream.EventUserUnmuted, alice, carol))
+	broker.Publish(chirp(bob))
+	broker.Publish(chirp(carol))
+	if event := nextEvent(t, timelineSub); event.AuthorID != carol {
+		t.Errorf("Expected carol's chirp on the timeline after the unmute, got %+v", event)
+	}
+	for _, author := range []uuid.UUID{bob, carol} {
+		if event := nextEvent(t, globalSub); event.AuthorID != author {
+			t.Errorf("Expected the chirp of %s on the global feed, got %+v", author, event)
+		}
+	}
+	if len(timelineSub.Events()) != 0 {
+		t.Error("Expected no more timeline events")
+	}
+}
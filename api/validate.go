@@ -0,0 +1,378 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/auth"
+)
+
+// maxBodyBytes caps the size of every request body read by the API.
+const maxBodyBytes = 1 << 20
+
+// usernamePattern matches valid usernames, which are stored in lower case.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+
+// decodeRequest decodes the JSON body of the request into dst, a pointer to a
+// request struct, and validates it against the struct's `validate` tags. Bodies
+// larger than maxBodyBytes, with unknown fields or with trailing data are
+// rejected. If the body is invalid, it responds with a problem listing every
+// invalid field and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	if err != nil {
+		respondWithDecodeError(w, r, err)
+		return false
+	}
+
+	if fields := validateStruct(dst); len(fields) > 0 {
+		respondWithError(w, r, CodeValidationFailed, "Request body is invalid", nil, fields...)
+		return false
+	}
+	return true
+}
+
+// respondWithDecodeError responds with a problem describing why a JSON body
+// could not be decoded, pointing at the offending field where possible.
+func respondWithDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		respondWithError(w, r, CodeBodyTooLarge, fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesErr.Limit), err)
+	case errors.As(err, &syntaxErr):
+		respondWithError(w, r, CodeInvalidBody, fmt.Sprintf("Request body contains malformed JSON at offset %d", syntaxErr.Offset), err)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		respondWithError(w, r, CodeInvalidBody, "Request body contains a field of the wrong type", err, FieldError{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: "must be of type " + jsonTypeName(typeErr.Type),
+		})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		respondWithError(w, r, CodeInvalidBody, "Request body contains an unknown field", err, FieldError{
+			Field:   field,
+			Code:    "unknown_field",
+			Message: "is not a known field",
+		})
+	case errors.Is(err, io.EOF):
+		respondWithError(w, r, CodeInvalidBody, "Request body must not be empty", err)
+	default:
+		respondWithError(w, r, CodeInvalidBody, "Invalid request body", err)
+	}
+}
+
+// jsonTypeName names a Go type the way a JSON client would know it.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		if t == reflect.TypeOf(uuid.UUID{}) {
+			return "string"
+		}
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// validateStruct checks the fields of the struct v points to against their
+// `validate` tags and returns an error for every invalid field, named after its
+// JSON key. Rules are separated by commas:
+//
+//	required     strings and lists must not be empty, UUIDs must not be nil
+//	email        a string must be an email address
+//	url          a string must be an absolute http or https URL
+//	username     a string must be 3 to 15 letters, digits or underscores
+//	min=<n>      a string must have at least n characters, a list n items
+//	max=<n>      a string must have at most n characters, a list n items
+//	oneof=<a b>  a string, or every item of a list, must be one of the values
+//
+// Pointers are checked against the value they point to, and nil ones are
+// empty. Rules other than required are skipped for empty values.
+func validateStruct(v any) []FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []FieldError
+	for _, field := range reflect.VisibleFields(value.Type()) {
+		rules, ok := field.Tag.Lookup("validate")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		if fieldErr := validateField(value.FieldByIndex(field.Index), rules); fieldErr != nil {
+			fieldErr.Field = name
+			fields = append(fields, *fieldErr)
+		}
+	}
+	return fields
+}
+
+// validateField checks a single value against comma separated rules and
+// returns the first rule it breaks.
+func validateField(value reflect.Value, rules string) *FieldError {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			value = reflect.Zero(value.Type().Elem())
+		} else {
+			value = value.Elem()
+		}
+	}
+	empty := value.IsZero() || (value.Kind() == reflect.Slice && value.Len() == 0)
+
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		if name == "required" {
+			if empty {
+				return &FieldError{Code: "required", Message: "is required"}
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+
+		switch name {
+		case "email":
+			address, err := mail.ParseAddress(value.String())
+			if err != nil || address.Address != value.String() {
+				return &FieldError{Code: "invalid_email", Message: "must be an email address"}
+			}
+		case "url":
+			target, err := url.Parse(value.String())
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return &FieldError{Code: "invalid_url", Message: "must be an absolute http or https URL"}
+			}
+		case "username":
+			if !usernamePattern.MatchString(value.String()) {
+				return &FieldError{Code: "invalid_username", Message: "must be 3 to 15 letters, digits or underscores"}
+			}
+		case "min":
+			n, _ := strconv.Atoi(arg)
+			if length(value) < n {
+				return &FieldError{Code: "too_short", Message: fmt.Sprintf("must be at least %d %s long", n, unit(value))}
+			}
+		case "max":
+			n, _ := strconv.Atoi(arg)
+			if length(value) > n {
+				return &FieldError{Code: "too_long", Message: fmt.Sprintf("must be at most %d %s long", n, unit(value))}
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			values := []string{value.String()}
+			if value.Kind() == reflect.Slice {
+				values = value.Interface().([]string)
+			}
+			for _, v := range values {
+				if !contains(allowed, v) {
+					return &FieldError{Code: "oneof", Message: "must be one of " + strings.Join(allowed, ", ")}
+				}
+			}
+		default:
+			panic("unknown validation rule " + rule)
+		}
+	}
+	return nil
+}
+
+// length returns the number of characters of a string or items of a list.
+func length(value reflect.Value) int {
+	if value.Kind() == reflect.String {
+		return utf8.RuneCountInString(value.String())
+	}
+	return value.Len()
+}
+
+func unit(value reflect.Value) string {
+	if value.Kind() == reflect.String {
+		return "characters"
+	}
+	return "items"
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// queryUUID parses the optional UUID query parameter with the given name. If it
+// is present but invalid, it responds with a problem and returns false.
+func queryUUID(w http.ResponseWriter, r *http.Request, name string) (uuid.NullUUID, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return uuid.NullUUID{}, true
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		respondWithError(w, r, CodeInvalidParameter, "Invalid query parameter: "+name, err, FieldError{
+			Field:   name,
+			Code:    "invalid_uuid",
+			Message: "must be a UUID",
+		})
+		return uuid.NullUUID{}, false
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, true
+}
+
+// queryEnum returns the query parameter with the given name, or the first
+// allowed value if it is absent. If it is not one of the allowed values, it
+// responds with a problem and returns false.
+func queryEnum(w http.ResponseWriter, r *http.Request, name string, allowed ...string) (string, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return allowed[0], true
+	}
+	if !contains(allowed, raw) {
+		respondWithError(w, r, CodeInvalidParameter, "Invalid query parameter: "+name, nil, FieldError{
+			Field:   name,
+			Code:    "oneof",
+			Message: "must be one of " + strings.Join(allowed, ", "),
+		})
+		return "", false
+	}
+	return raw, true
+}
+
+// queryInt parses the optional integer query parameter with the given name,
+// which must lie between min and max. If it is present but invalid, it responds
+// with a problem and returns false.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, min, max int64) (sql.NullInt64, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return sql.NullInt64{}, true
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < min || n > max {
+		respondWithError(w, r, CodeInvalidParameter, "Invalid query parameter: "+name, err, FieldError{
+			Field:   name,
+			Code:    "out_of_range",
+			Message: fmt.Sprintf("must be an integer between %d and %d", min, max),
+		})
+		return sql.NullInt64{}, false
+	}
+	return sql.NullInt64{Int64: n, Valid: true}, true
+}
+
+// queryCursor decodes the optional "cursor" query parameter of a paginated
+// list. If it is present but invalid, it responds with a problem and returns
+// false.
+func queryCursor(w http.ResponseWriter, r *http.Request) (sql.NullTime, uuid.NullUUID, bool) {
+	afterTime, afterID, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithError(w, r, CodeInvalidParameter, "Invalid query parameter: cursor", err, FieldError{
+			Field:   "cursor",
+			Code:    "invalid_cursor",
+			Message: "must be the next_cursor of a previous page",
+		})
+		return sql.NullTime{}, uuid.NullUUID{}, false
+	}
+	return afterTime, afterID, true
+}
+
+// pathUUID parses the UUID path parameter with the given name. If it is
+// invalid, it responds with a problem and returns false.
+func pathUUID(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue(name))
+	if err != nil {
+		respondWithError(w, r, CodeInvalidParameter, "Invalid path parameter: "+name, err, FieldError{
+			Field:   name,
+			Code:    "invalid_uuid",
+			Message: "must be a UUID",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// checkPassword checks a password chosen by a user against the password policy.
+// If it breaks the policy, it responds with a problem explaining why and
+// returns false.
+func (cfg *ApiConfig) checkPassword(w http.ResponseWriter, r *http.Request, password, email string) bool {
+	if cfg.PasswordPolicy == nil {
+		return true
+	}
+	err := cfg.PasswordPolicy.Check(password, email)
+	var passwordErr *auth.PasswordError
+	if errors.As(err, &passwordErr) {
+		respondWithError(w, r, CodeValidationFailed, "Password does not meet the password policy", nil, FieldError{
+			Field:   "password",
+			Code:    passwordErr.Code,
+			Message: passwordErr.Message,
+		})
+		return false
+	}
+	return true
+}
+
+// encodeCursor returns the cursor of the page after the item with the given
+// time and ID, for lists ordered by time and then ID.
+func encodeCursor(updatedAt time.Time, id uuid.UUID) string {
+	raw := updatedAt.UTC().Format(time.RFC3339Nano) + "," + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor returns the time and ID a cursor continues after, which are null
+// for an empty cursor.
+func decodeCursor(cursor string) (sql.NullTime, uuid.NullUUID, error) {
+	if cursor == "" {
+		return sql.NullTime{}, uuid.NullUUID{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return sql.NullTime{}, uuid.NullUUID{}, err
+	}
+	rawTime, rawID, found := strings.Cut(string(raw), ",")
+	if !found {
+		return sql.NullTime{}, uuid.NullUUID{}, errors.New("cursor has no ID")
+	}
+	updatedAt, err := time.Parse(time.RFC3339Nano, rawTime)
+	if err != nil {
+		return sql.NullTime{}, uuid.NullUUID{}, err
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return sql.NullTime{}, uuid.NullUUID{}, err
+	}
+	return sql.NullTime{Time: updatedAt, Valid: true}, uuid.NullUUID{UUID: id, Valid: true}, nil
+}
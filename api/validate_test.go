@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/webhooks"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   ErrorCode
+		wantFields []string
+	}{
+		{
+			name:       "valid",
+			body:       `{"email": "user@example.com", "password": "hunter22"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "empty body",
+			body:       ``,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidBody,
+		},
+		{
+			name:       "malformed",
+			body:       `{"email": `,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidBody,
+		},
+		{
+			name:       "unknown field",
+			body:       `{"email": "user@example.com", "password": "hunter22", "admin": true}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidBody,
+			wantFields: []string{"admin"},
+		},
+		{
+			name:       "wrong type",
+			body:       `{"email": 42, "password": "hunter22"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidBody,
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "trailing data",
+			body:       `{"email": "user@example.com", "password": "hunter22"} {}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeInvalidBody,
+		},
+		{
+			name:       "invalid fields",
+			body:       `{"email": "not an email", "password": ""}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeValidationFailed,
+			wantFields: []string{"email", "password"},
+		},
+		{
+			name:       "too large",
+			body:       `{"email": "` + strings.Repeat("a", maxBodyBytes) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantCode:   CodeBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(tt.body))
+
+			var createUserRequest CreateUserRequest
+			if decodeRequest(rec, req, &createUserRequest) {
+				rec.WriteHeader(http.StatusOK)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+
+			var problem Problem
+			if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+				t.Fatalf("Failed to decode problem: %v", err)
+			}
+			if problem.Code != tt.wantCode {
+				t.Errorf("Expected code %s, got %s", tt.wantCode, problem.Code)
+			}
+			var fields []string
+			for _, field := range problem.Errors {
+				fields = append(fields, field.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("Expected invalid fields %v, got %v", tt.wantFields, fields)
+			}
+		})
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	tests := []struct {
+		name    string
+		request any
+		want    []FieldError
+	}{
+		{
+			name:    "chirp too long",
+			request: &CreateChirpRequest{Body: strings.Repeat("é", 141)},
+			want:    []FieldError{{Field: "body", Code: "too_long", Message: "must be at most 140 characters long"}},
+		},
+		{
+			name:    "chirp of 140 multibyte characters",
+			request: &CreateChirpRequest{Body: strings.Repeat("é", 140)},
+		},
+		{
+			name:    "missing webhook fields",
+			request: &CreateWebhookSubscriptionRequest{},
+			want: []FieldError{
+				{Field: "url", Code: "required", Message: "is required"},
+				{Field: "events", Code: "required", Message: "is required"},
+			},
+		},
+		{
+			name:    "invalid webhook fields",
+			request: &CreateWebhookSubscriptionRequest{URL: "ftp://example.com", Events: []string{"chirp.created", "chirp.liked"}},
+			want: []FieldError{
+				{Field: "url", Code: "invalid_url", Message: "must be an absolute http or https URL"},
+				{Field: "events", Code: "oneof", Message: "must be one of chirp.created, chirp.deleted, user.upgraded"},
+			},
+		},
+		{
+			name:    "empty profile",
+			request: &UpdateProfileRequest{},
+		},
+		{
+			name:    "removed username",
+			request: &UpdateProfileRequest{Username: ptr("")},
+		},
+		{
+			name:    "invalid profile fields",
+			request: &UpdateProfileRequest{Username: ptr("no-dashes"), Bio: ptr(strings.Repeat("a", 161)), Website: ptr("example.com")},
+			want: []FieldError{
+				{Field: "username", Code: "invalid_username", Message: "must be 3 to 15 letters, digits or underscores"},
+				{Field: "bio", Code: "too_long", Message: "must be at most 160 characters long"},
+				{Field: "website", Code: "invalid_url", Message: "must be an absolute http or https URL"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateStruct(tt.request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOptionalUUID(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		body string
+		want OptionalUUID
+	}{
+		{body: `{}`},
+		{body: `{"avatar_media_id": null}`, want: OptionalUUID{Set: true}},
+		{body: `{"avatar_media_id": "` + id.String() + `"}`, want: OptionalUUID{NullUUID: uuid.NullUUID{UUID: id, Valid: true}, Set: true}},
+	}
+	for _, tt := range tests {
+		var request UpdateProfileRequest
+		if err := json.Unmarshal([]byte(tt.body), &request); err != nil || request.AvatarMediaID != tt.want {
+			t.Errorf("%s: expected %+v, got %+v, %v", tt.body, tt.want, request.AvatarMediaID, err)
+		}
+	}
+}
+
+func TestWebhookEventsRule(t *testing.T) {
+	// The validation rule must accept exactly the event types the dispatcher knows
+	field, _ := reflect.TypeOf(CreateWebhookSubscriptionRequest{}).FieldByName("Events")
+	want := "required,oneof=" + strings.Join(webhooks.EventTypes, " ")
+	if got := field.Tag.Get("validate"); got != want {
+		t.Errorf("Expected the events rule %q, got %q", want, got)
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps?author_id=nope&sort=sideways", nil)
+
+	if _, ok := queryUUID(rec, req, "author_id"); ok {
+		t.Fatal("queryUUID should reject an invalid UUID")
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	if _, ok := queryEnum(rec, req, "sort", "asc", "desc"); ok {
+		t.Fatal("queryEnum should reject an unknown value")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/chirps", nil)
+	if authorID, ok := queryUUID(httptest.NewRecorder(), req, "author_id"); !ok || authorID.Valid {
+		t.Error("queryUUID should accept a missing parameter")
+	}
+	if sort, ok := queryEnum(httptest.NewRecorder(), req, "sort", "asc", "desc"); !ok || sort != "asc" {
+		t.Errorf("queryEnum should default to the first value, got %q", sort)
+	}
+	if limit, ok := queryInt(httptest.NewRecorder(), req, "limit", 1, 100); !ok || limit.Valid {
+		t.Error("queryInt should accept a missing parameter")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/chirps?limit=0&offset=ten", nil)
+	if _, ok := queryInt(httptest.NewRecorder(), req, "limit", 1, 100); ok {
+		t.Error("queryInt should reject a value out of range")
+	}
+	if _, ok := queryInt(httptest.NewRecorder(), req, "offset", 0, 100); ok {
+		t.Error("queryInt should reject a value that is not an integer")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/chirps?limit=20", nil)
+	if limit, ok := queryInt(httptest.NewRecorder(), req, "limit", 1, 100); !ok || limit.Int64 != 20 {
+		t.Errorf("queryInt should accept a value in range, got %v", limit)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+	id := uuid.New()
+
+	gotTime, gotID, err := decodeCursor(encodeCursor(updatedAt, id))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if !gotTime.Valid || !gotTime.Time.Equal(updatedAt) || !gotID.Valid || gotID.UUID != id {
+		t.Errorf("Expected %v and %v, got %v and %v", updatedAt, id, gotTime, gotID)
+	}
+
+	if gotTime, gotID, err := decodeCursor(""); err != nil || gotTime.Valid || gotID.Valid {
+		t.Errorf("Expected an empty cursor to start at the beginning, got %v, %v, %v", gotTime, gotID, err)
+	}
+	for _, cursor := range []string{"not base64!", "bm90IGEgY3Vyc29y", encodeCursor(updatedAt, id)[:20]} {
+		if _, _, err := decodeCursor(cursor); err == nil {
+			t.Errorf("Expected cursor %q to be rejected", cursor)
+		}
+	}
+}
+
+// TestValidationTags checks every rule of every request body, so that a typo in
+// a validate tag fails here rather than panicking while serving a request.
+func TestValidationTags(t *testing.T) {
+	requests := []any{
+		CreateChirpRequest{},
+		CreateUserRequest{},
+		LoginUserRequest{},
+		UpdateUserRequest{},
+		UpdateProfileRequest{},
+		CreateWebhookSubscriptionRequest{},
+		CreateConversationRequest{},
+		SendMessageRequest{},
+	}
+	for _, request := range requests {
+		requestType := reflect.TypeOf(request)
+		for _, field := range reflect.VisibleFields(requestType) {
+			rules, ok := field.Tag.Lookup("validate")
+			if !ok {
+				continue
+			}
+
+			// Rules are skipped for empty values, so check a non-empty one
+			sample := reflect.New(field.Type).Elem()
+			value := sample
+			if field.Type.Kind() == reflect.Pointer {
+				sample = reflect.New(field.Type.Elem())
+				value = sample.Elem()
+			}
+			switch value.Kind() {
+			case reflect.String:
+				value.SetString("x")
+			case reflect.Slice:
+				sample = reflect.MakeSlice(field.Type, 1, 1)
+				if item := sample.Index(0); item.Kind() == reflect.String {
+					item.SetString("x")
+				}
+			}
+			for _, rule := range strings.Split(rules, ",") {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s.%s: rule %q panicked: %v", requestType.Name(), field.Name, rule, r)
+						}
+					}()
+					validateField(sample, rule)
+				}()
+			}
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+	"github.com/Fepozopo/chirpy/internal/webhooks"
+)
+
+const webhookProviderPolka = "polka"
+
+// Statuses an event in the webhook_events inbox moves through.
+const (
+	webhookStatusPending    = "pending"
+	webhookStatusProcessing = "processing"
+	webhookStatusProcessed  = "processed"
+	webhookStatusIgnored    = "ignored"
+	webhookStatusFailed     = "failed"
+)
+
+// webhookEventLease is how long an event stays claimed. An event still
+// processing after that, because the instance processing it crashed or timed
+// out, can be claimed again.
+const webhookEventLease = 5 * time.Minute
+
+// claimWebhookEvent marks the event as processing if it is pending, failed or
+// its lease expired, and returns sql.ErrNoRows otherwise.
+func (cfg *ApiConfig) claimWebhookEvent(ctx context.Context, id uuid.UUID) (database.WebhookEvent, error) {
+	return cfg.DbQueries.ClaimWebhookEvent(ctx, database.ClaimWebhookEventParams{
+		ID:           id,
+		LeaseSeconds: int32(webhookEventLease / time.Second),
+	})
+}
+
+// webhookEventID returns the provider event ID used to deduplicate a webhook
+// delivery. It prefers the "id" field of the payload, then the Idempotency-Key
+// header, and falls back to a SHA-256 digest of the raw payload so that a
+// provider retrying the exact same body is still recognised.
+func webhookEventID(headers http.Header, event StripeEvent, payload []byte) string {
+	if event.ID != "" {
+		return event.ID
+	}
+	if key := headers.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	sum := sha256.Sum256(payload)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// recordWebhookEvent stores an incoming webhook event in the inbox. If an event
+// with the same provider and event ID has already been recorded, the existing
+// record is returned instead.
+func (cfg *ApiConfig) recordWebhookEvent(ctx context.Context, provider, eventID, eventType string, payload []byte) (database.WebhookEvent, error) {
+	event, err := cfg.DbQueries.CreateWebhookEvent(ctx, database.CreateWebhookEventParams{
+		Provider:  provider,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return cfg.DbQueries.GetWebhookEventByEventID(ctx, database.GetWebhookEventByEventIDParams{
+			Provider: provider,
+			EventID:  eventID,
+		})
+	}
+	return event, err
+}
+
+// processWebhookEvent applies a claimed webhook event and records the outcome in
+// the inbox. Events other than user.upgraded are marked as ignored. If the event
+// cannot be applied, it is marked as failed and the returned error code and
+// error describe why.
+func (cfg *ApiConfig) processWebhookEvent(ctx context.Context, event database.WebhookEvent) (ErrorCode, error) {
+	var stripeEvent StripeEvent
+	if err := json.Unmarshal(event.Payload, &stripeEvent); err != nil {
+		return cfg.failWebhookEvent(ctx, event, CodeInvalidBody, "Invalid event payload")
+	}
+
+	if stripeEvent.Event != "user.upgraded" {
+		if err := cfg.DbQueries.MarkWebhookEventProcessed(ctx, database.MarkWebhookEventProcessedParams{
+			ID:     event.ID,
+			Status: webhookStatusIgnored,
+		}); err != nil {
+			return CodeInternal, errors.New("Failed to update event")
+		}
+		return "", nil
+	}
+
+	upgraded, err := cfg.DbQueries.UpgradeUserToChirpyRed(ctx, stripeEvent.Data.UserID)
+	if err != nil {
+		return cfg.failWebhookEvent(ctx, event, CodeInternal, "Failed to upgrade user")
+	}
+	if upgraded == 0 {
+		return cfg.failWebhookEvent(ctx, event, CodeNotFound, "Failed to find user")
+	}
+
+	if err := cfg.DbQueries.MarkWebhookEventProcessed(ctx, database.MarkWebhookEventProcessedParams{
+		ID:     event.ID,
+		Status: webhookStatusProcessed,
+	}); err != nil {
+		return CodeInternal, errors.New("Failed to update event")
+	}
+
+	cfg.emitWebhook(ctx, webhooks.EventUserUpgraded, uuid.NullUUID{UUID: stripeEvent.Data.UserID, Valid: true}, map[string]uuid.UUID{
+		"user_id": stripeEvent.Data.UserID,
+	})
+	cfg.publishUpgrade(ctx, stripeEvent.Data.UserID)
+
+	return "", nil
+}
+
+// publishUpgrade publishes a Chirpy Red upgrade to the real-time APIs,
+// addressed to the upgraded user.
+func (cfg *ApiConfig) publishUpgrade(ctx context.Context, userID uuid.UUID) {
+	if cfg.Stream == nil && cfg.Events == nil {
+		return
+	}
+	data, err := json.Marshal(map[string]uuid.UUID{"user_id": userID})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode stream event", "event", stream.EventUserUpgraded, "error", err)
+		return
+	}
+	cfg.publish(ctx, stream.Event{
+		Type:       stream.EventUserUpgraded,
+		AuthorID:   userID,
+		Recipients: []uuid.UUID{userID},
+		Data:       data,
+	})
+}
+
+// failWebhookEvent marks the event as failed with the given message and returns
+// the error code and message as an error for the caller to respond with.
+func (cfg *ApiConfig) failWebhookEvent(ctx context.Context, event database.WebhookEvent, code ErrorCode, message string) (ErrorCode, error) {
+	err := cfg.DbQueries.MarkWebhookEventFailed(ctx, database.MarkWebhookEventFailedParams{
+		ID:        event.ID,
+		LastError: sql.NullString{String: message, Valid: true},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to mark webhook event as failed", "webhook_event_id", event.ID, "error", err)
+	}
+	return code, errors.New(message)
+}
+
+// authorizeAdmin checks the ApiKey in the Authorization header against the
+// configured admin key. If the admin key is not configured or does not match, it
+// responds with an error and returns false.
+func (cfg *ApiConfig) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.AdminKey == "" {
+		respondWithError(w, r, CodeAdminDisabled, "Admin API is disabled", nil)
+		return false
+	}
+
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil || apiKey != cfg.AdminKey {
+		cfg.Metrics.AuthFailure("invalid_api_key")
+		respondWithError(w, r, CodeInvalidAPIKey, "Invalid API key", err)
+		return false
+	}
+
+	return true
+}
+
+// mapWebhookEvent maps a database webhook event to a MappedWebhookEvent to
+// control the JSON keys.
+func mapWebhookEvent(event database.WebhookEvent) MappedWebhookEvent {
+	mappedEvent := MappedWebhookEvent{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		UpdatedAt: event.UpdatedAt,
+		Provider:  event.Provider,
+		EventID:   event.EventID,
+		EventType: event.EventType,
+		Payload:   event.Payload,
+		Status:    event.Status,
+		Attempts:  event.Attempts,
+		LastError: event.LastError.String,
+	}
+	if event.ProcessedAt.Valid {
+		processedAt := event.ProcessedAt.Time
+		mappedEvent.ProcessedAt = &processedAt
+	}
+	return mappedEvent
+}
+
+// HandleListWebhookEvents lists the recorded incoming webhook events, newest
+// first. The query parameter "status" can be used to only list events with the
+// given status, e.g. "failed". It requires the admin API key in the
+// Authorization header.
+func (cfg *ApiConfig) HandleListWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	var events []database.WebhookEvent
+	var err error
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		events, err = cfg.DbQueries.ListWebhookEvents(r.Context())
+	case webhookStatusPending, webhookStatusProcessing, webhookStatusProcessed, webhookStatusIgnored, webhookStatusFailed:
+		events, err = cfg.DbQueries.ListWebhookEventsByStatus(r.Context(), status)
+	default:
+		respondWithError(w, r, CodeInvalidParameter, fmt.Sprintf("Invalid status: %q", status), nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to list webhook events", err)
+		return
+	}
+
+	mappedEvents := []MappedWebhookEvent{}
+	for _, event := range events {
+		mappedEvents = append(mappedEvents, mapWebhookEvent(event))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mappedEvents)
+}
+
+// HandleReplayWebhookEvent processes a recorded webhook event again. Only
+// pending or failed events, and events still processing after their lease, can
+// be replayed; replaying any other event responds with a 409 status code. On
+// success, it responds with a 200 status code and the updated event, whose
+// status and last error record the outcome of the replay. It requires the
+// admin API key in the Authorization header.
+func (cfg *ApiConfig) HandleReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	if !cfg.authorizeAdmin(w, r) {
+		return
+	}
+
+	eventID, ok := pathUUID(w, r, "eventID")
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.DbQueries.GetWebhookEvent(r.Context(), eventID); err != nil {
+		respondWithError(w, r, CodeNotFound, "Failed to find event with ID: "+eventID.String(), err)
+		return
+	}
+
+	event, err := cfg.claimWebhookEvent(r.Context(), eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, r, CodeConflict, "Only pending, failed or stale processing events can be replayed", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to claim event", err)
+		return
+	}
+
+	// The outcome of the replay is recorded on the event itself
+	cfg.processWebhookEvent(r.Context(), event)
+
+	event, err = cfg.DbQueries.GetWebhookEvent(r.Context(), eventID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to load event", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mapWebhookEvent(event))
+}
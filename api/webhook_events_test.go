@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWebhookEventID(t *testing.T) {
+	payload := []byte(`{"event":"user.upgraded"}`)
+	withKey := http.Header{"Idempotency-Key": {"key-1"}}
+
+	tests := []struct {
+		name    string
+		headers http.Header
+		event   StripeEvent
+		want    string
+	}{
+		{"payload id", withKey, StripeEvent{ID: "evt_1"}, "evt_1"},
+		{"idempotency key", withKey, StripeEvent{}, "key-1"},
+		{"digest", http.Header{}, StripeEvent{}, "sha256:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := webhookEventID(tt.headers, tt.event, payload)
+			if !strings.HasPrefix(got, tt.want) || (tt.want == "sha256:" && len(got) != len("sha256:")+64) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// The same body must map to the same ID so that retries are deduplicated
+	first := webhookEventID(http.Header{}, StripeEvent{}, payload)
+	if again := webhookEventID(http.Header{}, StripeEvent{}, payload); again != first {
+		t.Errorf("Expected the same digest for the same payload, got %q and %q", first, again)
+	}
+	if other := webhookEventID(http.Header{}, StripeEvent{}, []byte(`{}`)); other == first {
+		t.Error("Expected different payloads to have different digests")
+	}
+}
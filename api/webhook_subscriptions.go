@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/webhooks"
+)
+
+// emitWebhook records outbound webhook deliveries of the event. Failing to record
+// a delivery must not fail the request that triggered the event, so errors are
+// only logged.
+func (cfg *ApiConfig) emitWebhook(ctx context.Context, eventType string, userID uuid.NullUUID, data any) {
+	if cfg.Webhooks == nil {
+		return
+	}
+	if err := cfg.Webhooks.Emit(ctx, eventType, userID, data); err != nil {
+		slog.ErrorContext(ctx, "Failed to emit webhook", "event", eventType, "request_id", RequestID(ctx), "error", err)
+	}
+}
+
+// mapWebhookSubscription maps a database webhook subscription to a
+// MappedWebhookSubscription to control the JSON keys. The secret is left out.
+func mapWebhookSubscription(subscription database.WebhookSubscription) MappedWebhookSubscription {
+	return MappedWebhookSubscription{
+		ID:        subscription.ID,
+		CreatedAt: subscription.CreatedAt,
+		UpdatedAt: subscription.UpdatedAt,
+		URL:       subscription.Url,
+		Events:    subscription.Events,
+		Active:    subscription.Active,
+	}
+}
+
+// HandleCreateWebhookSubscription registers a webhook subscription for the
+// authenticated user. It expects the target URL and the event types to subscribe
+// to in the request body. It responds with a 201 status code and the
+// subscription, including the secret used to sign its deliveries. The secret is
+// only ever returned here.
+func (cfg *ApiConfig) HandleCreateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// The URL must be an absolute http(s) URL and every event a known event type
+	var createRequest CreateWebhookSubscriptionRequest
+	if !decodeRequest(w, r, &createRequest) {
+		return
+	}
+
+	// Deliveries must not reach the server's own network. The address is
+	// checked again when connecting, after the host name is resolved.
+	if err := webhooks.CheckURL(createRequest.URL); err != nil {
+		respondWithError(w, r, CodeValidationFailed, "Request body is invalid", err, FieldError{
+			Field:   "url",
+			Code:    "forbidden_address",
+			Message: "must not point to a loopback, private, link-local or unspecified address",
+		})
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to generate webhook secret", err)
+		return
+	}
+
+	subscription, err := cfg.DbQueries.CreateWebhookSubscription(r.Context(), database.CreateWebhookSubscriptionParams{
+		UserID: userID,
+		Url:    createRequest.URL,
+		Secret: secret,
+		Events: createRequest.Events,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to create webhook subscription", err)
+		return
+	}
+
+	mappedSubscription := mapWebhookSubscription(subscription)
+	mappedSubscription.Secret = subscription.Secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(mappedSubscription)
+}
+
+// HandleGetWebhookSubscriptions lists the webhook subscriptions of the
+// authenticated user.
+func (cfg *ApiConfig) HandleGetWebhookSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := cfg.DbQueries.GetUserWebhookSubscriptions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get webhook subscriptions", err)
+		return
+	}
+
+	mappedSubscriptions := []MappedWebhookSubscription{}
+	for _, subscription := range subscriptions {
+		mappedSubscriptions = append(mappedSubscriptions, mapWebhookSubscription(subscription))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mappedSubscriptions)
+}
+
+// HandleDeleteWebhookSubscription deletes one of the authenticated user's
+// webhook subscriptions along with its delivery log. It responds with a 404
+// status code if the subscription does not exist or belongs to another user.
+func (cfg *ApiConfig) HandleDeleteWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, ok := pathUUID(w, r, "subscriptionID")
+	if !ok {
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.DbQueries.DeleteWebhookSubscription(r.Context(), database.DeleteWebhookSubscriptionParams{
+		ID:     subscriptionID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to delete webhook subscription", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, r, CodeNotFound, "Webhook subscription not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetWebhookDeliveries lists the most recent deliveries of one of the
+// authenticated user's webhook subscriptions, newest first.
+func (cfg *ApiConfig) HandleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, ok := pathUUID(w, r, "subscriptionID")
+	if !ok {
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	subscription, err := cfg.DbQueries.GetWebhookSubscription(r.Context(), subscriptionID)
+	if err != nil || subscription.UserID != userID {
+		respondWithError(w, r, CodeNotFound, "Webhook subscription not found", err)
+		return
+	}
+
+	deliveries, err := cfg.DbQueries.GetWebhookDeliveries(r.Context(), subscriptionID)
+	if err != nil {
+		respondWithError(w, r, CodeInternal, "Failed to get webhook deliveries", err)
+		return
+	}
+
+	mappedDeliveries := []MappedWebhookDelivery{}
+	for _, delivery := range deliveries {
+		mappedDelivery := MappedWebhookDelivery{
+			ID:             delivery.ID,
+			CreatedAt:      delivery.CreatedAt,
+			UpdatedAt:      delivery.UpdatedAt,
+			EventType:      delivery.EventType,
+			Payload:        delivery.Payload,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			NextAttemptAt:  delivery.NextAttemptAt,
+			LastError:      delivery.LastError.String,
+			ResponseStatus: delivery.ResponseStatus.Int32,
+		}
+		if delivery.DeliveredAt.Valid {
+			deliveredAt := delivery.DeliveredAt.Time
+			mappedDelivery.DeliveredAt = &deliveredAt
+		}
+		mappedDeliveries = append(mappedDeliveries, mappedDelivery)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mappedDeliveries)
+}
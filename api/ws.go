@@ -0,0 +1,371 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// Channels a WebSocket client can subscribe to.
+const (
+	ChannelTimeline      = "timeline"
+	ChannelMentions      = "mentions"
+	ChannelNotifications = "notifications"
+	ChannelMessages      = "messages"
+)
+
+// Close codes of the WebSocket connections closed by the server, besides the
+// standard ones.
+const (
+	CloseTokenExpired = 4001
+	CloseSlowConsumer = 4002
+)
+
+const (
+	// wsWriteTimeout bounds every write to a WebSocket connection.
+	wsWriteTimeout = 10 * time.Second
+	// wsPongWait is how long a connection may stay silent, pongs included.
+	wsPongWait = 60 * time.Second
+	// wsPingInterval is how often the server pings; shorter than wsPongWait.
+	wsPingInterval = 50 * time.Second
+	// wsMaxMessageSize is the size limit of client messages.
+	wsMaxMessageSize = 4096
+	// wsExpiryWarning is how long before the access token expires the client
+	// is told to send a new one.
+	wsExpiryWarning = time.Minute
+)
+
+// wsUpgrader upgrades requests to WebSocket connections. Connections are
+// authenticated with an access token rather than cookies, so requests from
+// other origins cannot act on a user's behalf and are allowed.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// HandleWebSocket upgrades the request to a WebSocket connection for the user
+// authenticated by the access token, sent in the Authorization header or, for
+// browsers, which cannot set headers on WebSocket requests, in the
+// access_token query parameter.
+//
+// Clients send JSON messages to subscribe to and unsubscribe from channels,
+// and receive the events of their channels as JSON messages: the chirps of the
+// timeline, the chirps mentioning the user and the notifications addressed to
+// them, such as likes, new followers and Chirpy Red upgrades. The connection is
+// closed with code 4001 when the access token expires, unless the client sent
+// a new one in an "auth" message; it is warned a minute before with a
+// "token_expiring" message.
+func (cfg *ApiConfig) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		cfg.Metrics.AuthFailure("missing_token")
+		respondWithError(w, r, CodeMissingCredentials, "Missing access token", err)
+		return
+	}
+	userID, expiresAt, err := auth.ValidateJWTExpiry(token, cfg.TokenSecret)
+	if err != nil {
+		cfg.Metrics.AuthFailure("invalid_token")
+		respondWithError(w, r, CodeInvalidToken, "Invalid access token", err)
+		return
+	}
+	setRequestUserID(r, userID)
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded with an error
+		return
+	}
+
+	socket := &webSocket{
+		cfg:       cfg,
+		conn:      conn,
+		userID:    userID,
+		expiresAt: expiresAt,
+		channels:  map[string]bool{},
+		shutdown:  make(chan struct{}),
+	}
+	if !cfg.trackSocket(socket) {
+		socket.close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	defer cfg.untrackSocket(socket)
+
+	cfg.Metrics.WebSocketOpened()
+	reason := socket.run()
+	cfg.Metrics.WebSocketClosed(reason)
+}
+
+// CloseWebSockets closes every WebSocket connection, telling the clients that
+// the server is going away, and refuses new ones. The HTTP server does not
+// track upgraded connections, so it must be called when shutting down.
+func (cfg *ApiConfig) CloseWebSockets() {
+	cfg.socketsMu.Lock()
+	defer cfg.socketsMu.Unlock()
+	cfg.socketsClosed = true
+	for socket := range cfg.sockets {
+		close(socket.shutdown)
+	}
+	cfg.sockets = nil
+}
+
+// trackSocket registers an open connection, unless the server is shutting
+// down.
+func (cfg *ApiConfig) trackSocket(socket *webSocket) bool {
+	cfg.socketsMu.Lock()
+	defer cfg.socketsMu.Unlock()
+	if cfg.socketsClosed {
+		return false
+	}
+	if cfg.sockets == nil {
+		cfg.sockets = map[*webSocket]struct{}{}
+	}
+	cfg.sockets[socket] = struct{}{}
+	return true
+}
+
+func (cfg *ApiConfig) untrackSocket(socket *webSocket) {
+	cfg.socketsMu.Lock()
+	defer cfg.socketsMu.Unlock()
+	delete(cfg.sockets, socket)
+}
+
+// webSocket is the state of a WebSocket connection. Only run writes to the
+// connection; mu guards the state read by the subscription's filter, which
+// runs on the publishers' goroutines.
+type webSocket struct {
+	cfg       *ApiConfig
+	conn      *websocket.Conn
+	userID    uuid.UUID
+	expiresAt time.Time
+	shutdown  chan struct{}
+
+	mu        sync.Mutex
+	channels  map[string]bool
+	relations *streamRelations // loaded when subscribing to the timeline
+}
+
+// run serves the connection until it ends and returns the reason it ended.
+func (s *webSocket) run() string {
+	defer s.conn.Close()
+
+	sub, _, _ := s.cfg.Stream.Subscribe(s.filter, 0)
+	defer sub.Close()
+
+	messages := make(chan []byte)
+	readErr := make(chan error, 1)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go s.read(messages, readErr, stopped)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	warning := time.NewTimer(time.Until(s.expiresAt.Add(-wsExpiryWarning)))
+	defer warning.Stop()
+	expiry := time.NewTimer(time.Until(s.expiresAt))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-readErr:
+			return "client_gone"
+		case <-s.shutdown:
+			s.close(websocket.CloseGoingAway, "server shutting down")
+			return "shutdown"
+		case <-sub.Done():
+			s.close(CloseSlowConsumer, "too slow")
+			return "slow_consumer"
+		case <-expiry.C:
+			s.close(CloseTokenExpired, "token expired")
+			return "token_expired"
+		case <-warning.C:
+			expiresAt := s.expiresAt
+			if !s.send(WebSocketServerMessage{Type: "token_expiring", ExpiresAt: &expiresAt}) {
+				return "write_failed"
+			}
+		case <-ping.C:
+			if s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)) != nil {
+				return "write_failed"
+			}
+		case event := <-sub.Events():
+			for _, channel := range s.route(event) {
+				if !s.send(WebSocketServerMessage{
+					Type:    "event",
+					Channel: channel,
+					Event:   event.Type,
+					ID:      event.ID,
+					Data:    event.Data,
+				}) {
+					return "write_failed"
+				}
+			}
+		case data := <-messages:
+			reply, renewed := s.handle(data)
+			if renewed {
+				warning.Reset(time.Until(s.expiresAt.Add(-wsExpiryWarning)))
+				expiry.Reset(time.Until(s.expiresAt))
+			}
+			if !s.send(reply) {
+				return "write_failed"
+			}
+		}
+	}
+}
+
+// read passes the client's messages to run until the connection fails or
+// stopped is closed. Pongs extend the read deadline.
+func (s *webSocket) read(messages chan<- []byte, readErr chan<- error, stopped <-chan struct{}) {
+	s.conn.SetReadLimit(wsMaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			readErr <- err
+			return
+		}
+		select {
+		case messages <- data:
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// handle handles a client message and returns the reply, and whether the
+// client sent a new access token.
+func (s *webSocket) handle(data []byte) (WebSocketServerMessage, bool) {
+	var msg WebSocketClientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return wsError("invalid_message", "Message must be a JSON object"), false
+	}
+
+	switch msg.Type {
+	case "ping":
+		return WebSocketServerMessage{Type: "pong"}, false
+	case "subscribe", "unsubscribe":
+		if msg.Channel != ChannelTimeline && msg.Channel != ChannelMentions && msg.Channel != ChannelNotifications && msg.Channel != ChannelMessages {
+			return wsError("unknown_channel", "Unknown channel: "+msg.Channel), false
+		}
+		if msg.Type == "unsubscribe" {
+			s.mu.Lock()
+			delete(s.channels, msg.Channel)
+			s.mu.Unlock()
+			return WebSocketServerMessage{Type: "unsubscribed", Channel: msg.Channel}, false
+		}
+		if msg.Channel == ChannelTimeline && !s.loadRelations() {
+			return wsError("internal_error", "Failed to get followed and hidden users"), false
+		}
+		s.mu.Lock()
+		s.channels[msg.Channel] = true
+		s.mu.Unlock()
+		return WebSocketServerMessage{Type: "subscribed", Channel: msg.Channel}, false
+	case "auth":
+		userID, expiresAt, err := auth.ValidateJWTExpiry(msg.Token, s.cfg.TokenSecret)
+		if err != nil || userID != s.userID {
+			s.cfg.Metrics.AuthFailure("invalid_token")
+			return wsError("invalid_token", "Invalid access token"), false
+		}
+		s.expiresAt = expiresAt
+		return WebSocketServerMessage{Type: "authenticated", ExpiresAt: &expiresAt}, true
+	default:
+		return wsError("unknown_type", "Unknown message type: "+msg.Type), false
+	}
+}
+
+// loadRelations loads the users the user follows and the users hidden from
+// them, once. Later changes are tracked by filter.
+func (s *webSocket) loadRelations() bool {
+	s.mu.Lock()
+	loaded := s.relations != nil
+	s.mu.Unlock()
+	if loaded {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+	defer cancel()
+	relations, err := s.cfg.loadStreamRelations(ctx, s.userID)
+	if err != nil {
+		slog.Error("Failed to get followed and hidden users", "user_id", s.userID, "error", err)
+		return false
+	}
+	s.mu.Lock()
+	s.relations = relations
+	s.mu.Unlock()
+	return true
+}
+
+// filter selects the events delivered to the connection. It runs when the
+// event is published, so it also keeps the followed and hidden users up to
+// date before their next chirp.
+func (s *webSocket) filter(event stream.Event) bool {
+	s.mu.Lock()
+	relations := s.relations
+	s.mu.Unlock()
+	if relations != nil {
+		relations.update(event)
+	}
+	return len(s.route(event)) > 0
+}
+
+// route returns the subscribed channels an event belongs to.
+func (s *webSocket) route(event stream.Event) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var channels []string
+	if s.channels[ChannelTimeline] && event.ReachesFollower(s.userID) && s.relations.inTimeline(event.AuthorID) {
+		channels = append(channels, ChannelTimeline)
+	}
+	if s.channels[ChannelMentions] && event.Type == stream.EventChirpCreated && event.IsFor(s.userID) {
+		channels = append(channels, ChannelMentions)
+	}
+	if s.channels[ChannelNotifications] && (event.Type == stream.EventNotificationCreated || event.Type == stream.EventUserFollowed || event.Type == stream.EventUserUpgraded) && event.IsFor(s.userID) {
+		channels = append(channels, ChannelNotifications)
+	}
+	if s.channels[ChannelMessages] && event.Type == stream.EventMessageCreated && event.IsFor(s.userID) {
+		channels = append(channels, ChannelMessages)
+	}
+	return channels
+}
+
+// send writes a message to the connection and reports whether it succeeded.
+func (s *webSocket) send(msg WebSocketServerMessage) bool {
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		if !errors.Is(err, websocket.ErrCloseSent) {
+			slog.Debug("Failed to write to WebSocket", "user_id", s.userID, "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// close sends a close message with the code and reason, then closes the
+// connection.
+func (s *webSocket) close(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	s.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+	s.conn.Close()
+}
+
+// wsError returns an error message with the code and message.
+func wsError(code, message string) WebSocketServerMessage {
+	return WebSocketServerMessage{Type: "error", Code: code, Message: message}
+}
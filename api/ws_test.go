@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+const wsTestSecret = "a-test-token-secret-that-is-long-enough"
+
+// dialWebSocket opens a WebSocket connection with an access token for the user
+// that expires after expiresIn.
+func dialWebSocket(t *testing.T, serverURL string, userID uuid.UUID, expiresIn time.Duration) *websocket.Conn {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, wsTestSecret, expiresIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws" + strings.TrimPrefix(serverURL, "http") + "?access_token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// exchange sends a message and returns the reply.
+func exchange(t *testing.T, conn *websocket.Conn, msg WebSocketClientMessage) WebSocketServerMessage {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatalf("Failed to send %+v: %v", msg, err)
+	}
+	return receiveMessage(t, conn)
+}
+
+func receiveMessage(t *testing.T, conn *websocket.Conn) WebSocketServerMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply WebSocketServerMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("Failed to receive a message: %v", err)
+	}
+	return reply
+}
+
+func TestHandleWebSocket(t *testing.T) {
+	broker := stream.NewBroker(0, 0)
+	cfg := &ApiConfig{Stream: broker, TokenSecret: wsTestSecret}
+	// The logging middleware wraps the ResponseWriter, which must still upgrade
+	server := httptest.NewServer(cfg.MiddlewareLogging(http.HandlerFunc(cfg.HandleWebSocket)))
+	defer server.Close()
+
+	alice, bob := uuid.New(), uuid.New()
+
+	t.Run("requires a token", func(t *testing.T) {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected a 401 status, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("channels", func(t *testing.T) {
+		conn := dialWebSocket(t, server.URL, alice, time.Hour)
+		for _, channel := range []string{ChannelMentions, ChannelNotifications, ChannelMessages} {
+			reply := exchange(t, conn, WebSocketClientMessage{Type: "subscribe", Channel: channel})
+			if reply.Type != "subscribed" || reply.Channel != channel {
+				t.Fatalf("Expected a subscription to %s, got %+v", channel, reply)
+			}
+		}
+
+		// Chirps that do not mention alice are not delivered
+		broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: bob, Data: []byte(`{"body":"hi"}`)})
+		mention := broker.Publish(stream.Event{
+			Type:       stream.EventChirpCreated,
+			AuthorID:   bob,
+			Recipients: []uuid.UUID{alice},
+			Data:       []byte(`{"body":"hi @alice"}`),
+		})
+		msg := receiveMessage(t, conn)
+		if msg.Type != "event" || msg.Channel != ChannelMentions || msg.ID != mention.ID || string(msg.Data) != `{"body":"hi @alice"}` {
+			t.Errorf("Expected the mention, got %+v", msg)
+		}
+
+		broker.Publish(stream.Event{Type: stream.EventUserFollowed, AuthorID: bob, Recipients: []uuid.UUID{alice}, Data: []byte(`{}`)})
+		if msg := receiveMessage(t, conn); msg.Channel != ChannelNotifications || msg.Event != stream.EventUserFollowed {
+			t.Errorf("Expected the follow notification, got %+v", msg)
+		}
+		notificationID := uuid.New()
+		cfg.publishNotification(context.Background(), bob, NotificationEvent{NotificationID: uuid.New(), Type: NotificationLike, ActorID: alice})
+		cfg.publishNotification(context.Background(), alice, NotificationEvent{NotificationID: notificationID, Type: NotificationLike, ActorID: bob})
+		msg = receiveMessage(t, conn)
+		var notification NotificationEvent
+		if msg.Channel != ChannelNotifications || msg.Event != stream.EventNotificationCreated || json.Unmarshal(msg.Data, &notification) != nil || notification.NotificationID != notificationID {
+			t.Errorf("Expected alice's like notification, got %+v", msg)
+		}
+		broker.Publish(stream.Event{Type: stream.EventUserUpgraded, AuthorID: bob, Recipients: []uuid.UUID{bob}, Data: []byte(`{}`)})
+		broker.Publish(stream.Event{Type: stream.EventUserUpgraded, AuthorID: alice, Recipients: []uuid.UUID{alice}, Data: []byte(`{}`)})
+		if msg := receiveMessage(t, conn); msg.Channel != ChannelNotifications || msg.Event != stream.EventUserUpgraded {
+			t.Errorf("Expected the upgrade notification, got %+v", msg)
+		}
+		broker.Publish(stream.Event{Type: stream.EventMessageCreated, AuthorID: bob, Recipients: []uuid.UUID{alice}, Data: []byte(`{"body":"psst"}`)})
+		if msg := receiveMessage(t, conn); msg.Channel != ChannelMessages || msg.Event != stream.EventMessageCreated || string(msg.Data) != `{"body":"psst"}` {
+			t.Errorf("Expected the direct message, got %+v", msg)
+		}
+
+		if reply := exchange(t, conn, WebSocketClientMessage{Type: "unsubscribe", Channel: ChannelMentions}); reply.Type != "unsubscribed" {
+			t.Errorf("Expected to unsubscribe, got %+v", reply)
+		}
+		if reply := exchange(t, conn, WebSocketClientMessage{Type: "ping"}); reply.Type != "pong" {
+			t.Errorf("Expected a pong, got %+v", reply)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		conn := dialWebSocket(t, server.URL, alice, time.Hour)
+		for _, tc := range []struct {
+			msg  WebSocketClientMessage
+			code string
+		}{
+			{WebSocketClientMessage{Type: "subscribe", Channel: "everything"}, "unknown_channel"},
+			{WebSocketClientMessage{Type: "shout"}, "unknown_type"},
+			{WebSocketClientMessage{Type: "auth", Token: "nope"}, "invalid_token"},
+		} {
+			if reply := exchange(t, conn, tc.msg); reply.Type != "error" || reply.Code != tc.code {
+				t.Errorf("%+v: expected a %s error, got %+v", tc.msg, tc.code, reply)
+			}
+		}
+
+		conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+		if reply := receiveMessage(t, conn); reply.Code != "invalid_message" {
+			t.Errorf("Expected an invalid_message error, got %+v", reply)
+		}
+	})
+
+	t.Run("token expiry", func(t *testing.T) {
+		conn := dialWebSocket(t, server.URL, alice, 2*time.Second)
+		if msg := receiveMessage(t, conn); msg.Type != "token_expiring" {
+			t.Fatalf("Expected a token_expiring warning, got %+v", msg)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != CloseTokenExpired {
+			t.Fatalf("Expected the connection to close with code %d, got %v", CloseTokenExpired, err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); broker.Subscribers() != 0; {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the subscription to be closed, got %d subscribers", broker.Subscribers())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	t.Run("reauthentication", func(t *testing.T) {
+		conn := dialWebSocket(t, server.URL, alice, 2*time.Second)
+		if msg := receiveMessage(t, conn); msg.Type != "token_expiring" {
+			t.Fatalf("Expected a token_expiring warning, got %+v", msg)
+		}
+		token, err := auth.MakeJWT(alice, wsTestSecret, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := exchange(t, conn, WebSocketClientMessage{Type: "auth", Token: token})
+		if reply.Type != "authenticated" || reply.ExpiresAt == nil || time.Until(*reply.ExpiresAt) < 50*time.Minute {
+			t.Fatalf("Expected to be authenticated for an hour, got %+v", reply)
+		}
+
+		// The connection outlives the first token
+		time.Sleep(2500 * time.Millisecond)
+		if reply := exchange(t, conn, WebSocketClientMessage{Type: "ping"}); reply.Type != "pong" {
+			t.Errorf("Expected a pong, got %+v", reply)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		conn := dialWebSocket(t, server.URL, alice, time.Hour)
+		exchange(t, conn, WebSocketClientMessage{Type: "ping"})
+		cfg.CloseWebSockets()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("Expected the connection to close with code %d, got %v", websocket.CloseGoingAway, err)
+		}
+	})
+}
+
+func TestExtractMentions(t *testing.T) {
+	got := extractMentions("@Alice and @bob_2, not me@example.com or @ab, but @ALICE again")
+	if want := []string{"alice", "bob_2"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Sort orders of ListChirps.
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// defaultPageSize is the number of chirps Chirps fetches per request unless
+// ListChirpsOptions.Limit is set.
+const defaultPageSize = 100
+
+// ListChirpsOptions filter and page the chirps returned by ListChirps.
+type ListChirpsOptions struct {
+	// AuthorID, if set, only lists the chirps of this user.
+	AuthorID uuid.UUID
+	// Sort orders the chirps by creation time, SortAsc by default.
+	Sort string
+	// Limit is the maximum number of chirps to return; zero means all.
+	Limit int
+	// Offset is the number of chirps to skip.
+	Offset int
+}
+
+func (o ListChirpsOptions) query() url.Values {
+	query := url.Values{}
+	if o.AuthorID != uuid.Nil {
+		query.Set("author_id", o.AuthorID.String())
+	}
+	if o.Sort != "" {
+		query.Set("sort", o.Sort)
+	}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		query.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return query
+}
+
+// Visibilities of chirps. Mentioned users and the author always see a chirp.
+const (
+	// VisibilityPublic chirps are listed for anyone.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted chirps are visible to anyone but left out of the
+	// global listing.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityFollowers chirps are only visible to the author's followers.
+	VisibilityFollowers = "followers"
+	// VisibilityMentioned chirps are only visible to the users they mention.
+	VisibilityMentioned = "mentioned"
+)
+
+// ChirpOptions set the reply, media, audience and content warning of a chirp
+// posted with PostChirp.
+type ChirpOptions struct {
+	// ReplyToID, if set, is the ID of the chirp to reply to.
+	ReplyToID uuid.UUID
+	// MediaIDs are up to four media uploaded with UploadMedia.
+	MediaIDs []uuid.UUID
+	// Visibility is one of the Visibility constants, VisibilityPublic by
+	// default. The chirps of protected users only reach their followers.
+	Visibility string
+	// ContentWarning, if set, marks the chirp as sensitive and is shown in
+	// place of its body until the reader expands it.
+	ContentWarning string
+	// Sensitive marks the chirp as sensitive without a content warning.
+	Sensitive bool
+}
+
+// CreateChirp posts a chirp as the logged in user, attaching up to four media
+// uploaded with UploadMedia.
+func (c *Client) CreateChirp(ctx context.Context, body string, mediaIDs ...uuid.UUID) (*Chirp, error) {
+	return c.PostChirp(ctx, body, ChirpOptions{MediaIDs: mediaIDs})
+}
+
+// Reply posts a chirp replying to the chirp with the given ID as the logged in
+// user. The author of that chirp is notified.
+func (c *Client) Reply(ctx context.Context, replyToID uuid.UUID, body string, mediaIDs ...uuid.UUID) (*Chirp, error) {
+	return c.PostChirp(ctx, body, ChirpOptions{ReplyToID: replyToID, MediaIDs: mediaIDs})
+}
+
+// PostChirp posts a chirp as the logged in user with the given options.
+func (c *Client) PostChirp(ctx context.Context, body string, opts ChirpOptions) (*Chirp, error) {
+	var replyToID *uuid.UUID
+	if opts.ReplyToID != uuid.Nil {
+		replyToID = &opts.ReplyToID
+	}
+	var chirp Chirp
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/chirps",
+		auth:   authBearer,
+		body: struct {
+			Body           string      `json:"body"`
+			MediaIDs       []uuid.UUID `json:"media_ids,omitempty"`
+			ReplyToID      *uuid.UUID  `json:"reply_to_id,omitempty"`
+			Visibility     string      `json:"visibility,omitempty"`
+			ContentWarning string      `json:"content_warning,omitempty"`
+			Sensitive      bool        `json:"sensitive,omitempty"`
+		}{body, opts.MediaIDs, replyToID, opts.Visibility, opts.ContentWarning, opts.Sensitive},
+	}, &chirp)
+	if err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+// GetChirp returns the chirp with the given ID. Chirps that are only visible
+// to followers or mentioned users, and the chirps of protected users, are only
+// found if the client is logged in as someone who may see them.
+func (c *Client) GetChirp(ctx context.Context, id uuid.UUID) (*Chirp, error) {
+	var chirp Chirp
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/chirps/" + id.String(), auth: authOptional}, &chirp); err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+// ListChirps returns a single page of chirps. Only the chirps the logged in
+// user may see are included, and the chirps of users they blocked or muted, or
+// who blocked them, are left out. Unlisted chirps are only listed with
+// AuthorID, or for their author.
+func (c *Client) ListChirps(ctx context.Context, opts ListChirpsOptions) ([]Chirp, error) {
+	var chirps []Chirp
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/chirps", query: opts.query(), auth: authOptional}, &chirps); err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
+
+// Chirps iterates over every chirp matching the options, starting at
+// opts.Offset and fetching opts.Limit chirps per request. Iteration stops at
+// the first error, which is yielded with a zero Chirp.
+func (c *Client) Chirps(ctx context.Context, opts ListChirpsOptions) iter.Seq2[Chirp, error] {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageSize
+	}
+	return func(yield func(Chirp, error) bool) {
+		for {
+			page, err := c.ListChirps(ctx, opts)
+			if err != nil {
+				yield(Chirp{}, err)
+				return
+			}
+			for _, chirp := range page {
+				if !yield(chirp, nil) {
+					return
+				}
+			}
+			if len(page) < opts.Limit {
+				return
+			}
+			opts.Offset += len(page)
+		}
+	}
+}
+
+// DeleteChirp deletes one of the logged in user's chirps.
+func (c *Client) DeleteChirp(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/api/chirps/" + id.String(), auth: authBearer}, nil)
+}
+
+// LikeChirp makes the logged in user like the chirp with the given ID.
+func (c *Client) LikeChirp(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/chirps/" + id.String() + "/like", auth: authBearer}, nil)
+}
+
+// UnlikeChirp makes the logged in user stop liking the chirp with the given ID.
+func (c *Client) UnlikeChirp(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/api/chirps/" + id.String() + "/like", auth: authBearer}, nil)
+}
@@ -0,0 +1,262 @@
+// Package client is a Go client for the Chirpy API.
+//
+// A Client is created with the base URL of a Chirpy server and holds the
+// credentials it authenticates with: the access and refresh tokens returned by
+// Login, and the API keys of the payment provider webhook and the admin API.
+// When a request authenticated with an access token is rejected with 401, the
+// client gets a new access token from /api/refresh and retries the request once.
+//
+// Error responses are returned as *Error, which carries the problem details
+// sent by the server, including its stable error code:
+//
+//	if _, err := c.GetChirp(ctx, id); client.IsCode(err, client.CodeNotFound) {
+//		// ...
+//	}
+//
+// The Prometheus and documentation endpoints are meant for Prometheus and
+// browsers and are not wrapped.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Client calls the Chirpy API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	polkaKey   string
+	adminKey   string
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to send requests, e.g. to configure
+// timeouts. http.DefaultClient is used otherwise.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTokens sets the access and refresh tokens of a previous Login.
+func WithTokens(accessToken, refreshToken string) Option {
+	return func(c *Client) {
+		c.accessToken = accessToken
+		c.refreshToken = refreshToken
+	}
+}
+
+// WithPolkaKey sets the API key the payment provider webhook is called with.
+func WithPolkaKey(key string) Option {
+	return func(c *Client) {
+		c.polkaKey = key
+	}
+}
+
+// WithAdminKey sets the API key the admin API is called with.
+func WithAdminKey(key string) Option {
+	return func(c *Client) {
+		c.adminKey = key
+	}
+}
+
+// New returns a client of the Chirpy server at baseURL, e.g.
+// "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Tokens returns the current access and refresh tokens, e.g. to store them
+// and pass them to WithTokens later.
+func (c *Client) Tokens() (accessToken, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.accessToken, c.refreshToken
+}
+
+// SetTokens replaces the access and refresh tokens, e.g. with ones stored
+// from an earlier session.
+func (c *Client) SetTokens(accessToken, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accessToken = accessToken
+	c.refreshToken = refreshToken
+}
+
+// auth is the way a request is authenticated.
+type auth int
+
+const (
+	authNone auth = iota
+	// authBearer authenticates with the access token, refreshing it on 401.
+	authBearer
+	// authOptional authenticates with the access token if the client is
+	// logged in, like authBearer, and sends no credentials otherwise.
+	authOptional
+	// authRefresh authenticates with the refresh token.
+	authRefresh
+	authPolkaKey
+	authAdminKey
+)
+
+// request describes a call to the API.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	auth   auth
+	body   any
+	// raw, if set, is sent as the body instead of body encoded as JSON, with
+	// contentType as its Content-Type.
+	raw         []byte
+	contentType string
+}
+
+// do sends the request and decodes a successful JSON response into out, if out
+// is not nil. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	body := req.raw
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+	}
+
+	resp, err := c.send(ctx, req, body)
+	if err != nil {
+		return err
+	}
+
+	// The access token may have expired: get a new one and try again once
+	if resp.StatusCode == http.StatusUnauthorized && (req.auth == authBearer || req.auth == authOptional) {
+		if _, refreshToken := c.Tokens(); refreshToken != "" {
+			resp.Body.Close()
+			if err := c.Refresh(ctx); err != nil {
+				return err
+			}
+			if resp, err = c.send(ctx, req, body); err != nil {
+				return err
+			}
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// send builds and sends a single HTTP request.
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := c.baseURL.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	switch {
+	case req.contentType != "":
+		httpReq.Header.Set("Content-Type", req.contentType)
+	case body != nil:
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json, application/problem+json")
+
+	accessToken, refreshToken := c.Tokens()
+	switch req.auth {
+	case authBearer:
+		if accessToken == "" {
+			return nil, ErrNotLoggedIn
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	case authOptional:
+		if accessToken != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+	case authRefresh:
+		if refreshToken == "" {
+			return nil, ErrNotLoggedIn
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+refreshToken)
+	case authPolkaKey:
+		httpReq.Header.Set("Authorization", "ApiKey "+c.polkaKey)
+	case authAdminKey:
+		httpReq.Header.Set("Authorization", "ApiKey "+c.adminKey)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", req.method, req.path, err)
+	}
+	return resp, nil
+}
+
+// decodeError turns an error response into an *Error. Responses that are not
+// problem details, such as plain text, become an *Error with the response's
+// status and body as its detail.
+func decodeError(resp *http.Response) error {
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read error response: %w", err)
+	}
+
+	apiErr := &Error{}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if mediaType != problemContentType || json.Unmarshal(raw, apiErr) != nil {
+		apiErr = &Error{Detail: strings.TrimSpace(string(raw))}
+	}
+	if apiErr.Status == 0 {
+		apiErr.Status = resp.StatusCode
+	}
+	if apiErr.Title == "" {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// ErrNotLoggedIn is returned when a request needs a token the client does not
+// have, because Login was not called or the tokens were revoked.
+var ErrNotLoggedIn = errors.New("client is not logged in")
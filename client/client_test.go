@@ -0,0 +1,896 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+
+	"github.com/Fepozopo/chirpy/api"
+	"github.com/Fepozopo/chirpy/client"
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/blobstore"
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/media"
+	"github.com/Fepozopo/chirpy/internal/migrations"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+const testTokenSecret = "a-test-token-secret-that-is-long-enough"
+
+// newTestServer serves the real API handlers with the given configuration.
+func newTestServer(t *testing.T, cfg *api.ApiConfig) *client.Client {
+	t.Helper()
+	if cfg.TokenSecret == "" {
+		cfg.TokenSecret = testTokenSecret
+	}
+	if cfg.PasswordPolicy == nil {
+		cfg.PasswordPolicy = auth.DefaultPasswordPolicy()
+	}
+	cfg.PasswordHasher = &auth.PasswordHasher{Algorithm: auth.AlgorithmBcrypt, BcryptCost: 4}
+
+	mux := http.NewServeMux()
+	for _, route := range cfg.Routes() {
+		mux.Handle(route.Pattern, route.Handler)
+	}
+	server := httptest.NewServer(cfg.MiddlewareRequestID(mux))
+	t.Cleanup(server.Close)
+
+	c, err := client.New(server.URL,
+		client.WithHTTPClient(server.Client()),
+		client.WithPolkaKey(cfg.StripeKey),
+		client.WithAdminKey(cfg.AdminKey),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:8080", "ftp://example.com", "http://%zz"} {
+		if _, err := client.New(baseURL); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", baseURL)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	c := newTestServer(t, &api.ApiConfig{})
+
+	if err := c.Healthz(ctx); err != nil {
+		t.Fatalf("Healthz failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		call       func() error
+		wantStatus int
+		wantCode   client.ErrorCode
+		wantField  string
+	}{
+		{
+			name: "validation",
+			call: func() error {
+				_, err := c.CreateUser(ctx, "not an email", "a-long-enough-password")
+				return err
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   client.CodeValidationFailed,
+			wantField:  "email",
+		},
+		{
+			name: "password policy",
+			call: func() error {
+				_, err := c.CreateUser(ctx, "user@example.com", "password")
+				return err
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   client.CodeValidationFailed,
+			wantField:  "password",
+		},
+		{
+			name: "invalid parameter",
+			call: func() error {
+				_, err := c.ListChirps(ctx, client.ListChirpsOptions{Sort: "sideways"})
+				return err
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   client.CodeInvalidParameter,
+			wantField:  "sort",
+		},
+		{
+			name: "admin disabled",
+			call: func() error {
+				_, err := c.ListWebhookEvents(ctx, "")
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   client.CodeAdminDisabled,
+		},
+		{
+			name:       "reset outside dev",
+			call:       func() error { return c.Reset(ctx) },
+			wantStatus: http.StatusForbidden,
+			wantCode:   client.CodeForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected a *client.Error, got %v", err)
+			}
+			if apiErr.Status != tt.wantStatus || apiErr.Code != tt.wantCode {
+				t.Errorf("Got status %d and code %q, want %d and %q", apiErr.Status, apiErr.Code, tt.wantStatus, tt.wantCode)
+			}
+			if tt.wantCode != "" && apiErr.RequestID == "" {
+				t.Error("Expected the problem to carry the request ID")
+			}
+			if tt.wantField != "" && (len(apiErr.Errors) != 1 || apiErr.Errors[0].Field != tt.wantField) {
+				t.Errorf("Expected a field error for %q, got %+v", tt.wantField, apiErr.Errors)
+			}
+		})
+	}
+}
+
+func TestClientPlainTextError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	c, err := client.New(server.URL, client.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Errors that are not problem details, e.g. from a proxy, keep their status
+	var apiErr *client.Error
+	if err := c.Healthz(context.Background()); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadGateway || apiErr.Code != "" {
+		t.Errorf("Expected a plain 502 *client.Error, got %v", err)
+	}
+}
+
+func TestProfileUpdateJSON(t *testing.T) {
+	avatarID := uuid.MustParse("7d1c3c44-3c2c-4b45-9a8b-46d3a0e0e6f1")
+	tests := []struct {
+		name   string
+		update client.ProfileUpdate
+		want   string
+	}{
+		{name: "empty", update: client.ProfileUpdate{}, want: `{}`},
+		{name: "bio", update: client.ProfileUpdate{Bio: client.Ptr("Hi")}, want: `{"bio":"Hi"}`},
+		{name: "unprotect", update: client.ProfileUpdate{IsProtected: client.Ptr(false)}, want: `{"is_protected":false}`},
+		{name: "avatar", update: client.ProfileUpdate{AvatarMediaID: &avatarID}, want: `{"avatar_media_id":"` + avatarID.String() + `"}`},
+		{name: "remove avatar", update: client.ProfileUpdate{Username: client.Ptr(""), RemoveAvatar: true}, want: `{"username":"","avatar_media_id":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.update)
+			if err != nil || string(data) != tt.want {
+				t.Errorf("Marshal = %s, %v, want %s", data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	ctx := context.Background()
+	c := newTestServer(t, &api.ApiConfig{})
+
+	if _, err := c.CreateChirp(ctx, "Hello"); !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("Expected ErrNotLoggedIn before logging in, got %v", err)
+	}
+
+	// Without a refresh token, a rejected access token is returned as is
+	c.SetTokens("not-a-jwt", "")
+	if _, err := c.CreateChirp(ctx, "Hello"); !client.IsCode(err, client.CodeInvalidToken) {
+		t.Errorf("Expected invalid_token with an invalid access token, got %v", err)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	broker := stream.NewBroker(10, 10)
+	c := newTestServer(t, &api.ApiConfig{Stream: broker})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	authorID := uuid.New()
+	first := broker.Publish(stream.Event{Type: stream.EventChirpCreated, AuthorID: authorID, Data: []byte(`{"body":"first"}`)})
+	broker.Publish(stream.Event{Type: stream.EventChirpDeleted, AuthorID: authorID, Data: []byte(`{"body":"second"}`)})
+
+	// Resuming after the first event yields the second from the replay buffer
+	opts := client.StreamOptions{LastEventID: strconv.FormatUint(first.ID, 10)}
+	for event, err := range c.Stream(ctx, opts) {
+		if err != nil {
+			t.Fatalf("Stream failed: %v", err)
+		}
+		if event.Type != client.StreamChirpDeleted || event.Chirp == nil || event.Chirp.Body != "second" {
+			t.Errorf("Expected the deleted second chirp, got %+v", event)
+		}
+		break
+	}
+
+	var stopped bool
+	for _, err := range c.Stream(ctx, client.StreamOptions{Timeline: true}) {
+		if !errors.Is(err, client.ErrNotLoggedIn) {
+			t.Errorf("Expected ErrNotLoggedIn for the timeline, got %v", err)
+		}
+		stopped = true
+		break
+	}
+	if !stopped {
+		t.Error("Expected the timeline stream to yield an error")
+	}
+}
+
+func TestClientSocket(t *testing.T) {
+	broker := stream.NewBroker(10, 10)
+	c := newTestServer(t, &api.ApiConfig{Stream: broker})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := c.Connect(ctx); !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("Expected ErrNotLoggedIn before logging in, got %v", err)
+	}
+	c.SetTokens("not-a-jwt", "")
+	if _, err := c.Connect(ctx); !client.IsCode(err, client.CodeInvalidToken) {
+		t.Errorf("Expected invalid_token with an invalid access token, got %v", err)
+	}
+
+	userID, authorID := uuid.New(), uuid.New()
+	token, err := auth.MakeJWT(userID, testTokenSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTokens(token, "")
+	socket, err := c.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer socket.Close()
+
+	if err := socket.Subscribe(client.ChannelMentions); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if msg, err := socket.Receive(); err != nil || msg.Type != client.MessageSubscribed {
+		t.Fatalf("Expected a subscription, got %+v, %v", msg, err)
+	}
+
+	broker.Publish(stream.Event{
+		Type:       stream.EventChirpCreated,
+		AuthorID:   authorID,
+		Recipients: []uuid.UUID{userID},
+		Data:       []byte(`{"body":"hi @you"}`),
+	})
+	msg, err := socket.Receive()
+	if err != nil || msg.Type != client.MessageEvent || msg.Channel != client.ChannelMentions {
+		t.Fatalf("Expected a mention, got %+v, %v", msg, err)
+	}
+	if chirp, err := msg.Chirp(); err != nil || chirp.Body != "hi @you" {
+		t.Errorf("Expected the mentioning chirp, got %+v, %v", chirp, err)
+	}
+}
+
+// TestClientIntegration runs the client against the real handlers and a real
+// database. It needs an empty, disposable Postgres database in
+// CHIRPY_TEST_DB_URL and is skipped otherwise.
+func TestClientIntegration(t *testing.T) {
+	dbURL := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_DB_URL is not set")
+	}
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	blobs, err := blobstore.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create blob store: %v", err)
+	}
+
+	broker := stream.NewBroker(0, 0)
+	cfg := &api.ApiConfig{
+		DB:         db,
+		DbQueries:  database.New(db),
+		Migrations: migrator,
+		Platform:   "dev",
+		StripeKey:  "polka-key",
+		AdminKey:   "admin-key",
+		Blobs:      blobs,
+		Stream:     broker,
+	}
+	c := newTestServer(t, cfg)
+	if err := c.Reset(ctx); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	readiness, err := c.Readyz(ctx)
+	if err != nil || !readiness.Ready() {
+		t.Fatalf("Readyz = %+v, %v, want ready", readiness, err)
+	}
+
+	email, password := "sdk@example.com", "correct-horse-battery-staple"
+	user, err := c.CreateUser(ctx, email, password)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := c.CreateUser(ctx, email, password); err == nil {
+		t.Error("Creating a user with the same email twice succeeded")
+	}
+	if _, err := c.Login(ctx, email, "wrong-password"); !client.IsCode(err, client.CodeInvalidCredentials) {
+		t.Errorf("Login with a wrong password returned %v, want invalid_credentials", err)
+	}
+	session, err := c.Login(ctx, email, password)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// Create enough chirps to span several pages
+	var created []*client.Chirp
+	for i := range 5 {
+		chirp, err := c.CreateChirp(ctx, fmt.Sprintf("Chirp %d", i))
+		if err != nil {
+			t.Fatalf("CreateChirp failed: %v", err)
+		}
+		created = append(created, chirp)
+	}
+	if chirp, err := c.GetChirp(ctx, created[0].ID); err != nil || chirp.Body != "Chirp 0" {
+		t.Errorf("GetChirp = %+v, %v", chirp, err)
+	}
+
+	var listed []client.Chirp
+	for chirp, err := range c.Chirps(ctx, client.ListChirpsOptions{AuthorID: user.ID, Limit: 2}) {
+		if err != nil {
+			t.Fatalf("Chirps failed: %v", err)
+		}
+		listed = append(listed, chirp)
+	}
+	if len(listed) != len(created) {
+		t.Fatalf("Chirps yielded %d chirps, want %d", len(listed), len(created))
+	}
+	for i := range listed {
+		if listed[i].ID != created[i].ID {
+			t.Errorf("Chirp %d is %s, want %s", i, listed[i].ID, created[i].ID)
+		}
+	}
+
+	// getMedia fetches a media file with the access token, or logged out when
+	// it is empty
+	getMedia := func(id uuid.UUID, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/media/"+id.String(), nil)
+		req.SetPathValue("mediaID", id.String())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		cfg.HandleGetMedia(rec, req)
+		return rec
+	}
+
+	// Uploaded media are attached to a single chirp
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := c.UploadMedia(ctx, "image.png", &img)
+	if err != nil {
+		t.Fatalf("UploadMedia failed: %v", err)
+	}
+	if uploaded.ContentType != "image/png" || uploaded.Width != 40 || uploaded.Height != 30 {
+		t.Errorf("UploadMedia = %+v, want a 40×30 PNG", uploaded)
+	}
+	if _, err := c.UploadMedia(ctx, "image.png", bytes.NewReader([]byte("not an image"))); !client.IsCode(err, client.CodeValidationFailed) {
+		t.Errorf("UploadMedia of text returned %v, want validation_failed", err)
+	}
+	if rec := getMedia(uploaded.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Getting an unattached upload logged out returned %d, want 404", rec.Code)
+	}
+	if rec := getMedia(uploaded.ID, session.Token); rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "private, no-store" {
+		t.Errorf("Getting your own upload returned %d with Cache-Control %q, want a private 200", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	withMedia, err := c.CreateChirp(ctx, "With an image", uploaded.ID)
+	if err != nil {
+		t.Fatalf("CreateChirp with media failed: %v", err)
+	}
+	if rec := getMedia(uploaded.ID, ""); rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("Getting the image of a public chirp returned %d with Cache-Control %q, want a public 200", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	if len(withMedia.Media) != 1 || withMedia.Media[0].ID != uploaded.ID {
+		t.Errorf("CreateChirp media = %+v, want %s", withMedia.Media, uploaded.ID)
+	}
+	if _, err := c.CreateChirp(ctx, "Same image", uploaded.ID); !client.IsCode(err, client.CodeValidationFailed) {
+		t.Errorf("Attaching media twice returned %v, want validation_failed", err)
+	}
+	if err := c.DeleteChirp(ctx, withMedia.ID); err != nil {
+		t.Fatalf("DeleteChirp failed: %v", err)
+	}
+
+	// The images of chirps for followers are only served to who may see them
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	hidden, err := c.UploadMedia(ctx, "image.png", &img)
+	if err != nil {
+		t.Fatalf("UploadMedia failed: %v", err)
+	}
+	forFollowers, err := c.PostChirp(ctx, "For followers", client.ChirpOptions{MediaIDs: []uuid.UUID{hidden.ID}, Visibility: client.VisibilityFollowers})
+	if err != nil {
+		t.Fatalf("PostChirp with media failed: %v", err)
+	}
+	if rec := getMedia(hidden.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Getting the image of a chirp for followers logged out returned %d, want 404", rec.Code)
+	}
+	if rec := getMedia(hidden.ID, session.Token); rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "private, no-store" {
+		t.Errorf("Getting the image of your chirp for followers returned %d with Cache-Control %q, want a private 200", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	if err := c.DeleteChirp(ctx, forFollowers.ID); err != nil {
+		t.Fatalf("DeleteChirp failed: %v", err)
+	}
+
+	// The sweeper deletes the blobs of media removed by cascade, and uploads
+	// that were never attached once they expire
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := c.UploadMedia(ctx, "image.png", &img)
+	if err != nil {
+		t.Fatalf("UploadMedia failed: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE media SET created_at = NOW() - INTERVAL '2 days' WHERE id = $1", stale.ID); err != nil {
+		t.Fatal(err)
+	}
+	media.NewSweeper(database.New(db), blobs).Sweep(ctx)
+	for _, id := range []uuid.UUID{uploaded.ID, hidden.ID, stale.ID} {
+		for _, key := range []string{"originals/" + id.String(), "thumbnails/" + id.String()} {
+			if _, err := blobs.Get(ctx, key); !errors.Is(err, blobstore.ErrNotFound) {
+				t.Errorf("Blob %s after the sweep returned %v, want ErrNotFound", key, err)
+			}
+		}
+	}
+	var queued int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM media_blob_deletions").Scan(&queued); err != nil || queued != 0 {
+		t.Errorf("Expected the blob deletion queue to be drained, got %d (%v)", queued, err)
+	}
+
+	// Profiles are public, never show the email and count chirps and follows
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 20, 20))); err != nil {
+		t.Fatal(err)
+	}
+	avatar, err := c.UploadMedia(ctx, "avatar.png", &img)
+	if err != nil {
+		t.Fatalf("UploadMedia failed: %v", err)
+	}
+	profile, err := c.UpdateProfile(ctx, client.ProfileUpdate{Username: client.Ptr("SDK_User"), DisplayName: client.Ptr("SDK"), Website: client.Ptr("https://example.com"), AvatarMediaID: &avatar.ID})
+	if err != nil {
+		t.Fatalf("UpdateProfile failed: %v", err)
+	}
+	if profile.DisplayName != "SDK" || profile.Username == nil || *profile.Username != "sdk_user" || profile.Avatar == nil || profile.Avatar.ID != avatar.ID {
+		t.Errorf("UpdateProfile = %+v, want the username, display name and avatar", profile)
+	}
+	if rec := getMedia(avatar.ID, ""); rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("Getting an avatar logged out returned %d with Cache-Control %q, want a public 200", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	if _, err := c.CreateChirp(ctx, "My avatar", avatar.ID); !client.IsCode(err, client.CodeValidationFailed) {
+		t.Errorf("Attaching an avatar to a chirp returned %v, want validation_failed", err)
+	}
+	other, err := c.CreateUser(ctx, "other@example.com", password)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := c.Follow(ctx, other.ID); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	if err := c.Follow(ctx, user.ID); !client.IsCode(err, client.CodeInvalidParameter) {
+		t.Errorf("Following yourself returned %v, want invalid_parameter", err)
+	}
+	if profile, err := c.GetUser(ctx, other.ID); err != nil || profile.FollowerCount != 1 {
+		t.Errorf("GetUser = %+v, %v, want 1 follower", profile, err)
+	}
+	if profile, err := c.GetUser(ctx, user.ID); err != nil || profile.ChirpCount != int64(len(created)) || profile.FollowingCount != 1 {
+		t.Errorf("GetUser = %+v, %v, want %d chirps and 1 followee", profile, err, len(created))
+	}
+	if err := c.Unfollow(ctx, other.ID); err != nil {
+		t.Fatalf("Unfollow failed: %v", err)
+	}
+	if err := c.Unfollow(ctx, other.ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Unfollowing twice returned %v, want not_found", err)
+	}
+
+	// Likes, replies and follows notify the user, grouped by chirp and type
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if count, err := c.UnreadNotificationCount(ctx); err != nil || count != 1 {
+		t.Errorf("UnreadNotificationCount = %d, %v, want the follow", count, err)
+	}
+	if err := c.MarkAllNotificationsRead(ctx); err != nil {
+		t.Fatalf("MarkAllNotificationsRead failed: %v", err)
+	}
+	for range 2 {
+		if err := c.LikeChirp(ctx, created[1].ID); err != nil {
+			t.Fatalf("LikeChirp failed: %v", err)
+		}
+	}
+	reply, err := c.Reply(ctx, created[1].ID, "Hi @sdk_user")
+	if err != nil || reply.ReplyToID == nil || *reply.ReplyToID != created[1].ID {
+		t.Fatalf("Reply = %+v, %v, want a reply to %s", reply, err, created[1].ID)
+	}
+	if _, err := c.Reply(ctx, uuid.New(), "Hi"); !client.IsCode(err, client.CodeValidationFailed) {
+		t.Errorf("Replying to an unknown chirp returned %v, want validation_failed", err)
+	}
+	if err := c.Follow(ctx, user.ID); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	var notifications []client.Notification
+	for notification, err := range c.Notifications(ctx, 2) {
+		if err != nil {
+			t.Fatalf("Notifications failed: %v", err)
+		}
+		notifications = append(notifications, notification)
+	}
+	var types []string
+	for _, notification := range notifications {
+		types = append(types, notification.Type)
+	}
+	if fmt.Sprint(types) != "[follow reply like]" {
+		t.Fatalf("Notifications = %v, want a follow, a reply and a like", types)
+	}
+	if n := notifications[2]; n.ActorCount != 1 || *n.ChirpID != created[1].ID || n.Summary != "Someone liked your chirp" {
+		t.Errorf("Like notification = %+v", n)
+	}
+	if err := c.MarkNotificationRead(ctx, notifications[0].ID); err != nil {
+		t.Fatalf("MarkNotificationRead failed: %v", err)
+	}
+	if err := c.MarkNotificationRead(ctx, uuid.New()); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Marking an unknown notification returned %v, want not_found", err)
+	}
+	if count, err := c.UnreadNotificationCount(ctx); err != nil || count != 2 {
+		t.Errorf("UnreadNotificationCount = %d, %v, want 2", count, err)
+	}
+	if err := c.UnlikeChirp(ctx, created[1].ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Unliking a chirp you do not like returned %v, want not_found", err)
+	}
+
+	// Direct messages are censored like chirps and carry read receipts
+	conversation, err := c.CreateConversation(ctx, other.ID)
+	if err != nil || !conversation.Direct || len(conversation.Members) != 2 {
+		t.Fatalf("CreateConversation = %+v, %v, want a direct conversation", conversation, err)
+	}
+	if again, err := c.CreateConversation(ctx, other.ID, user.ID); err != nil || again.ID != conversation.ID {
+		t.Errorf("CreateConversation again = %+v, %v, want %s", again, err, conversation.ID)
+	}
+	if _, err := c.CreateConversation(ctx, user.ID); !client.IsCode(err, client.CodeValidationFailed) {
+		t.Errorf("A conversation with yourself returned %v, want validation_failed", err)
+	}
+	sent, err := c.SendMessage(ctx, conversation.ID, "Hi, kerfuffle")
+	if err != nil || sent.Body != "Hi, ****" {
+		t.Fatalf("SendMessage = %+v, %v, want a censored message", sent, err)
+	}
+	if _, err := c.SendMessage(ctx, uuid.New(), "Hi"); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Sending to an unknown conversation returned %v, want not_found", err)
+	}
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if conversations, err := c.ListConversations(ctx); err != nil || len(conversations) != 1 || conversations[0].UnreadCount != 1 {
+		t.Errorf("ListConversations = %+v, %v, want 1 unread message", conversations, err)
+	}
+	if err := c.MarkMessageRead(ctx, conversation.ID, sent.ID); err != nil {
+		t.Fatalf("MarkMessageRead failed: %v", err)
+	}
+	if _, err := c.SendMessage(ctx, conversation.ID, "Hello"); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if page, err := c.ListMessages(ctx, conversation.ID, 1, ""); err != nil || len(page.Messages) != 1 || page.Messages[0].Body != "Hello" || page.NextCursor == "" {
+		t.Errorf("ListMessages = %+v, %v, want the reply and a cursor", page, err)
+	}
+	var messages []client.Message
+	for message, err := range c.Messages(ctx, conversation.ID, 1) {
+		if err != nil {
+			t.Fatalf("Messages failed: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) != 2 || messages[1].ID != sent.ID || len(messages[1].ReadBy) != 1 || messages[1].ReadBy[0] != other.ID {
+		t.Errorf("Messages = %+v, want the sent message read by the other user", messages)
+	}
+
+	// The other user's open timeline stream stops carrying the user's chirps
+	// once the user blocks them
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+	timeline := make(chan client.StreamEvent, 8)
+	go func() {
+		for event, err := range c.Stream(streamCtx, client.StreamOptions{Timeline: true}) {
+			if err == nil {
+				timeline <- event
+			}
+		}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); broker.Subscribers() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the timeline stream")
+		}
+	}
+	nextTimelineChirp := func() string {
+		t.Helper()
+		select {
+		case event := <-timeline:
+			if event.Chirp == nil {
+				t.Fatalf("Expected a chirp on the timeline, got %+v", event)
+			}
+			return event.Chirp.Body
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the timeline")
+			return ""
+		}
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if chirp, err := c.CreateChirp(ctx, "Before the block"); err != nil {
+		t.Fatalf("CreateChirp failed: %v", err)
+	} else {
+		created = append(created, chirp)
+	}
+	if body := nextTimelineChirp(); body != "Before the block" {
+		t.Errorf("Timeline chirp = %q, want the chirp before the block", body)
+	}
+
+	// Blocks end follows, hide chirps and stop replies and messages both ways
+	if err := c.Block(ctx, other.ID); err != nil {
+		t.Fatalf("Block failed: %v", err)
+	}
+	if blocks, err := c.ListBlocks(ctx); err != nil || len(blocks) != 1 || blocks[0].ID != other.ID {
+		t.Errorf("ListBlocks = %+v, %v, want the other user", blocks, err)
+	}
+	if chirp, err := c.CreateChirp(ctx, "After the block"); err != nil {
+		t.Fatalf("CreateChirp failed: %v", err)
+	} else {
+		created = append(created, chirp)
+	}
+	if profile, err := c.GetUser(ctx, user.ID); err != nil || profile.FollowerCount != 0 {
+		t.Errorf("GetUser = %+v, %v, want no followers after the block", profile, err)
+	}
+	if chirps, err := c.ListChirps(ctx, client.ListChirpsOptions{}); err != nil || len(chirps) != len(created) {
+		t.Errorf("ListChirps = %d chirps, %v, want only your own %d", len(chirps), err, len(created))
+	}
+	if _, err := c.CreateUser(ctx, "third@example.com", password); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := c.Login(ctx, "third@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := c.CreateConversation(ctx, user.ID, other.ID); !client.IsCode(err, client.CodeForbidden) {
+		t.Errorf("A group of users who blocked each other returned %v, want forbidden", err)
+	}
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := c.Reply(ctx, created[1].ID, "Hi"); !client.IsCode(err, client.CodeForbidden) {
+		t.Errorf("Replying to a user who blocked you returned %v, want forbidden", err)
+	}
+	if _, err := c.GetChirp(ctx, created[1].ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Getting a chirp of a user who blocked you returned %v, want not_found", err)
+	}
+	if err := c.LikeChirp(ctx, created[1].ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Liking a chirp of a user who blocked you returned %v, want not_found", err)
+	}
+	if _, err := c.SendMessage(ctx, conversation.ID, "Hi"); !client.IsCode(err, client.CodeForbidden) {
+		t.Errorf("Messaging a user who blocked you returned %v, want forbidden", err)
+	}
+	if err := c.Follow(ctx, user.ID); !client.IsCode(err, client.CodeForbidden) {
+		t.Errorf("Following a user who blocked you returned %v, want forbidden", err)
+	}
+	if _, err := c.CreateChirp(ctx, "Own chirp"); err != nil {
+		t.Fatalf("CreateChirp failed: %v", err)
+	}
+	if body := nextTimelineChirp(); body != "Own chirp" {
+		t.Errorf("Timeline chirp = %q, want only the own chirp after the block", body)
+	}
+	stopStream()
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if err := c.Unblock(ctx, other.ID); err != nil {
+		t.Fatalf("Unblock failed: %v", err)
+	}
+	if err := c.Unblock(ctx, other.ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Unblocking a user you did not block returned %v, want not_found", err)
+	}
+	if err := c.Mute(ctx, other.ID); err != nil {
+		t.Fatalf("Mute failed: %v", err)
+	}
+	if chirps, err := c.ListChirps(ctx, client.ListChirpsOptions{}); err != nil || len(chirps) != len(created) {
+		t.Errorf("ListChirps = %d chirps, %v, want only your own %d", len(chirps), err, len(created))
+	}
+	if mutes, err := c.ListMutes(ctx); err != nil || len(mutes) != 1 || mutes[0].ID != other.ID {
+		t.Errorf("ListMutes = %+v, %v, want the other user", mutes, err)
+	}
+	if err := c.Unmute(ctx, other.ID); err != nil {
+		t.Fatalf("Unmute failed: %v", err)
+	}
+
+	// Protected users approve their followers, who alone see their chirps
+	profile, err = c.UpdateProfile(ctx, client.ProfileUpdate{IsProtected: client.Ptr(true)})
+	if err != nil || !profile.IsProtected {
+		t.Fatalf("UpdateProfile = %+v, %v, want a protected profile", profile, err)
+	}
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := c.GetChirp(ctx, created[1].ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Getting a protected chirp returned %v, want not_found", err)
+	}
+	if err := c.LikeChirp(ctx, created[1].ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Liking a protected chirp returned %v, want not_found", err)
+	}
+	if _, err := c.CreateConversation(ctx, user.ID); !client.IsCode(err, client.CodeForbidden) {
+		t.Errorf("Messaging a protected user returned %v, want forbidden", err)
+	}
+	if err := c.Follow(ctx, user.ID); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	// Updating other fields keeps the protection and the pending requests
+	profile, err = c.UpdateProfile(ctx, client.ProfileUpdate{Bio: client.Ptr("Protected")})
+	if err != nil || !profile.IsProtected || profile.Bio != "Protected" || profile.Username == nil || *profile.Username != "sdk_user" || profile.Avatar == nil {
+		t.Fatalf("UpdateProfile = %+v, %v, want only the bio updated", profile, err)
+	}
+	if requests, err := c.ListFollowRequests(ctx); err != nil || len(requests) != 1 || requests[0].ID != other.ID {
+		t.Fatalf("ListFollowRequests = %+v, %v, want the other user", requests, err)
+	}
+	if err := c.ApproveFollowRequest(ctx, other.ID); err != nil {
+		t.Fatalf("ApproveFollowRequest failed: %v", err)
+	}
+	if err := c.DeclineFollowRequest(ctx, other.ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Declining an approved request returned %v, want not_found", err)
+	}
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := c.GetChirp(ctx, created[1].ID); err != nil {
+		t.Errorf("GetChirp as an approved follower failed: %v", err)
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// Chirps for mentioned users only are hidden even from followers, and a
+	// content warning marks a chirp as sensitive
+	private, err := c.PostChirp(ctx, "Just for me", client.ChirpOptions{Visibility: client.VisibilityMentioned, ContentWarning: "spoilers"})
+	if err != nil || private.Visibility != client.VisibilityMentioned || private.ContentWarning != "spoilers" || !private.Sensitive {
+		t.Fatalf("PostChirp = %+v, %v, want a sensitive chirp for mentioned users", private, err)
+	}
+	if _, err := c.PostChirp(ctx, "Hello", client.ChirpOptions{Visibility: "friends"}); !client.IsCode(err, client.CodeValidationFailed) {
+		t.Errorf("Posting with an unknown visibility returned %v, want validation_failed", err)
+	}
+	if _, err := c.Login(ctx, "other@example.com", password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := c.GetChirp(ctx, private.ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Getting a chirp for mentioned users returned %v, want not_found", err)
+	}
+	if _, err := c.Login(ctx, email, password); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// An invalid access token is replaced through /api/refresh transparently
+	_, refreshToken := c.Tokens()
+	expired, err := auth.MakeJWT(user.ID, testTokenSecret, -time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to make JWT: %v", err)
+	}
+	c.SetTokens(expired, refreshToken)
+	if err := c.DeleteChirp(ctx, created[0].ID); err != nil {
+		t.Fatalf("DeleteChirp with an expired access token failed: %v", err)
+	}
+	if accessToken, _ := c.Tokens(); accessToken == expired {
+		t.Error("Expected the access token to be refreshed")
+	}
+	if _, err := c.GetChirp(ctx, created[0].ID); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("GetChirp of a deleted chirp returned %v, want not_found", err)
+	}
+
+	// Event IDs are unique per run, since the inbox outlives Reset
+	event := client.PolkaEvent{ID: "evt_upgrade_" + user.ID.String(), Event: "user.upgraded"}
+	event.Data.UserID = user.ID
+	if err := c.SendPolkaEvent(ctx, event); err != nil {
+		t.Fatalf("SendPolkaEvent failed: %v", err)
+	}
+	if user, err := c.Login(ctx, email, password); err != nil || !user.IsChirpyRed {
+		t.Errorf("Expected the user to be upgraded, got %+v, %v", user, err)
+	}
+
+	// A retried event is acknowledged without being applied again
+	if err := c.SendPolkaEvent(ctx, event); err != nil {
+		t.Fatalf("Retrying SendPolkaEvent failed: %v", err)
+	}
+	findEvent := func(status, eventID string) []client.WebhookEvent {
+		t.Helper()
+		events, err := c.ListWebhookEvents(ctx, status)
+		if err != nil {
+			t.Fatalf("ListWebhookEvents failed: %v", err)
+		}
+		return slices.DeleteFunc(events, func(e client.WebhookEvent) bool { return e.EventID != eventID })
+	}
+	if events := findEvent("processed", event.ID); len(events) != 1 || events[0].Attempts != 1 {
+		t.Fatalf("ListWebhookEvents = %+v, want the upgrade processed once", events)
+	}
+
+	// Failed events are recorded and can be replayed
+	missing := client.PolkaEvent{ID: "evt_missing_" + user.ID.String(), Event: "user.upgraded"}
+	missing.Data.UserID = uuid.New()
+	if err := c.SendPolkaEvent(ctx, missing); !client.IsCode(err, client.CodeNotFound) {
+		t.Errorf("Upgrading an unknown user returned %v, want not_found", err)
+	}
+	events := findEvent("failed", missing.ID)
+	if len(events) != 1 {
+		t.Fatalf("ListWebhookEvents = %+v, want the failed event", events)
+	}
+	failed := events[0]
+	if replayed, err := c.ReplayWebhookEvent(ctx, failed.ID); err != nil || replayed.Status != "failed" || replayed.Attempts != 2 {
+		t.Errorf("ReplayWebhookEvent = %+v, %v, want a second failed attempt", replayed, err)
+	}
+
+	// An event stuck processing is only claimed again after its lease
+	if _, err := db.ExecContext(ctx, "UPDATE webhook_events SET status = 'processing', updated_at = NOW() WHERE id = $1", failed.ID); err != nil {
+		t.Fatalf("Failed to mark the event as processing: %v", err)
+	}
+	if _, err := c.ReplayWebhookEvent(ctx, failed.ID); !client.IsCode(err, client.CodeConflict) {
+		t.Errorf("Replaying an event being processed returned %v, want conflict", err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE webhook_events SET updated_at = NOW() - INTERVAL '1 hour' WHERE id = $1", failed.ID); err != nil {
+		t.Fatalf("Failed to age the event: %v", err)
+	}
+	if replayed, err := c.ReplayWebhookEvent(ctx, failed.ID); err != nil || replayed.Status != "failed" {
+		t.Errorf("Replaying a stale processing event = %+v, %v, want it claimed again", replayed, err)
+	}
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if err := c.Refresh(ctx); !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("Refresh after Logout returned %v, want ErrNotLoggedIn", err)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is the stable, machine-readable identifier of an API error.
+type ErrorCode string
+
+// Error codes returned by the API.
+const (
+	CodeInvalidBody        ErrorCode = "invalid_body"
+	CodeBodyTooLarge       ErrorCode = "body_too_large"
+	CodeInvalidParameter   ErrorCode = "invalid_parameter"
+	CodeValidationFailed   ErrorCode = "validation_failed"
+	CodeMissingCredentials ErrorCode = "missing_credentials"
+	CodeInvalidCredentials ErrorCode = "invalid_credentials"
+	CodeInvalidToken       ErrorCode = "invalid_token"
+	CodeTokenExpired       ErrorCode = "token_expired"
+	CodeInvalidAPIKey      ErrorCode = "invalid_api_key"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeAdminDisabled      ErrorCode = "admin_disabled"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeConflict           ErrorCode = "conflict"
+	CodeInternal           ErrorCode = "internal_error"
+)
+
+// problemContentType is the media type of problem responses (RFC 7807).
+const problemContentType = "application/problem+json"
+
+// Error is an error response of the API: an RFC 7807 problem details object.
+type Error struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("chirpy: %d %s", e.Status, e.Title)
+	if e.Code != "" {
+		msg += " (" + string(e.Code) + ")"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, field := range e.Errors {
+		msg += fmt.Sprintf("; %s %s", field.Field, field.Message)
+	}
+	return msg
+}
+
+// IsCode reports whether err is an *Error with the given code.
+func IsCode(err error, code ErrorCode) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
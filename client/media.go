@@ -0,0 +1,41 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// UploadMedia uploads a JPEG, PNG or GIF image as the logged in user. The
+// returned media can be attached to a chirp with CreateChirp.
+func (c *Client) UploadMedia(ctx context.Context, filename string, r io.Reader) (*Media, error) {
+	// The body is buffered so that it can be sent again after a token refresh
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request body: %w", err)
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode request body: %w", err)
+	}
+
+	var media Media
+	err = c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/api/media",
+		auth:        authBearer,
+		raw:         body.Bytes(),
+		contentType: writer.FormDataContentType(),
+	}, &media)
+	if err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// CreateConversation starts a conversation between the logged in user and the
+// given users: a direct conversation with one user, which is returned if it
+// already exists, or a group conversation with up to nine.
+func (c *Client) CreateConversation(ctx context.Context, memberIDs ...uuid.UUID) (*Conversation, error) {
+	var conversation Conversation
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/conversations",
+		auth:   authBearer,
+		body: struct {
+			MemberIDs []uuid.UUID `json:"member_ids"`
+		}{memberIDs},
+	}, &conversation)
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// ListConversations returns the conversations of the logged in user, the one
+// with the latest message first.
+func (c *Client) ListConversations(ctx context.Context) ([]Conversation, error) {
+	var conversations []Conversation
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/conversations", auth: authBearer}, &conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// SendMessage sends a message to a conversation of the logged in user.
+func (c *Client) SendMessage(ctx context.Context, conversationID uuid.UUID, body string) (*Message, error) {
+	var message Message
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/conversations/" + conversationID.String() + "/messages",
+		auth:   authBearer,
+		body: struct {
+			Body string `json:"body"`
+		}{body},
+	}, &message)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ListMessages returns a single page of the messages of a conversation, newest
+// first. A limit of zero uses the server's default; an empty cursor starts at
+// the newest message, and the NextCursor of a page continues after it.
+func (c *Client) ListMessages(ctx context.Context, conversationID uuid.UUID, limit int, cursor string) (*MessagePage, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	var page MessagePage
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/api/conversations/" + conversationID.String() + "/messages",
+		query:  query,
+		auth:   authBearer,
+	}, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Messages iterates over every message of a conversation, newest first,
+// fetching limit messages per request. Iteration stops at the first error,
+// which is yielded with a zero Message.
+func (c *Client) Messages(ctx context.Context, conversationID uuid.UUID, limit int) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		cursor := ""
+		for {
+			page, err := c.ListMessages(ctx, conversationID, limit, cursor)
+			if err != nil {
+				yield(Message{}, err)
+				return
+			}
+			for _, message := range page.Messages {
+				if !yield(message, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			cursor = page.NextCursor
+		}
+	}
+}
+
+// MarkMessageRead marks a message, and every earlier message of its
+// conversation, as read by the logged in user.
+func (c *Client) MarkMessageRead(ctx context.Context, conversationID, messageID uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/conversations/" + conversationID.String() + "/messages/" + messageID.String() + "/read",
+		auth:   authBearer,
+	}, nil)
+}
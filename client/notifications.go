@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Types of notifications.
+const (
+	NotificationMention        = "mention"
+	NotificationFollow         = "follow"
+	NotificationLike           = "like"
+	NotificationReply          = "reply"
+	NotificationFollowRequest  = "follow_request"
+	NotificationFollowAccepted = "follow_accepted"
+)
+
+// ListNotifications returns a single page of the logged in user's
+// notifications, most recently updated first. A limit of zero uses the
+// server's default; an empty cursor starts at the newest notification, and the
+// NextCursor of a page continues after it.
+func (c *Client) ListNotifications(ctx context.Context, limit int, cursor string) (*NotificationPage, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	var page NotificationPage
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/notifications", query: query, auth: authBearer}, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Notifications iterates over every notification of the logged in user,
+// fetching limit notifications per request. Iteration stops at the first
+// error, which is yielded with a zero Notification.
+func (c *Client) Notifications(ctx context.Context, limit int) iter.Seq2[Notification, error] {
+	return func(yield func(Notification, error) bool) {
+		cursor := ""
+		for {
+			page, err := c.ListNotifications(ctx, limit, cursor)
+			if err != nil {
+				yield(Notification{}, err)
+				return
+			}
+			for _, notification := range page.Notifications {
+				if !yield(notification, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			cursor = page.NextCursor
+		}
+	}
+}
+
+// MarkNotificationRead marks the notification with the given ID as read.
+func (c *Client) MarkNotificationRead(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/notifications/" + id.String() + "/read", auth: authBearer}, nil)
+}
+
+// MarkAllNotificationsRead marks every notification of the logged in user as
+// read.
+func (c *Client) MarkAllNotificationsRead(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/notifications/read", auth: authBearer}, nil)
+}
+
+// UnreadNotificationCount returns the number of unread notifications of the
+// logged in user.
+func (c *Client) UnreadNotificationCount(ctx context.Context) (int64, error) {
+	var out struct {
+		UnreadCount int64 `json:"unread_count"`
+	}
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/notifications/unread_count", auth: authBearer}, &out); err != nil {
+		return 0, err
+	}
+	return out.UnreadCount, nil
+}
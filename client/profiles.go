@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// GetUser returns the public profile of the user with the given ID.
+func (c *Client) GetUser(ctx context.Context, id uuid.UUID) (*Profile, error) {
+	var profile Profile
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/users/" + id.String()}, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// UpdateProfile updates the profile fields of the logged in user that are set
+// in update and returns their updated profile.
+func (c *Client) UpdateProfile(ctx context.Context, update ProfileUpdate) (*Profile, error) {
+	var profile Profile
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   "/api/profile",
+		auth:   authBearer,
+		body:   update,
+	}, &profile)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// Follow makes the logged in user follow the user with the given ID. If that
+// user is protected, it sends them a follow request instead, which they
+// approve or decline.
+func (c *Client) Follow(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/users/" + id.String() + "/follow",
+		auth:   authBearer,
+	}, nil)
+}
+
+// Unfollow makes the logged in user stop following the user with the given ID,
+// or withdraws their pending request to follow them.
+func (c *Client) Unfollow(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/api/users/" + id.String() + "/follow",
+		auth:   authBearer,
+	}, nil)
+}
+
+// ListFollowRequests returns the users who asked to follow the logged in user,
+// most recent request first.
+func (c *Client) ListFollowRequests(ctx context.Context) ([]RelatedUser, error) {
+	var users []RelatedUser
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/follow_requests", auth: authBearer}, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ApproveFollowRequest makes the user with the given ID, who asked to follow
+// the logged in user, one of their followers.
+func (c *Client) ApproveFollowRequest(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/follow_requests/" + id.String() + "/approve",
+		auth:   authBearer,
+	}, nil)
+}
+
+// DeclineFollowRequest declines the request of the user with the given ID to
+// follow the logged in user.
+func (c *Client) DeclineFollowRequest(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/api/follow_requests/" + id.String(),
+		auth:   authBearer,
+	}, nil)
+}
+
+// Block makes the logged in user block the user with the given ID. Blocking
+// ends the follows between the two users, and neither can follow, mention,
+// reply to or message the other until the block is lifted.
+func (c *Client) Block(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/users/" + id.String() + "/block",
+		auth:   authBearer,
+	}, nil)
+}
+
+// Unblock lifts the logged in user's block of the user with the given ID.
+func (c *Client) Unblock(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/api/users/" + id.String() + "/block",
+		auth:   authBearer,
+	}, nil)
+}
+
+// ListBlocks returns the users the logged in user blocked, most recently
+// blocked first.
+func (c *Client) ListBlocks(ctx context.Context) ([]RelatedUser, error) {
+	var users []RelatedUser
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/blocks", auth: authBearer}, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Mute hides the chirps and actions of the user with the given ID from the
+// logged in user.
+func (c *Client) Mute(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/users/" + id.String() + "/mute",
+		auth:   authBearer,
+	}, nil)
+}
+
+// Unmute makes the logged in user stop muting the user with the given ID.
+func (c *Client) Unmute(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/api/users/" + id.String() + "/mute",
+		auth:   authBearer,
+	}, nil)
+}
+
+// ListMutes returns the users the logged in user muted, most recently muted
+// first.
+func (c *Client) ListMutes(ctx context.Context) ([]RelatedUser, error) {
+	var users []RelatedUser
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/mutes", auth: authBearer}, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
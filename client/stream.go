@@ -0,0 +1,173 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the events yielded by Stream.
+const (
+	StreamChirpCreated = "chirp.created"
+	StreamChirpDeleted = "chirp.deleted"
+	// StreamReset means events were missed while reconnecting; fetch the
+	// chirps again.
+	StreamReset = "reset"
+)
+
+// defaultStreamRetry is how long Stream waits before reconnecting unless the
+// server suggests another delay.
+const defaultStreamRetry = 3 * time.Second
+
+// StreamOptions select the events yielded by Stream.
+type StreamOptions struct {
+	// Timeline streams the chirps of the logged in user and the users they
+	// follow instead of every chirp.
+	Timeline bool
+	// AuthorID, if set, only streams the chirps of this user. It cannot be
+	// combined with Timeline.
+	AuthorID uuid.UUID
+	// LastEventID resumes a stream after the event with this ID.
+	LastEventID string
+}
+
+// StreamEvent is an event of the real-time stream. Chirp is nil for
+// StreamReset events.
+type StreamEvent struct {
+	ID    string
+	Type  string
+	Chirp *Chirp
+}
+
+// Stream iterates over the chirp events of the real-time stream as they
+// happen, until ctx is done. When the connection drops, it reconnects and
+// resumes after the last event it yielded. Errors are yielded with a zero
+// StreamEvent; if the loop continues, Stream reconnects after a delay.
+func (c *Client) Stream(ctx context.Context, opts StreamOptions) iter.Seq2[StreamEvent, error] {
+	return func(yield func(StreamEvent, error) bool) {
+		lastID, retry := opts.LastEventID, defaultStreamRetry
+		for ctx.Err() == nil {
+			err := c.readStream(ctx, opts, lastID, func(event StreamEvent, delay time.Duration) bool {
+				if delay > 0 {
+					retry = delay
+				}
+				if event.Type == "" {
+					return true
+				}
+				if event.ID != "" {
+					lastID = event.ID
+				}
+				return yield(event, nil)
+			})
+			if errors.Is(err, errStopStream) || ctx.Err() != nil {
+				return
+			}
+			if err != nil && !yield(StreamEvent{}, err) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+		}
+	}
+}
+
+// errStopStream is returned by readStream when the caller stopped iterating.
+var errStopStream = errors.New("stream stopped")
+
+// readStream opens one connection to the stream and passes its events to
+// handle, along with reconnection delays suggested by the server, until the
+// connection ends or handle returns false.
+func (c *Client) readStream(ctx context.Context, opts StreamOptions, lastID string, handle func(StreamEvent, time.Duration) bool) error {
+	query := url.Values{}
+	if opts.Timeline {
+		query.Set("feed", "timeline")
+	}
+	if opts.AuthorID != uuid.Nil {
+		query.Set("author_id", opts.AuthorID.String())
+	}
+	if lastID != "" {
+		query.Set("last_event_id", lastID)
+	}
+	req := request{method: http.MethodGet, path: "/api/stream", query: query}
+	if opts.Timeline {
+		req.auth = authBearer
+	}
+
+	resp, err := c.send(ctx, req, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && opts.Timeline {
+		if _, refreshToken := c.Tokens(); refreshToken != "" {
+			resp.Body.Close()
+			if err := c.Refresh(ctx); err != nil {
+				return err
+			}
+			if resp, err = c.send(ctx, req, nil); err != nil {
+				return err
+			}
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+
+	var event StreamEvent
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event
+			if event.Type != "" && event.Type != StreamReset {
+				var chirp Chirp
+				if err := json.Unmarshal([]byte(data.String()), &chirp); err != nil {
+					return fmt.Errorf("failed to decode %s event: %w", event.Type, err)
+				}
+				event.Chirp = &chirp
+			}
+			if !handle(event, 0) {
+				return errStopStream
+			}
+			event, data = StreamEvent{}, strings.Builder{}
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Type = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				handle(StreamEvent{}, time.Duration(ms)*time.Millisecond)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream interrupted: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User is a Chirpy user. Token and RefreshToken are only set by Login.
+type User struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Email        string    `json:"email"`
+	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Token        string    `json:"token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+}
+
+// Chirp is a short message posted by a user.
+type Chirp struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Body      string     `json:"body"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ReplyToID *uuid.UUID `json:"reply_to_id"`
+	// Visibility is one of the Visibility constants.
+	Visibility string `json:"visibility"`
+	// Sensitive chirps should be collapsed behind their content warning, if
+	// any, until the reader expands them.
+	ContentWarning string  `json:"content_warning"`
+	Sensitive      bool    `json:"sensitive"`
+	Media          []Media `json:"media,omitempty"`
+}
+
+// Profile is the public profile of a user, which never includes their email
+// address.
+type Profile struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Username       *string   `json:"username"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	Location       string    `json:"location"`
+	Website        string    `json:"website"`
+	Avatar         *Media    `json:"avatar"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	IsProtected    bool      `json:"is_protected"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+}
+
+// ProfileUpdate holds the profile fields set by UpdateProfile. Nil fields keep
+// their values, an empty Username removes the username and RemoveAvatar
+// removes the avatar. IsProtected makes the user approve their followers, who
+// alone see their chirps.
+type ProfileUpdate struct {
+	Username      *string    `json:"username,omitempty"`
+	DisplayName   *string    `json:"display_name,omitempty"`
+	Bio           *string    `json:"bio,omitempty"`
+	Location      *string    `json:"location,omitempty"`
+	Website       *string    `json:"website,omitempty"`
+	AvatarMediaID *uuid.UUID `json:"avatar_media_id,omitempty"`
+	RemoveAvatar  bool       `json:"-"`
+	IsProtected   *bool      `json:"is_protected,omitempty"`
+}
+
+// MarshalJSON sends a null avatar_media_id when RemoveAvatar is set.
+func (u ProfileUpdate) MarshalJSON() ([]byte, error) {
+	type fields ProfileUpdate
+	if !u.RemoveAvatar {
+		return json.Marshal(fields(u))
+	}
+	return json.Marshal(struct {
+		fields
+		AvatarMediaID *uuid.UUID `json:"avatar_media_id"`
+	}{fields: fields(u)})
+}
+
+// Ptr returns a pointer to v, for the optional fields of ProfileUpdate.
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Notification tells the logged in user that one or more actors mentioned,
+// followed, liked or replied to them. Actors has the most recent of them and
+// ActorCount counts all of them.
+type Notification struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Type       string     `json:"type"`
+	ChirpID    *uuid.UUID `json:"chirp_id"`
+	Read       bool       `json:"read"`
+	ReadAt     *time.Time `json:"read_at"`
+	Actors     []Actor    `json:"actors"`
+	ActorCount int64      `json:"actor_count"`
+	Summary    string     `json:"summary"`
+}
+
+// Actor is a user who caused a notification.
+type Actor struct {
+	ID          uuid.UUID `json:"id"`
+	Username    *string   `json:"username"`
+	DisplayName string    `json:"display_name"`
+}
+
+// RelatedUser is a user the logged in user blocked or muted, or who asked to
+// follow them, returned by ListBlocks, ListMutes and ListFollowRequests.
+type RelatedUser struct {
+	ID          uuid.UUID `json:"id"`
+	Username    *string   `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Since       time.Time `json:"since"`
+}
+
+// NotificationPage is a page of notifications returned by ListNotifications.
+// NextCursor is empty on the last page.
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor"`
+}
+
+// Conversation is a direct-message conversation: a direct one between two
+// users or a group of up to ten. UnreadCount counts the messages of the other
+// members the logged in user has not read.
+type Conversation struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Direct      bool      `json:"direct"`
+	Members     []Member  `json:"members"`
+	UnreadCount int64     `json:"unread_count"`
+}
+
+// Member is a member of a conversation. LastReadAt is the creation time of the
+// latest message they read.
+type Member struct {
+	ID          uuid.UUID  `json:"id"`
+	Username    *string    `json:"username"`
+	DisplayName string     `json:"display_name"`
+	LastReadAt  *time.Time `json:"last_read_at"`
+}
+
+// Message is a direct message. ReadBy lists the other members who have read it.
+type Message struct {
+	ID             uuid.UUID   `json:"id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	SenderID       uuid.UUID   `json:"sender_id"`
+	Body           string      `json:"body"`
+	ReadBy         []uuid.UUID `json:"read_by"`
+}
+
+// MessagePage is a page of messages returned by ListMessages. NextCursor is
+// empty on the last page.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// Media is an uploaded image. URL and ThumbnailURL are paths relative to the
+// server's base URL.
+type Media struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+// Readiness reports whether the server is ready, and the result of each of its
+// checks.
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Ready reports whether every check passed.
+func (r *Readiness) Ready() bool {
+	return r.Status == "ok"
+}
+
+// PolkaEvent is an event sent by the payment provider.
+type PolkaEvent struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID uuid.UUID `json:"user_id"`
+	} `json:"data"`
+}
+
+// WebhookSubscription is a URL subscribed to events. Secret, the key the
+// deliveries are signed with, is only set when the subscription is created.
+type WebhookSubscription struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+// WebhookDelivery is an attempt to deliver an event to a subscription.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	ResponseStatus int32           `json:"response_status,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// WebhookEvent is an incoming event recorded by the server.
+type WebhookEvent struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Healthz checks that the server is running.
+func (c *Client) Healthz(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodGet, path: "/api/healthz"}, nil)
+}
+
+// Readyz returns the readiness of the server. A server that is not ready is
+// not an error; check Readiness.Ready.
+func (c *Client) Readyz(ctx context.Context) (*Readiness, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/api/readyz"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, decodeError(resp)
+	}
+	var readiness Readiness
+	if err := json.NewDecoder(resp.Body).Decode(&readiness); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &readiness, nil
+}
+
+// CreateUser creates a user. The password must meet the server's password
+// policy; a rejected password is an *Error with CodeValidationFailed.
+func (c *Client) CreateUser(ctx context.Context, email, password string) (*User, error) {
+	var user User
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/users",
+		body:   credentials{email, password},
+	}, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login logs in and keeps the returned access and refresh tokens to
+// authenticate later requests.
+func (c *Client) Login(ctx context.Context, email, password string) (*User, error) {
+	var user User
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/login",
+		body:   credentials{email, password},
+	}, &user)
+	if err != nil {
+		return nil, err
+	}
+	c.SetTokens(user.Token, user.RefreshToken)
+	return &user, nil
+}
+
+// Refresh replaces the access token with a new one, using the refresh token.
+// It is called automatically when a request is rejected because the access
+// token expired.
+func (c *Client) Refresh(ctx context.Context) error {
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/refresh", auth: authRefresh}, &response); err != nil {
+		return err
+	}
+
+	_, refreshToken := c.Tokens()
+	c.SetTokens(response.Token, refreshToken)
+	return nil
+}
+
+// Logout revokes the refresh token and forgets both tokens.
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.do(ctx, request{method: http.MethodPost, path: "/api/revoke", auth: authRefresh}, nil)
+	if err != nil && !errors.Is(err, ErrNotLoggedIn) {
+		return err
+	}
+	c.SetTokens("", "")
+	return nil
+}
+
+// UpdateUser changes the email address and password of the logged in user.
+func (c *Client) UpdateUser(ctx context.Context, email, password string) (*User, error) {
+	var user User
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   "/api/users",
+		auth:   authBearer,
+		body:   credentials{email, password},
+	}, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Reset deletes every user. The server only allows it when PLATFORM is "dev".
+func (c *Client) Reset(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/admin/reset"}, nil)
+}
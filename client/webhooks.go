@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// Events a webhook subscription can receive.
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+	EventUserUpgraded = "user.upgraded"
+)
+
+// CreateWebhookSubscription subscribes a URL to events of the logged in user.
+// The returned subscription's Secret is only available now.
+func (c *Client) CreateWebhookSubscription(ctx context.Context, target string, events ...string) (*WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/webhooks",
+		auth:   authBearer,
+		body: struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}{target, events},
+	}, &subscription)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ListWebhookSubscriptions returns the logged in user's webhook subscriptions.
+func (c *Client) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/webhooks", auth: authBearer}, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// DeleteWebhookSubscription deletes one of the logged in user's webhook
+// subscriptions.
+func (c *Client) DeleteWebhookSubscription(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/api/webhooks/" + id.String(), auth: authBearer}, nil)
+}
+
+// ListWebhookDeliveries returns the most recent deliveries of one of the
+// logged in user's webhook subscriptions.
+func (c *Client) ListWebhookDeliveries(ctx context.Context, subscriptionID uuid.UUID) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/api/webhooks/" + subscriptionID.String() + "/deliveries",
+		auth:   authBearer,
+	}, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// SendPolkaEvent sends a payment provider event, authenticated with the key
+// set by WithPolkaKey.
+func (c *Client) SendPolkaEvent(ctx context.Context, event PolkaEvent) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/api/polka/webhooks", auth: authPolkaKey, body: event}, nil)
+}
+
+// ListWebhookEvents returns the incoming events recorded by the server,
+// optionally only those with the given status, e.g. "failed". It needs the key
+// set by WithAdminKey.
+func (c *Client) ListWebhookEvents(ctx context.Context, status string) ([]WebhookEvent, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	var events []WebhookEvent
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/admin/webhooks/events", query: query, auth: authAdminKey}, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ReplayWebhookEvent processes a pending or failed incoming event, or one left
+// processing for longer than its lease, again and returns it afterwards. It
+// needs the key set by WithAdminKey.
+func (c *Client) ReplayWebhookEvent(ctx context.Context, id uuid.UUID) (*WebhookEvent, error) {
+	var event WebhookEvent
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/admin/webhooks/events/" + id.String() + "/replay",
+		auth:   authAdminKey,
+	}, &event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Channels a Socket can subscribe to.
+const (
+	// ChannelTimeline carries the chirp events of the logged in user and the
+	// users they follow.
+	ChannelTimeline = "timeline"
+	// ChannelMentions carries the chirps that mention the logged in user.
+	ChannelMentions = "mentions"
+	// ChannelNotifications carries the notifications, new followers and
+	// Chirpy Red upgrade of the logged in user.
+	ChannelNotifications = "notifications"
+	// ChannelMessages carries the direct messages sent to the logged in user.
+	ChannelMessages = "messages"
+)
+
+// Types of the messages received from a Socket.
+const (
+	MessageEvent         = "event"
+	MessageSubscribed    = "subscribed"
+	MessageUnsubscribed  = "unsubscribed"
+	MessageAuthenticated = "authenticated"
+	MessageTokenExpiring = "token_expiring"
+	MessagePong          = "pong"
+	MessageError         = "error"
+)
+
+// Types of the events of the notifications and messages channels. The
+// notifications channel also carries EventUserUpgraded, and the other channels
+// EventChirpCreated and EventChirpDeleted.
+const (
+	EventNotificationCreated = "notification.created"
+	EventUserFollowed        = "user.followed"
+	EventMessageCreated      = "message.created"
+)
+
+// closeTokenExpired is the close code of a Socket whose access token expired.
+const closeTokenExpired = 4001
+
+// ErrTokenExpired is returned by Socket.Receive when the server closed the
+// connection because the access token expired.
+var ErrTokenExpired = errors.New("access token expired")
+
+// socketWriteTimeout bounds every message sent on a Socket.
+const socketWriteTimeout = 10 * time.Second
+
+// SocketMessage is a message received from a Socket. Events have the channel,
+// the event type, ID and data of the event; errors have a code and a message.
+type SocketMessage struct {
+	Type      string          `json:"type"`
+	Channel   string          `json:"channel"`
+	Event     string          `json:"event"`
+	ID        string          `json:"id"`
+	Data      json.RawMessage `json:"data"`
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	ExpiresAt *time.Time      `json:"expires_at"`
+}
+
+// Chirp decodes the chirp of a chirp event.
+func (m SocketMessage) Chirp() (*Chirp, error) {
+	var chirp Chirp
+	if err := json.Unmarshal(m.Data, &chirp); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", m.Event, err)
+	}
+	return &chirp, nil
+}
+
+// Follow decodes the follow of a user.followed event.
+func (m SocketMessage) Follow() (*Follow, error) {
+	var follow Follow
+	if err := json.Unmarshal(m.Data, &follow); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", m.Event, err)
+	}
+	return &follow, nil
+}
+
+// Notification decodes the notification of a notification.created event.
+func (m SocketMessage) Notification() (*NotificationEvent, error) {
+	var notification NotificationEvent
+	if err := json.Unmarshal(m.Data, &notification); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", m.Event, err)
+	}
+	return &notification, nil
+}
+
+// DirectMessage decodes the direct message of a message.created event.
+func (m SocketMessage) DirectMessage() (*Message, error) {
+	var message Message
+	if err := json.Unmarshal(m.Data, &message); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %w", m.Event, err)
+	}
+	return &message, nil
+}
+
+// Follow is a user following another.
+type Follow struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	FolloweeID uuid.UUID `json:"followee_id"`
+}
+
+// NotificationEvent is a notification created, or joined by another actor,
+// for the logged in user. Fetch the notifications to get its summary.
+type NotificationEvent struct {
+	NotificationID uuid.UUID  `json:"notification_id"`
+	Type           string     `json:"type"`
+	ActorID        uuid.UUID  `json:"actor_id"`
+	ChirpID        *uuid.UUID `json:"chirp_id"`
+}
+
+// Socket is a WebSocket connection to the real-time API, opened by Connect.
+// Receive must be called in a loop, which also answers the server's pings.
+// The other methods may be called concurrently with Receive.
+type Socket struct {
+	client *Client
+	conn   *websocket.Conn
+
+	mu sync.Mutex // serializes writes
+}
+
+// Connect opens a WebSocket connection for the logged in user. If the access
+// token is rejected, it gets a new one and tries again once.
+func (c *Client) Connect(ctx context.Context) (*Socket, error) {
+	u := c.baseURL.JoinPath("/api/ws")
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	dial := func() (*websocket.Conn, *http.Response, error) {
+		accessToken, _ := c.Tokens()
+		if accessToken == "" {
+			return nil, nil, ErrNotLoggedIn
+		}
+		header := http.Header{"Authorization": {"Bearer " + accessToken}}
+		return websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	}
+
+	conn, resp, err := dial()
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		if _, refreshToken := c.Tokens(); refreshToken != "" {
+			resp.Body.Close()
+			if err := c.Refresh(ctx); err != nil {
+				return nil, err
+			}
+			conn, resp, err = dial()
+		}
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 {
+			defer resp.Body.Close()
+			return nil, decodeError(resp)
+		}
+		if errors.Is(err, ErrNotLoggedIn) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("GET /api/ws: %w", err)
+	}
+	return &Socket{client: c, conn: conn}, nil
+}
+
+// Subscribe subscribes to a channel. The server confirms with a
+// MessageSubscribed message, or answers with a MessageError message.
+func (s *Socket) Subscribe(channel string) error {
+	return s.send(map[string]string{"type": "subscribe", "channel": channel})
+}
+
+// Unsubscribe unsubscribes from a channel.
+func (s *Socket) Unsubscribe(channel string) error {
+	return s.send(map[string]string{"type": "unsubscribe", "channel": channel})
+}
+
+// Reauthenticate gets a new access token and sends it to the server, which
+// keeps the connection open until the new token expires. Receive does so
+// itself when the server warns that the token is expiring.
+func (s *Socket) Reauthenticate(ctx context.Context) error {
+	if err := s.client.Refresh(ctx); err != nil {
+		return err
+	}
+	accessToken, _ := s.client.Tokens()
+	return s.send(map[string]string{"type": "auth", "token": accessToken})
+}
+
+// Receive waits for the next message. When the server warns that the access
+// token is expiring, Receive reauthenticates, if the client has a refresh
+// token, before returning the warning. Once the connection is closed, it
+// returns ErrTokenExpired if the token expired, or another error.
+func (s *Socket) Receive() (SocketMessage, error) {
+	var msg SocketMessage
+	if err := s.conn.ReadJSON(&msg); err != nil {
+		if websocket.IsCloseError(err, closeTokenExpired) {
+			return SocketMessage{}, ErrTokenExpired
+		}
+		return SocketMessage{}, err
+	}
+
+	if msg.Type == MessageTokenExpiring {
+		if _, refreshToken := s.client.Tokens(); refreshToken != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), socketWriteTimeout)
+			defer cancel()
+			if err := s.Reauthenticate(ctx); err != nil {
+				return msg, fmt.Errorf("failed to reauthenticate: %w", err)
+			}
+		}
+	}
+	return msg, nil
+}
+
+// Close closes the connection.
+func (s *Socket) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	s.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteTimeout))
+	return s.conn.Close()
+}
+
+func (s *Socket) send(msg any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return s.conn.WriteJSON(msg)
+}
@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,25 +12,40 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Fepozopo/chirpy/internal/auth")
+
+// ErrEmptyPassword is returned when hashing an empty password.
+var ErrEmptyPassword = errors.New("password must not be empty")
+
 // HashPassword takes a password string and returns a hashed string of the
-// password and an error. The hashed string is a string of bytes generated
-// by the bcrypt.GenerateFromPassword() function. The error is returned if
-// there is an error generating the hash.
+// password and an error. The hash is created by the DefaultPasswordHasher, with
+// Argon2id. The error is returned if the password is empty or there is an error
+// generating the hash. Passwords chosen by users should be checked against a
+// PasswordPolicy first.
 func HashPassword(password string) (string, error) {
-	hashBytes, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
-		return "", err
-	}
-	return string(hashBytes), nil
+	return HashPasswordContext(context.Background(), password)
+}
+
+// HashPasswordContext is like HashPassword, but records the deliberately slow
+// hashing as a span that is a child of the span in the given context.
+func HashPasswordContext(ctx context.Context, password string) (string, error) {
+	return DefaultPasswordHasher().Hash(ctx, password)
 }
 
-// CheckPasswordHash checks if the given password matches the given hash.
-// It returns an error if the password does not match the hash.
+// CheckPasswordHash checks if the given password matches the given hash, which
+// may have been created with Argon2id or bcrypt. It returns an error if the
+// password does not match the hash.
 func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return CheckPasswordHashContext(context.Background(), password, hash)
+}
+
+// CheckPasswordHashContext is like CheckPasswordHash, but records the
+// comparison as a span that is a child of the span in the given context.
+func CheckPasswordHashContext(ctx context.Context, password, hash string) error {
+	return DefaultPasswordHasher().Verify(ctx, password, hash)
 }
 
 // MakeJWT creates a JWT token containing the given userID as the subject and
@@ -51,24 +68,35 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 // Subject field of the token claims. If the token is invalid or the Subject
 // field is not a valid UUID, it returns an error.
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	userID, _, err := ValidateJWTExpiry(tokenString, tokenSecret)
+	return userID, err
+}
+
+// ValidateJWTExpiry is like ValidateJWT, but also returns when the token
+// expires, for connections that outlive a single request. Tokens without an
+// expiry are rejected.
+func ValidateJWTExpiry(tokenString, tokenSecret string) (uuid.UUID, time.Time, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(tokenSecret), nil
 	})
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("failed to parse token: %w", err)
+		return uuid.UUID{}, time.Time{}, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	claims, ok := token.Claims.(*jwt.RegisteredClaims)
 	if !ok {
-		return uuid.UUID{}, fmt.Errorf("expected *jwt.RegisteredClaims, got %T", token.Claims)
+		return uuid.UUID{}, time.Time{}, fmt.Errorf("expected *jwt.RegisteredClaims, got %T", token.Claims)
 	}
 
 	userID, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.UUID{}, fmt.Errorf("expected valid uuid in Subject field, got %q", claims.Subject)
+		return uuid.UUID{}, time.Time{}, fmt.Errorf("expected valid uuid in Subject field, got %q", claims.Subject)
+	}
+	if claims.ExpiresAt == nil {
+		return uuid.UUID{}, time.Time{}, fmt.Errorf("token has no expiry")
 	}
 
-	return userID, nil
+	return userID, claims.ExpiresAt.Time, nil
 }
 
 // GetBearerToken extracts the Bearer token from the Authorization header
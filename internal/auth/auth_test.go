@@ -82,6 +82,31 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+func TestValidateJWTExpiry(t *testing.T) {
+	userID := uuid.New()
+	before := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	token, err := MakeJWT(userID, "mySecret", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	validUserID, expiresAt, err := ValidateJWTExpiry(token, "mySecret")
+	if err != nil {
+		t.Fatalf("Failed to validate JWT: %v", err)
+	}
+	if validUserID != userID || expiresAt.Before(before) || expiresAt.After(before.Add(2*time.Second)) {
+		t.Errorf("Expected %s expiring at %s, got %s expiring at %s", userID, before, validUserID, expiresAt)
+	}
+
+	expired, err := MakeJWT(userID, "mySecret", -time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	if _, _, err := ValidateJWTExpiry(expired, "mySecret"); err == nil {
+		t.Error("ValidateJWTExpiry accepted an expired token")
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	validToken := "myValidToken"
 	headers := http.Header{
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultBreachedPasswords is a small bundled corpus of commonly used passwords.
+//
+//go:embed breached_passwords.txt
+var defaultBreachedPasswords string
+
+// BreachedCorpus is a local copy of breached password hashes in the Have I Been
+// Pwned "Pwned Passwords" format: one upper case hex SHA-1 hash and the number of
+// times it was seen per line, separated by a colon. Lines starting with # are
+// ignored.
+//
+// Lookups follow the k-anonymity model of the Pwned Passwords range API: only
+// the first five characters of a password's hash select a range, and the rest
+// of the hash is matched within that range. The corpus is kept in memory, so it
+// is meant for curated lists rather than the full multi-gigabyte download, and
+// LoadBreachedCorpus refuses files larger than MaxBreachedCorpusBytes.
+type BreachedCorpus struct {
+	ranges map[string]map[string]int
+}
+
+// MaxBreachedCorpusBytes is the largest corpus file LoadBreachedCorpus reads,
+// about a million hashes, which take a few hundred megabytes of memory.
+const MaxBreachedCorpusBytes = 48 << 20
+
+// rangePrefixLength is the length of the hash prefix that selects a range.
+const rangePrefixLength = 5
+
+// DefaultBreachedCorpus returns the corpus bundled with Chirpy.
+func DefaultBreachedCorpus() *BreachedCorpus {
+	corpus, err := ReadBreachedCorpus(strings.NewReader(defaultBreachedPasswords))
+	if err != nil {
+		panic(fmt.Sprintf("invalid bundled breached password corpus: %v", err))
+	}
+	return corpus
+}
+
+// LoadBreachedCorpus reads a corpus from the file at the given path.
+func LoadBreachedCorpus(path string) (*BreachedCorpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password corpus: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password corpus: %w", err)
+	}
+	if info.Size() > MaxBreachedCorpusBytes {
+		return nil, fmt.Errorf("breached password corpus is %d bytes, more than the limit of %d; use a curated list, such as the most common passwords, rather than the full Pwned Passwords download", info.Size(), MaxBreachedCorpusBytes)
+	}
+	return ReadBreachedCorpus(f)
+}
+
+// ReadBreachedCorpus reads a corpus in the Pwned Passwords format from r.
+func ReadBreachedCorpus(r io.Reader) (*BreachedCorpus, error) {
+	corpus := &BreachedCorpus{ranges: map[string]map[string]int{}}
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, rawCount, found := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("line %d: invalid SHA-1 hash %q", lineNumber, hash)
+		}
+		count := 1
+		if found {
+			var err error
+			if count, err = strconv.Atoi(rawCount); err != nil || count < 0 {
+				return nil, fmt.Errorf("line %d: invalid count %q", lineNumber, rawCount)
+			}
+		}
+
+		prefix, suffix := hash[:rangePrefixLength], hash[rangePrefixLength:]
+		if corpus.ranges[prefix] == nil {
+			corpus.ranges[prefix] = map[string]int{}
+		}
+		corpus.ranges[prefix][suffix] += count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password corpus: %w", err)
+	}
+	return corpus, nil
+}
+
+// Range returns the hash suffixes and counts of every breached password whose
+// hash starts with the given five character prefix.
+func (c *BreachedCorpus) Range(prefix string) map[string]int {
+	return c.ranges[strings.ToUpper(prefix)]
+}
+
+// Count returns how many times the password was seen in breaches, or 0 if it
+// is not in the corpus.
+func (c *BreachedCorpus) Count(password string) int {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return c.Range(hash[:rangePrefixLength])[hash[rangePrefixLength:]]
+}
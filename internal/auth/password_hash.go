@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// ErrMismatchedPassword is returned when a password does not match its hash.
+var ErrMismatchedPassword = errors.New("password does not match the hash")
+
+// ErrUnknownHashFormat is returned when a stored hash is in no known format.
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// Argon2idParams are the tunable parameters of Argon2id.
+type Argon2idParams struct {
+	// Memory is the memory used by a single hash, in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation for Argon2id.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// DefaultBcryptCost is the bcrypt cost used unless configured otherwise.
+const DefaultBcryptCost = 12
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies passwords against hashes of any supported algorithm. Hashes are
+// self-describing: Argon2id hashes use the PHC string format
+// "$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>" and
+// bcrypt hashes their usual "$2a$<cost>$..." format, so hashes created with
+// older algorithms or parameters are recognised and can be upgraded with
+// NeedsRehash. A nil *PasswordHasher behaves like DefaultPasswordHasher.
+type PasswordHasher struct {
+	Algorithm  string
+	Argon2id   Argon2idParams
+	BcryptCost int
+}
+
+// DefaultPasswordHasher returns a hasher using Argon2id with the default
+// parameters.
+func DefaultPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:  AlgorithmArgon2id,
+		Argon2id:   DefaultArgon2idParams,
+		BcryptCost: DefaultBcryptCost,
+	}
+}
+
+// MaxPasswordBytes returns the length of the longest password the algorithm
+// hashes in full: 72 bytes for bcrypt, which ignores the rest, and 0, meaning no
+// limit, for Argon2id.
+func (h *PasswordHasher) MaxPasswordBytes() int {
+	if h == nil {
+		h = DefaultPasswordHasher()
+	}
+	if h.Algorithm == AlgorithmBcrypt {
+		return 72
+	}
+	return 0
+}
+
+// Hash hashes the password with the configured algorithm, recording the
+// deliberately slow hashing as a span that is a child of the span in the given
+// context.
+func (h *PasswordHasher) Hash(ctx context.Context, password string) (string, error) {
+	if h == nil {
+		h = DefaultPasswordHasher()
+	}
+	_, span := tracer.Start(ctx, "auth.HashPassword", trace.WithAttributes(
+		attribute.String("auth.algorithm", h.Algorithm),
+	))
+	defer span.End()
+
+	hash, err := h.hash(password)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	return hash, nil
+}
+
+func (h *PasswordHasher) hash(password string) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
+
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		p := h.Argon2id
+		salt := make([]byte, p.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	default:
+		return "", fmt.Errorf("unknown password hashing algorithm %q", h.Algorithm)
+	}
+}
+
+// Verify checks the password against a hash of any supported algorithm,
+// recording the comparison as a span that is a child of the span in the given
+// context. It returns ErrMismatchedPassword if the password does not match.
+func (h *PasswordHasher) Verify(ctx context.Context, password, hash string) error {
+	if h == nil {
+		h = DefaultPasswordHasher()
+	}
+	_, span := tracer.Start(ctx, "auth.CheckPasswordHash", trace.WithAttributes(
+		attribute.String("auth.algorithm", hashAlgorithm(hash)),
+	))
+	defer span.End()
+
+	switch hashAlgorithm(hash) {
+	case AlgorithmArgon2id:
+		params, salt, key, err := parseArgon2idHash(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrMismatchedPassword
+		}
+		return nil
+	case AlgorithmBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatchedPassword
+		}
+		return err
+	default:
+		return ErrUnknownHashFormat
+	}
+}
+
+// NeedsRehash reports whether the hash was created with another algorithm or
+// other parameters than the hasher's, and should be replaced by a new hash the
+// next time the password is known, e.g. after a successful login.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	if h == nil {
+		h = DefaultPasswordHasher()
+	}
+	if hashAlgorithm(hash) != h.Algorithm {
+		return true
+	}
+
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		params, _, _, err := parseArgon2idHash(hash)
+		return err != nil || params != h.Argon2id
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.BcryptCost
+	}
+	return true
+}
+
+// hashAlgorithm returns the algorithm a hash was created with, or "" if the
+// format is unknown.
+func hashAlgorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return AlgorithmBcrypt
+	}
+	return ""
+}
+
+// parseArgon2idHash decodes an Argon2id hash in the PHC string format.
+func parseArgon2idHash(hash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	// argon2.IDKey panics on zero iterations or parallelism
+	if params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
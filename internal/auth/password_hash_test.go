@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fastArgon2idParams keep the tests quick; they are not meant for production.
+var fastArgon2idParams = Argon2idParams{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher := &PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2id: fastArgon2idParams}
+	ctx := context.Background()
+
+	hash, err := hasher.Hash(ctx, "correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("Hash %q is not in the PHC string format", hash)
+	}
+
+	if err := hasher.Verify(ctx, "correct horse", hash); err != nil {
+		t.Fatalf("Failed to verify password: %v", err)
+	}
+	if err := hasher.Verify(ctx, "wrong horse", hash); !errors.Is(err, ErrMismatchedPassword) {
+		t.Fatalf("Verify with the wrong password returned %v, want ErrMismatchedPassword", err)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash is true for a hash with the hasher's own parameters")
+	}
+
+	stronger := &PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2id: fastArgon2idParams}
+	stronger.Argon2id.Iterations = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash is false for a hash with other parameters")
+	}
+}
+
+func TestPasswordHasherUpgradesBcrypt(t *testing.T) {
+	legacy := &PasswordHasher{Algorithm: AlgorithmBcrypt, BcryptCost: 4}
+	hasher := &PasswordHasher{Algorithm: AlgorithmArgon2id, Argon2id: fastArgon2idParams}
+	ctx := context.Background()
+
+	hash, err := legacy.Hash(ctx, "correct horse")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	if err := hasher.Verify(ctx, "correct horse", hash); err != nil {
+		t.Fatalf("Failed to verify bcrypt hash: %v", err)
+	}
+	if err := hasher.Verify(ctx, "wrong horse", hash); !errors.Is(err, ErrMismatchedPassword) {
+		t.Fatalf("Verify with the wrong password returned %v, want ErrMismatchedPassword", err)
+	}
+	if !hasher.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash is false for a bcrypt hash")
+	}
+	if legacy.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash is true for a bcrypt hash with the hasher's own cost")
+	}
+}
+
+func TestPasswordHasherUnknownFormat(t *testing.T) {
+	var hasher *PasswordHasher
+	ctx := context.Background()
+
+	zeroIterations := "$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	zeroParallelism := "$argon2id$v=19$m=19456,t=2,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$broken", zeroIterations, zeroParallelism} {
+		if err := hasher.Verify(ctx, "correct horse", hash); err == nil {
+			t.Errorf("Verify(%q) succeeded, want an error", hash)
+		}
+		if !hasher.NeedsRehash(hash) {
+			t.Errorf("NeedsRehash(%q) is false, want true", hash)
+		}
+	}
+}
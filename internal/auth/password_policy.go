@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordError explains why a password does not meet the PasswordPolicy. Code
+// is a stable identifier of the broken rule and Message a description that can
+// be shown to the user.
+type PasswordError struct {
+	Code    string
+	Message string
+}
+
+func (e *PasswordError) Error() string {
+	return "password " + e.Message
+}
+
+// Codes of the rules a password can break.
+const (
+	PasswordTooShort = "too_short"
+	PasswordTooLong  = "too_long"
+	PasswordBlocked  = "blocked"
+	PasswordBreached = "breached"
+)
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+	// MaxBytes guards the password hash against overly long input. Zero means
+	// no limit.
+	MaxBytes int
+	// Blocklist holds passwords that are rejected regardless of case.
+	Blocklist []string
+	// Breached, if set, rejects passwords that appear in the corpus at least
+	// BreachedMinCount times.
+	Breached         *BreachedCorpus
+	BreachedMinCount int
+}
+
+// DefaultPasswordPolicy returns the policy used unless configured otherwise: at
+// least 8 characters, at most 1024 bytes, and no password found in the bundled
+// breached password corpus. When passwords are hashed with bcrypt, MaxBytes
+// should be lowered to PasswordHasher.MaxPasswordBytes.
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:        8,
+		MaxBytes:         1024,
+		Blocklist:        []string{"chirpy", "chirpyred", "chirpy123"},
+		Breached:         DefaultBreachedCorpus(),
+		BreachedMinCount: 1,
+	}
+}
+
+// Check returns a *PasswordError if the password breaks the policy. Passwords
+// equal to any of the user inputs, such as the user's email address or its local
+// part, are rejected like blocklisted ones.
+func (p *PasswordPolicy) Check(password string, userInputs ...string) error {
+	if length := utf8.RuneCountInString(password); length < p.MinLength {
+		return &PasswordError{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("must be at least %d characters long", p.MinLength),
+		}
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return &PasswordError{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("must be at most %d bytes long", p.MaxBytes),
+		}
+	}
+
+	blocklist := append(append([]string{}, p.Blocklist...), expandUserInputs(userInputs)...)
+	for _, blocked := range blocklist {
+		if blocked != "" && strings.EqualFold(password, blocked) {
+			return &PasswordError{
+				Code:    PasswordBlocked,
+				Message: "is too easy to guess",
+			}
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Count(password) >= max(p.BreachedMinCount, 1) {
+		return &PasswordError{
+			Code:    PasswordBreached,
+			Message: "has appeared in a data breach and must not be used",
+		}
+	}
+	return nil
+}
+
+// expandUserInputs adds the local part of every email address to the inputs.
+func expandUserInputs(inputs []string) []string {
+	expanded := append([]string{}, inputs...)
+	for _, input := range inputs {
+		if local, _, found := strings.Cut(input, "@"); found {
+			expanded = append(expanded, local)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		wantCode string
+	}{
+		{name: "strong", password: "correct horse battery staple", email: "user@example.com"},
+		{name: "empty", password: "", wantCode: PasswordTooShort},
+		{name: "short", password: "Tr0ub4d", wantCode: PasswordTooShort},
+		{name: "too many bytes", password: strings.Repeat("é", 513), wantCode: PasswordTooLong},
+		{name: "blocklisted", password: "ChirpyRed", wantCode: PasswordBlocked},
+		{name: "email", password: "walter@example.com", email: "walter@example.com", wantCode: PasswordBlocked},
+		{name: "email local part", password: "heisenberg", email: "HEISENBERG@example.com", wantCode: PasswordBlocked},
+		{name: "breached", password: "password123", wantCode: PasswordBreached},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, tt.email)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("Expected the password to be accepted, got %v", err)
+				}
+				return
+			}
+
+			var passwordErr *PasswordError
+			if !errors.As(err, &passwordErr) {
+				t.Fatalf("Expected a *PasswordError, got %v", err)
+			}
+			if passwordErr.Code != tt.wantCode {
+				t.Errorf("Expected code %s, got %s", tt.wantCode, passwordErr.Code)
+			}
+		})
+	}
+}
+
+func TestBreachedCorpus(t *testing.T) {
+	// SHA-1 of "hunter2" and "letmein"
+	corpus, err := ReadBreachedCorpus(strings.NewReader(`# comment
+F3BBBD66A63D4BF1747940578EC3D0103530E21D:17
+b7a875fc1ea228b9061041b7cec4bd3c52ab3ce3:3
+`))
+	if err != nil {
+		t.Fatalf("Failed to read corpus: %v", err)
+	}
+
+	if got := corpus.Count("hunter2"); got != 17 {
+		t.Errorf("Expected hunter2 to be seen 17 times, got %d", got)
+	}
+	if got := corpus.Count("letmein"); got != 3 {
+		t.Errorf("Expected letmein to be seen 3 times, got %d", got)
+	}
+	if got := corpus.Count("correct horse battery staple"); got != 0 {
+		t.Errorf("Expected an unknown password not to be found, got %d", got)
+	}
+	if got := len(corpus.Range("F3BBB")); got != 1 {
+		t.Errorf("Expected one entry in range F3BBB, got %d", got)
+	}
+
+	if _, err := ReadBreachedCorpus(strings.NewReader("not a hash:1\n")); err == nil {
+		t.Error("ReadBreachedCorpus should reject invalid hashes")
+	}
+}
+
+func TestLoadBreachedCorpusLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte("F3BBBD66A63D4BF1747940578EC3D0103530E21D:17\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if corpus, err := LoadBreachedCorpus(path); err != nil || corpus.Count("hunter2") != 17 {
+		t.Fatalf("LoadBreachedCorpus = %v, want the corpus", err)
+	}
+
+	// A file over the limit is refused before it is read
+	if err := os.Truncate(path, MaxBreachedCorpusBytes+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedCorpus(path); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("Expected an oversized corpus to be refused, got %v", err)
+	}
+}
+
+func TestHashPasswordRejectsEmpty(t *testing.T) {
+	if _, err := HashPassword(""); !errors.Is(err, ErrEmptyPassword) {
+		t.Fatalf("Expected ErrEmptyPassword, got %v", err)
+	}
+}
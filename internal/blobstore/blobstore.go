@@ -0,0 +1,36 @@
+// Package blobstore stores binary objects, such as uploaded media, under string
+// keys. Objects are kept either on the local filesystem or in an S3 compatible
+// object store.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ErrNotFound is returned by Get when no object is stored under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores objects under keys of slash separated path elements, such
+// as "originals/<id>". Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores size bytes read from r under the key, replacing any object
+	// stored under it.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the object stored under the key, or ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under the key. Deleting a key that does
+	// not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// checkKey rejects keys that could escape the store, such as "../secret".
+func checkKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the server settings that are not part of api.ApiConfig. Both are
+// filled by a Loader from the same sources.
+type Config struct {
+	Port           string `env:"PORT" default:"8080" validate:"required"`
+	FilepathRoot   string `env:"FILEPATH_ROOT" default:"./app" validate:"required"`
+	DBURL          string `env:"DB_URL" secret:"true" validate:"required"`
+	TracesExporter string `env:"OTEL_TRACES_EXPORTER" default:"none" validate:"oneof=none stdout otlp"`
+
+	// Timeouts of the HTTP server. ShutdownTimeout bounds how long in-flight
+	// requests are given to finish after SIGINT or SIGTERM.
+	ReadTimeout       time.Duration `env:"READ_TIMEOUT" default:"15s"`
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT" default:"5s"`
+	WriteTimeout      time.Duration `env:"WRITE_TIMEOUT" default:"30s"`
+	IdleTimeout       time.Duration `env:"IDLE_TIMEOUT" default:"120s"`
+	ShutdownTimeout   time.Duration `env:"SHUTDOWN_TIMEOUT" default:"20s" validate:"min=1s"`
+
+	// The database is pinged at startup up to DBPingAttempts times, waiting
+	// DBPingBackoff after the first failure and twice as long after each next.
+	DBPingAttempts int           `env:"DB_PING_ATTEMPTS" default:"5" validate:"min=1"`
+	DBPingBackoff  time.Duration `env:"DB_PING_BACKOFF" default:"1s" validate:"min=1ms"`
+
+	// The password policy. Passwords found in the breached password corpus at
+	// least BreachedPasswordsMinCount times are rejected; the corpus bundled
+	// with Chirpy is used unless BreachedPasswordsFile is set.
+	PasswordMinLength         int      `env:"PASSWORD_MIN_LENGTH" default:"8"`
+	PasswordBlocklist         []string `env:"PASSWORD_BLOCKLIST"`
+	BreachedPasswordsCheck    bool     `env:"BREACHED_PASSWORDS_CHECK" default:"true"`
+	BreachedPasswordsFile     string   `env:"BREACHED_PASSWORDS_FILE"`
+	BreachedPasswordsMinCount int      `env:"BREACHED_PASSWORDS_MIN_COUNT" default:"1"`
+
+	// New passwords are hashed with PasswordHashAlgorithm. Hashes created with
+	// another algorithm or other parameters are upgraded on the next login.
+	PasswordHashAlgorithm string `env:"PASSWORD_HASH_ALGORITHM" default:"argon2id" validate:"oneof=argon2id bcrypt"`
+	Argon2Memory          int    `env:"ARGON2_MEMORY" default:"19456" validate:"min=1024,max=4194304"`
+	Argon2Iterations      int    `env:"ARGON2_ITERATIONS" default:"2" validate:"min=1,max=1000"`
+	Argon2Parallelism     int    `env:"ARGON2_PARALLELISM" default:"1" validate:"min=1,max=255"`
+	BcryptCost            int    `env:"BCRYPT_COST" default:"12"`
+
+	// Uploaded media are kept below MediaDir if MediaStore is "local", or in
+	// the S3 compatible bucket configured by the S3 settings if it is "s3".
+	// Thumbnails fit in a square of ThumbnailSize pixels.
+	MediaStore        string `env:"MEDIA_STORE" default:"local" validate:"oneof=local s3"`
+	MediaDir          string `env:"MEDIA_DIR" default:"./media"`
+	MediaMaxBytes     int64  `env:"MEDIA_MAX_BYTES" default:"10485760" validate:"min=1"`
+	ThumbnailSize     int    `env:"THUMBNAIL_SIZE" default:"320" validate:"min=1"`
+	S3Endpoint        string `env:"S3_ENDPOINT"`
+	S3Region          string `env:"S3_REGION" default:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY" secret:"true"`
+
+	// The real-time stream keeps the last StreamReplaySize events for clients
+	// resuming with Last-Event-ID, and disconnects clients that fall more than
+	// StreamQueueSize events behind.
+	StreamHeartbeatInterval time.Duration `env:"STREAM_HEARTBEAT_INTERVAL" default:"15s"`
+	StreamReplaySize        int           `env:"STREAM_REPLAY_SIZE" default:"1000"`
+	StreamQueueSize         int           `env:"STREAM_QUEUE_SIZE" default:"64"`
+
+	// The events of the real-time APIs only reach the clients of the instance
+	// they happen on if EventBus is "memory", or those of every instance
+	// connected to the database through LISTEN/NOTIFY if it is "postgres".
+	EventBus string `env:"EVENT_BUS" default:"memory" validate:"oneof=memory postgres"`
+}
+
+// Sources of a setting, in increasing order of precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnvFile = "env file"
+	SourceEnv     = "env"
+)
+
+// Loader fills structs from their `env` struct tags. A setting is read from, in
+// increasing order of precedence, the `default` tag, the optional YAML config
+// file, the .env files and the process environment. In the YAML file the
+// setting's key is its environment variable name in lower case, e.g.
+// "token_secret" for TOKEN_SECRET.
+//
+// Fields can also be tagged with `secret:"true"` to be redacted by Print, and
+// with `validate` rules, separated by commas:
+//
+//	required     the value must not be empty
+//	min=<n>      an integer must be at least n, a non-empty string at least
+//	             n bytes long
+//	max=<n>      an integer must be at most n, a string at most n bytes long
+//	oneof=<a b>  the value must be one of the space separated values
+//
+// On a time.Duration field the bound of min and max is a duration, e.g.
+// `min=1ms`.
+type Loader struct {
+	values  map[string]string
+	sources map[string]string
+	loaded  []any
+}
+
+// NewLoader reads the optional YAML config file and the .env files. A missing
+// .env file is ignored, but a config file that was explicitly given must exist.
+func NewLoader(configFile string, envFiles ...string) (*Loader, error) {
+	l := &Loader{
+		values:  map[string]string{},
+		sources: map[string]string{},
+	}
+
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		var fileValues map[string]any
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+		}
+		for key, value := range fileValues {
+			l.set(strings.ToUpper(key), formatValue(value), SourceFile)
+		}
+	}
+
+	for _, envFile := range envFiles {
+		envValues, err := godotenv.Read(envFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file %s: %w", envFile, err)
+		}
+		for key, value := range envValues {
+			l.set(key, value, SourceEnvFile)
+		}
+	}
+
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		l.set(key, value, SourceEnv)
+	}
+
+	return l, nil
+}
+
+func (l *Loader) set(key, value, source string) {
+	l.values[key] = value
+	l.sources[key] = source
+}
+
+// formatValue turns a value decoded from YAML into the string form used in the
+// environment. Lists are joined with commas.
+func formatValue(value any) string {
+	if list, ok := value.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// Load fills the tagged fields of the struct dst points to and validates them.
+// All invalid settings are reported together.
+func (l *Loader) Load(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected a pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+
+	var errs []error
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		name, ok := field.Tag.Lookup("env")
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		raw, found := l.values[name]
+		if !found {
+			raw = field.Tag.Get("default")
+		}
+
+		if err := setField(v.FieldByIndex(field.Index), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if err := validate(v.FieldByIndex(field.Index), raw, field.Tag.Get("validate")); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	l.loaded = append(l.loaded, dst)
+	return errors.Join(errs...)
+}
+
+// setField parses raw into the field according to the field's type.
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		if raw == "" {
+			field.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		if raw == "" {
+			field.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if raw == "" {
+			field.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// validate checks a field set from raw against the comma separated rules of a
+// validate tag. min and max bound the value of integers and the length of
+// other settings.
+func validate(field reflect.Value, raw, rules string) error {
+	if rules == "" {
+		return nil
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			if raw == "" {
+				return errors.New("is required")
+			}
+		case "min", "max":
+			if field.Type() == reflect.TypeOf(time.Duration(0)) {
+				bound, err := time.ParseDuration(arg)
+				if err != nil {
+					return fmt.Errorf("invalid %s rule %q", name, rule)
+				}
+				d := time.Duration(field.Int())
+				if name == "min" && d < bound {
+					return fmt.Errorf("must be at least %v, got %v", bound, d)
+				}
+				if name == "max" && d > bound {
+					return fmt.Errorf("must be at most %v, got %v", bound, d)
+				}
+				continue
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid %s rule %q", name, rule)
+			}
+			switch field.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				if name == "min" && field.Int() < int64(n) {
+					return fmt.Errorf("must be at least %d, got %d", n, field.Int())
+				}
+				if name == "max" && field.Int() > int64(n) {
+					return fmt.Errorf("must be at most %d, got %d", n, field.Int())
+				}
+			default:
+				if name == "min" && raw != "" && len(raw) < n {
+					return fmt.Errorf("must be at least %d characters long", n)
+				}
+				if name == "max" && len(raw) > n {
+					return fmt.Errorf("must be at most %d characters long", n)
+				}
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			found := false
+			for _, value := range allowed {
+				if raw == value {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("must be one of %s, got %q", strings.Join(allowed, ", "), raw)
+			}
+		default:
+			return fmt.Errorf("unknown validation rule %q", rule)
+		}
+	}
+	return nil
+}
+
+// Setting is a single effective setting as reported by Settings.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// Settings returns the effective value and source of every setting of the
+// structs loaded so far, sorted by name. The values of secret settings are
+// redacted.
+func (l *Loader) Settings() []Setting {
+	var settings []Setting
+	for _, dst := range l.loaded {
+		v := reflect.ValueOf(dst).Elem()
+		for _, field := range reflect.VisibleFields(v.Type()) {
+			name, ok := field.Tag.Lookup("env")
+			if !ok || !field.IsExported() {
+				continue
+			}
+
+			value := fmt.Sprint(v.FieldByIndex(field.Index).Interface())
+			if slice, ok := v.FieldByIndex(field.Index).Interface().([]string); ok {
+				value = strings.Join(slice, ",")
+			}
+			if field.Tag.Get("secret") == "true" && value != "" {
+				value = "[REDACTED]"
+			}
+
+			source, found := l.sources[name]
+			if !found {
+				source = SourceDefault
+			}
+			settings = append(settings, Setting{Name: name, Value: value, Source: source})
+		}
+	}
+
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings
+}
+
+// Print writes the effective settings to w, one per line, with secrets redacted.
+func (l *Loader) Print(w io.Writer) {
+	for _, setting := range l.Settings() {
+		fmt.Fprintf(w, "%s=%s (%s)\n", setting.Name, setting.Value, setting.Source)
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Port    string        `env:"CHIRPY_TEST_PORT" default:"8080"`
+	Secret  string        `env:"CHIRPY_TEST_SECRET" secret:"true" validate:"required,min=8"`
+	Mode    string        `env:"CHIRPY_TEST_MODE" default:"dev" validate:"oneof=dev prod"`
+	Timeout time.Duration `env:"CHIRPY_TEST_TIMEOUT" default:"5s"`
+	Debug   bool          `env:"CHIRPY_TEST_DEBUG"`
+	Origins []string      `env:"CHIRPY_TEST_ORIGINS"`
+	Workers int           `env:"CHIRPY_TEST_WORKERS" default:"1" validate:"min=1,max=255"`
+	Backoff time.Duration `env:"CHIRPY_TEST_BACKOFF" default:"1s" validate:"min=1ms,max=1m"`
+	ignored string
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "chirpy.yaml")
+	envFile := filepath.Join(dir, ".env")
+	os.WriteFile(configFile, []byte("chirpy_test_port: 9000\nchirpy_test_mode: prod\nchirpy_test_origins: [a.example, b.example]\n"), 0o600)
+	os.WriteFile(envFile, []byte("CHIRPY_TEST_PORT=9001\nCHIRPY_TEST_SECRET=fromEnvFile\n"), 0o600)
+	t.Setenv("CHIRPY_TEST_SECRET", "fromEnvironment")
+
+	loader, err := NewLoader(configFile, envFile, filepath.Join(dir, "missing.env"))
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+
+	var cfg testConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Port != "9001" {
+		t.Errorf("Expected the .env file to override the config file, got port %q", cfg.Port)
+	}
+	if cfg.Secret != "fromEnvironment" {
+		t.Errorf("Expected the environment to override the .env file, got secret %q", cfg.Secret)
+	}
+	if cfg.Mode != "prod" {
+		t.Errorf("Expected mode from the config file, got %q", cfg.Mode)
+	}
+	if cfg.Timeout != 5*time.Second {
+		t.Errorf("Expected the default timeout, got %v", cfg.Timeout)
+	}
+	if len(cfg.Origins) != 2 || cfg.Origins[1] != "b.example" {
+		t.Errorf("Expected origins from the config file, got %v", cfg.Origins)
+	}
+	if cfg.Workers != 1 {
+		t.Errorf("Expected the default workers, got %d", cfg.Workers)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	t.Setenv("CHIRPY_TEST_SECRET", "short")
+	t.Setenv("CHIRPY_TEST_MODE", "staging")
+	t.Setenv("CHIRPY_TEST_TIMEOUT", "soon")
+	t.Setenv("CHIRPY_TEST_WORKERS", "256")
+	t.Setenv("CHIRPY_TEST_BACKOFF", "0s")
+
+	loader, err := NewLoader("")
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+
+	var cfg testConfig
+	err = loader.Load(&cfg)
+	if err == nil {
+		t.Fatal("Load should have returned an error")
+	}
+	for _, name := range []string{"CHIRPY_TEST_SECRET", "CHIRPY_TEST_MODE", "CHIRPY_TEST_TIMEOUT", "CHIRPY_TEST_WORKERS", "CHIRPY_TEST_BACKOFF"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected the error to mention %s, got %v", name, err)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("CHIRPY_TEST_SECRET", "superSecretValue")
+
+	loader, err := NewLoader("")
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+	var cfg testConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	var out strings.Builder
+	loader.Print(&out)
+	if strings.Contains(out.String(), "superSecretValue") {
+		t.Fatal("Print should not reveal secrets")
+	}
+	if !strings.Contains(out.String(), "CHIRPY_TEST_SECRET=[REDACTED] (env)") {
+		t.Fatalf("Expected a redacted secret in the output, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "CHIRPY_TEST_PORT=8080 (default)") {
+		t.Fatalf("Expected the default port in the output, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,322 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: blocks.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const anyBlockedAmong = `-- name: AnyBlockedAmong :one
+SELECT EXISTS (
+    SELECT 1
+    FROM blocks
+    WHERE blocker_id = ANY($1::UUID[])
+      AND blocked_id = ANY($1::UUID[])
+)
+`
+
+// Reports whether any of the users blocked another one of them.
+func (q *Queries) AnyBlockedAmong(ctx context.Context, userIds []uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, anyBlockedAmong, pq.Array(userIds))
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const blockUser = `-- name: BlockUser :execrows
+WITH unfollowed AS (
+    DELETE FROM follows
+    WHERE (follower_id = $1 AND followee_id = $2)
+       OR (follower_id = $2 AND followee_id = $1)
+), unrequested AS (
+    DELETE FROM follow_requests
+    WHERE (requester_id = $1 AND target_id = $2)
+       OR (requester_id = $2 AND target_id = $1)
+)
+INSERT INTO blocks (blocker_id, blocked_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+`
+
+type BlockUserParams struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+}
+
+// Blocks a user and ends the follows and follow requests between the two
+// users.
+func (q *Queries) BlockUser(ctx context.Context, arg BlockUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, blockUser, arg.BlockerID, arg.BlockedID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const filterMentionable = `-- name: FilterMentionable :many
+SELECT users.id
+FROM users
+WHERE users.id = ANY($1::UUID[])
+  AND NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = users.id AND hidden_users.hidden_id = $2
+  )
+  AND ($3::TEXT = 'mentioned'
+    OR ($3::TEXT <> 'followers'
+      AND NOT EXISTS (SELECT 1 FROM users AS authors WHERE authors.id = $2 AND authors.is_protected))
+    OR EXISTS (
+      SELECT 1 FROM follows
+      WHERE follows.follower_id = users.id AND follows.followee_id = $2
+    ))
+`
+
+type FilterMentionableParams struct {
+	UserIds    []uuid.UUID
+	AuthorID   uuid.UUID
+	Visibility string
+}
+
+// Returns the users among user_ids who may learn that the author mentioned
+// them in a chirp with the visibility: the ones the author is not hidden from
+// and, for followers-only chirps or protected authors, who follow the author.
+// Chirps for mentioned users only reach every one of them.
+func (q *Queries) FilterMentionable(ctx context.Context, arg FilterMentionableParams) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, filterMentionable, pq.Array(arg.UserIds), arg.AuthorID, arg.Visibility)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isBlockedWithAny = `-- name: IsBlockedWithAny :one
+SELECT EXISTS (
+    SELECT 1
+    FROM blocks
+    WHERE (blocker_id = $1 AND blocked_id = ANY($2::UUID[]))
+       OR (blocked_id = $1 AND blocker_id = ANY($2::UUID[]))
+)
+`
+
+type IsBlockedWithAnyParams struct {
+	UserID   uuid.UUID
+	OtherIds []uuid.UUID
+}
+
+// Reports whether the user blocked, or was blocked by, any of the others.
+func (q *Queries) IsBlockedWithAny(ctx context.Context, arg IsBlockedWithAnyParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isBlockedWithAny, arg.UserID, pq.Array(arg.OtherIds))
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listBlockedUsers = `-- name: ListBlockedUsers :many
+SELECT users.id, users.username, users.display_name, blocks.created_at
+FROM blocks
+JOIN users ON users.id = blocks.blocked_id
+WHERE blocks.blocker_id = $1
+ORDER BY blocks.created_at DESC, users.id
+`
+
+type ListBlockedUsersRow struct {
+	ID          uuid.UUID
+	Username    sql.NullString
+	DisplayName string
+	CreatedAt   time.Time
+}
+
+func (q *Queries) ListBlockedUsers(ctx context.Context, userID uuid.UUID) ([]ListBlockedUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listBlockedUsers, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListBlockedUsersRow
+	for rows.Next() {
+		var i ListBlockedUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.DisplayName,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHiddenUsers = `-- name: ListHiddenUsers :many
+SELECT blocks.blocked_id AS hidden_id, 'block'::TEXT AS reason
+FROM blocks
+WHERE blocks.blocker_id = $1
+UNION ALL
+SELECT blocks.blocker_id AS hidden_id, 'blocked_by'::TEXT AS reason
+FROM blocks
+WHERE blocks.blocked_id = $1
+UNION ALL
+SELECT mutes.muted_id AS hidden_id, 'mute'::TEXT AS reason
+FROM mutes
+WHERE mutes.muter_id = $1
+`
+
+type ListHiddenUsersRow struct {
+	HiddenID uuid.UUID
+	Reason   string
+}
+
+// Returns the users hidden from the user and why: 'block' for the users they
+// blocked, 'blocked_by' for the users who blocked them and 'mute' for the users
+// they muted.
+func (q *Queries) ListHiddenUsers(ctx context.Context, userID uuid.UUID) ([]ListHiddenUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listHiddenUsers, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListHiddenUsersRow
+	for rows.Next() {
+		var i ListHiddenUsersRow
+		if err := rows.Scan(&i.HiddenID, &i.Reason); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMutedUsers = `-- name: ListMutedUsers :many
+SELECT users.id, users.username, users.display_name, mutes.created_at
+FROM mutes
+JOIN users ON users.id = mutes.muted_id
+WHERE mutes.muter_id = $1
+ORDER BY mutes.created_at DESC, users.id
+`
+
+type ListMutedUsersRow struct {
+	ID          uuid.UUID
+	Username    sql.NullString
+	DisplayName string
+	CreatedAt   time.Time
+}
+
+func (q *Queries) ListMutedUsers(ctx context.Context, muterID uuid.UUID) ([]ListMutedUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMutedUsers, muterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMutedUsersRow
+	for rows.Next() {
+		var i ListMutedUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.DisplayName,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const muteUser = `-- name: MuteUser :execrows
+INSERT INTO mutes (muter_id, muted_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (muter_id, muted_id) DO NOTHING
+`
+
+type MuteUserParams struct {
+	MuterID uuid.UUID
+	MutedID uuid.UUID
+}
+
+func (q *Queries) MuteUser(ctx context.Context, arg MuteUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, muteUser, arg.MuterID, arg.MutedID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unblockUser = `-- name: UnblockUser :execrows
+DELETE FROM blocks
+WHERE blocker_id = $1
+  AND blocked_id = $2
+`
+
+type UnblockUserParams struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+}
+
+func (q *Queries) UnblockUser(ctx context.Context, arg UnblockUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unblockUser, arg.BlockerID, arg.BlockedID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unmuteUser = `-- name: UnmuteUser :execrows
+DELETE FROM mutes
+WHERE muter_id = $1
+  AND muted_id = $2
+`
+
+type UnmuteUserParams struct {
+	MuterID uuid.UUID
+	MutedID uuid.UUID
+}
+
+func (q *Queries) UnmuteUser(ctx context.Context, arg UnmuteUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unmuteUser, arg.MuterID, arg.MutedID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -7,29 +7,46 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body           string
+	UserID         uuid.UUID
+	ReplyToID      uuid.NullUUID
+	Visibility     string
+	ContentWarning string
+	Sensitive      bool
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ReplyToID,
+		arg.Visibility,
+		arg.ContentWarning,
+		arg.Sensitive,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,10 +54,30 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ReplyToID,
+		&i.Visibility,
+		&i.ContentWarning,
+		&i.Sensitive,
 	)
 	return i, err
 }
 
+const createChirpMentions = `-- name: CreateChirpMentions :exec
+INSERT INTO chirp_mentions (chirp_id, user_id)
+SELECT $1, unnest($2::UUID[])
+ON CONFLICT (chirp_id, user_id) DO NOTHING
+`
+
+type CreateChirpMentionsParams struct {
+	ChirpID uuid.UUID
+	UserIds []uuid.UUID
+}
+
+func (q *Queries) CreateChirpMentions(ctx context.Context, arg CreateChirpMentionsParams) error {
+	_, err := q.db.ExecContext(ctx, createChirpMentions, arg.ChirpID, pq.Array(arg.UserIds))
+	return err
+}
+
 const deleteAllChirps = `-- name: DeleteAllChirps :exec
 DELETE FROM chirps
 `
@@ -61,13 +98,28 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
 FROM chirps
-ORDER BY created_at ASC
+WHERE NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = $1 AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, $1)
+  AND (chirps.visibility <> 'unlisted' OR chirps.user_id = $1)
+ORDER BY created_at ASC, id ASC
+LIMIT $3 OFFSET $2
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+type GetAllChirpsParams struct {
+	ViewerID   uuid.NullUUID
+	Skip       int32
+	MaxResults sql.NullInt32
+}
+
+// Leaves out the chirps viewer_id, if set, may not see and those of the users
+// hidden from them. Unlisted chirps are only listed for their author.
+func (q *Queries) GetAllChirps(ctx context.Context, arg GetAllChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirps, arg.ViewerID, arg.Skip, arg.MaxResults)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +133,10 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyToID,
+			&i.Visibility,
+			&i.ContentWarning,
+			&i.Sensitive,
 		); err != nil {
 			return nil, err
 		}
@@ -96,13 +152,28 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getAllChirpsDESC = `-- name: GetAllChirpsDESC :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
 FROM chirps
-ORDER BY created_at DESC
+WHERE NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = $1 AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, $1)
+  AND (chirps.visibility <> 'unlisted' OR chirps.user_id = $1)
+ORDER BY created_at DESC, id DESC
+LIMIT $3 OFFSET $2
 `
 
-func (q *Queries) GetAllChirpsDESC(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsDESC)
+type GetAllChirpsDESCParams struct {
+	ViewerID   uuid.NullUUID
+	Skip       int32
+	MaxResults sql.NullInt32
+}
+
+// Leaves out the chirps viewer_id, if set, may not see and those of the users
+// hidden from them. Unlisted chirps are only listed for their author.
+func (q *Queries) GetAllChirpsDESC(ctx context.Context, arg GetAllChirpsDESCParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirpsDESC, arg.ViewerID, arg.Skip, arg.MaxResults)
 	if err != nil {
 		return nil, err
 	}
@@ -116,6 +187,10 @@ func (q *Queries) GetAllChirpsDESC(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyToID,
+			&i.Visibility,
+			&i.ContentWarning,
+			&i.Sensitive,
 		); err != nil {
 			return nil, err
 		}
@@ -131,7 +206,7 @@ func (q *Queries) GetAllChirpsDESC(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
 FROM chirps
 WHERE id = $1
 `
@@ -145,19 +220,43 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ReplyToID,
+		&i.Visibility,
+		&i.ContentWarning,
+		&i.Sensitive,
 	)
 	return i, err
 }
 
 const getUserChirps = `-- name: GetUserChirps :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
 FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC
+WHERE chirps.user_id = $1
+  AND NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = $2 AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, $2)
+ORDER BY created_at ASC, id ASC
+LIMIT $4 OFFSET $3
 `
 
-func (q *Queries) GetUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getUserChirps, userID)
+type GetUserChirpsParams struct {
+	UserID     uuid.UUID
+	ViewerID   uuid.NullUUID
+	Skip       int32
+	MaxResults sql.NullInt32
+}
+
+// Leaves out the chirps viewer_id, if set, may not see and those of the users
+// hidden from them.
+func (q *Queries) GetUserChirps(ctx context.Context, arg GetUserChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getUserChirps,
+		arg.UserID,
+		arg.ViewerID,
+		arg.Skip,
+		arg.MaxResults,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -171,6 +270,10 @@ func (q *Queries) GetUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyToID,
+			&i.Visibility,
+			&i.ContentWarning,
+			&i.Sensitive,
 		); err != nil {
 			return nil, err
 		}
@@ -186,14 +289,34 @@ func (q *Queries) GetUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp,
 }
 
 const getUserChirpsDESC = `-- name: GetUserChirpsDESC :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
 FROM chirps
-WHERE user_id = $1
-ORDER BY created_at DESC
+WHERE chirps.user_id = $1
+  AND NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = $2 AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, $2)
+ORDER BY created_at DESC, id DESC
+LIMIT $4 OFFSET $3
 `
 
-func (q *Queries) GetUserChirpsDESC(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getUserChirpsDESC, userID)
+type GetUserChirpsDESCParams struct {
+	UserID     uuid.UUID
+	ViewerID   uuid.NullUUID
+	Skip       int32
+	MaxResults sql.NullInt32
+}
+
+// Leaves out the chirps viewer_id, if set, may not see and those of the users
+// hidden from them.
+func (q *Queries) GetUserChirpsDESC(ctx context.Context, arg GetUserChirpsDESCParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getUserChirpsDESC,
+		arg.UserID,
+		arg.ViewerID,
+		arg.Skip,
+		arg.MaxResults,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -207,6 +330,10 @@ func (q *Queries) GetUserChirpsDESC(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyToID,
+			&i.Visibility,
+			&i.ContentWarning,
+			&i.Sensitive,
 		); err != nil {
 			return nil, err
 		}
@@ -220,3 +347,61 @@ func (q *Queries) GetUserChirpsDESC(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const getVisibleChirp = `-- name: GetVisibleChirp :one
+SELECT id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive
+FROM chirps
+WHERE chirps.id = $1
+  AND chirp_visible_to(chirps.id, $2)
+`
+
+type GetVisibleChirpParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+// Returns the chirp only if viewer_id, if set, may see it.
+func (q *Queries) GetVisibleChirp(ctx context.Context, arg GetVisibleChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getVisibleChirp, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyToID,
+		&i.Visibility,
+		&i.ContentWarning,
+		&i.Sensitive,
+	)
+	return i, err
+}
+
+const listChirpMentions = `-- name: ListChirpMentions :many
+SELECT user_id FROM chirp_mentions
+WHERE chirp_id = $1
+`
+
+func (q *Queries) ListChirpMentions(ctx context.Context, chirpID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpMentions, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
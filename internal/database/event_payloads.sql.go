@@ -0,0 +1,79 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: event_payloads.sql
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+const createEventPayload = `-- name: CreateEventPayload :exec
+INSERT INTO event_payloads (id, created_at, payload)
+VALUES ($1, NOW(), $2)
+`
+
+type CreateEventPayloadParams struct {
+	ID      uuid.UUID
+	Payload json.RawMessage
+}
+
+func (q *Queries) CreateEventPayload(ctx context.Context, arg CreateEventPayloadParams) error {
+	_, err := q.db.ExecContext(ctx, createEventPayload, arg.ID, arg.Payload)
+	return err
+}
+
+const deleteExpiredEventPayloads = `-- name: DeleteExpiredEventPayloads :execrows
+DELETE FROM event_payloads
+WHERE created_at < NOW() - INTERVAL '1 hour'
+`
+
+func (q *Queries) DeleteExpiredEventPayloads(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredEventPayloads)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getEventPayload = `-- name: GetEventPayload :one
+SELECT payload
+FROM event_payloads
+WHERE id = $1
+`
+
+func (q *Queries) GetEventPayload(ctx context.Context, id uuid.UUID) (json.RawMessage, error) {
+	row := q.db.QueryRowContext(ctx, getEventPayload, id)
+	var payload json.RawMessage
+	err := row.Scan(&payload)
+	return payload, err
+}
+
+const nextEventID = `-- name: NextEventID :one
+SELECT nextval('stream_event_ids')::BIGINT AS id
+`
+
+func (q *Queries) NextEventID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, nextEventID)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const notifyEvent = `-- name: NotifyEvent :exec
+SELECT pg_notify($1::TEXT, $2::TEXT)
+`
+
+type NotifyEventParams struct {
+	Channel string
+	Payload string
+}
+
+func (q *Queries) NotifyEvent(ctx context.Context, arg NotifyEventParams) error {
+	_, err := q.db.ExecContext(ctx, notifyEvent, arg.Channel, arg.Payload)
+	return err
+}
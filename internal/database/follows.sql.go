@@ -0,0 +1,277 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: follows.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const anyProtectedNotFollowed = `-- name: AnyProtectedNotFollowed :one
+SELECT EXISTS (
+    SELECT 1
+    FROM users
+    WHERE users.id = ANY($1::UUID[])
+      AND users.is_protected
+      AND NOT EXISTS (
+        SELECT 1 FROM follows
+        WHERE follows.follower_id = $2 AND follows.followee_id = users.id
+      )
+)
+`
+
+type AnyProtectedNotFollowedParams struct {
+	OtherIds []uuid.UUID
+	UserID   uuid.UUID
+}
+
+// Reports whether any of the others is protected and not followed by the user.
+func (q *Queries) AnyProtectedNotFollowed(ctx context.Context, arg AnyProtectedNotFollowedParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, anyProtectedNotFollowed, pq.Array(arg.OtherIds), arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const approveAllFollowRequests = `-- name: ApproveAllFollowRequests :many
+WITH requests AS (
+    DELETE FROM follow_requests
+    WHERE target_id = $1
+    RETURNING requester_id, target_id
+)
+INSERT INTO follows (follower_id, followee_id, created_at)
+SELECT requester_id, target_id, NOW()
+FROM requests
+ON CONFLICT (follower_id, followee_id) DO NOTHING
+RETURNING follower_id
+`
+
+// Turns every request to follow the user into a follow, and returns the IDs
+// of the new followers.
+func (q *Queries) ApproveAllFollowRequests(ctx context.Context, targetID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, approveAllFollowRequests, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var follower_id uuid.UUID
+		if err := rows.Scan(&follower_id); err != nil {
+			return nil, err
+		}
+		items = append(items, follower_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const approveFollowRequest = `-- name: ApproveFollowRequest :execrows
+WITH request AS (
+    DELETE FROM follow_requests
+    WHERE requester_id = $1
+      AND target_id = $2
+    RETURNING requester_id, target_id
+)
+INSERT INTO follows (follower_id, followee_id, created_at)
+SELECT requester_id, target_id, NOW()
+FROM request
+ON CONFLICT (follower_id, followee_id) DO NOTHING
+`
+
+type ApproveFollowRequestParams struct {
+	RequesterID uuid.UUID
+	TargetID    uuid.UUID
+}
+
+// Turns the request into a follow.
+func (q *Queries) ApproveFollowRequest(ctx context.Context, arg ApproveFollowRequestParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, approveFollowRequest, arg.RequesterID, arg.TargetID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createFollowRequest = `-- name: CreateFollowRequest :execrows
+INSERT INTO follow_requests (requester_id, target_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (requester_id, target_id) DO NOTHING
+`
+
+type CreateFollowRequestParams struct {
+	RequesterID uuid.UUID
+	TargetID    uuid.UUID
+}
+
+func (q *Queries) CreateFollowRequest(ctx context.Context, arg CreateFollowRequestParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createFollowRequest, arg.RequesterID, arg.TargetID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteFollowRequest = `-- name: DeleteFollowRequest :execrows
+DELETE FROM follow_requests
+WHERE requester_id = $1
+  AND target_id = $2
+`
+
+type DeleteFollowRequestParams struct {
+	RequesterID uuid.UUID
+	TargetID    uuid.UUID
+}
+
+func (q *Queries) DeleteFollowRequest(ctx context.Context, arg DeleteFollowRequestParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFollowRequest, arg.RequesterID, arg.TargetID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const followUser = `-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (follower_id, followee_id) DO NOTHING
+`
+
+type FollowUserParams struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+}
+
+func (q *Queries) FollowUser(ctx context.Context, arg FollowUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, followUser, arg.FollowerID, arg.FolloweeID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const isFollowing = `-- name: IsFollowing :one
+SELECT EXISTS (
+    SELECT 1
+    FROM follows
+    WHERE follower_id = $1
+      AND followee_id = $2
+)
+`
+
+type IsFollowingParams struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+}
+
+func (q *Queries) IsFollowing(ctx context.Context, arg IsFollowingParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isFollowing, arg.FollowerID, arg.FolloweeID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listFollowRequests = `-- name: ListFollowRequests :many
+SELECT users.id, users.username, users.display_name, follow_requests.created_at
+FROM follow_requests
+JOIN users ON users.id = follow_requests.requester_id
+WHERE follow_requests.target_id = $1
+ORDER BY follow_requests.created_at DESC, users.id
+`
+
+type ListFollowRequestsRow struct {
+	ID          uuid.UUID
+	Username    sql.NullString
+	DisplayName string
+	CreatedAt   time.Time
+}
+
+func (q *Queries) ListFollowRequests(ctx context.Context, targetID uuid.UUID) ([]ListFollowRequestsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFollowRequests, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFollowRequestsRow
+	for rows.Next() {
+		var i ListFollowRequestsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.DisplayName,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFolloweeIDs = `-- name: ListFolloweeIDs :many
+SELECT followee_id
+FROM follows
+WHERE follower_id = $1
+`
+
+// Returns the users the user follows, including the ones they muted, which
+// ListHiddenUsers returns.
+func (q *Queries) ListFolloweeIDs(ctx context.Context, followerID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listFolloweeIDs, followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var followee_id uuid.UUID
+		if err := rows.Scan(&followee_id); err != nil {
+			return nil, err
+		}
+		items = append(items, followee_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const unfollowUser = `-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1
+  AND followee_id = $2
+`
+
+type UnfollowUserParams struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+}
+
+func (q *Queries) UnfollowUser(ctx context.Context, arg UnfollowUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unfollowUser, arg.FollowerID, arg.FolloweeID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,295 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: media.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const attachMedia = `-- name: AttachMedia :execrows
+UPDATE media
+SET chirp_id = $1
+WHERE id = ANY($2::UUID[])
+  AND user_id = $3
+  AND chirp_id IS NULL
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id)
+`
+
+type AttachMediaParams struct {
+	ChirpID uuid.NullUUID
+	Ids     []uuid.UUID
+	UserID  uuid.UUID
+}
+
+func (q *Queries) AttachMedia(ctx context.Context, arg AttachMediaParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, attachMedia, arg.ChirpID, pq.Array(arg.Ids), arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countAttachableMedia = `-- name: CountAttachableMedia :one
+SELECT COUNT(*)
+FROM media
+WHERE id = ANY($1::UUID[])
+  AND user_id = $2
+  AND chirp_id IS NULL
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id)
+`
+
+type CountAttachableMediaParams struct {
+	Ids    []uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) CountAttachableMedia(ctx context.Context, arg CountAttachableMediaParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAttachableMedia, pq.Array(arg.Ids), arg.UserID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createMedia = `-- name: CreateMedia :one
+INSERT INTO media (id, created_at, user_id, content_type, size, width, height, storage_key, thumbnail_content_type, thumbnail_key)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+)
+RETURNING id, created_at, user_id, chirp_id, content_type, size, width, height, storage_key, thumbnail_content_type, thumbnail_key
+`
+
+type CreateMediaParams struct {
+	ID                   uuid.UUID
+	UserID               uuid.UUID
+	ContentType          string
+	Size                 int64
+	Width                int32
+	Height               int32
+	StorageKey           string
+	ThumbnailContentType string
+	ThumbnailKey         string
+}
+
+func (q *Queries) CreateMedia(ctx context.Context, arg CreateMediaParams) (Media, error) {
+	row := q.db.QueryRowContext(ctx, createMedia,
+		arg.ID,
+		arg.UserID,
+		arg.ContentType,
+		arg.Size,
+		arg.Width,
+		arg.Height,
+		arg.StorageKey,
+		arg.ThumbnailContentType,
+		arg.ThumbnailKey,
+	)
+	var i Media
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.ChirpID,
+		&i.ContentType,
+		&i.Size,
+		&i.Width,
+		&i.Height,
+		&i.StorageKey,
+		&i.ThumbnailContentType,
+		&i.ThumbnailKey,
+	)
+	return i, err
+}
+
+const deleteMediaBlobDeletions = `-- name: DeleteMediaBlobDeletions :exec
+DELETE FROM media_blob_deletions
+WHERE storage_key = ANY($1::TEXT[])
+`
+
+func (q *Queries) DeleteMediaBlobDeletions(ctx context.Context, storageKeys []string) error {
+	_, err := q.db.ExecContext(ctx, deleteMediaBlobDeletions, pq.Array(storageKeys))
+	return err
+}
+
+const deleteUnattachedMedia = `-- name: DeleteUnattachedMedia :execrows
+DELETE FROM media
+WHERE chirp_id IS NULL
+  AND created_at < NOW() - $1::int * INTERVAL '1 second'
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id)
+`
+
+func (q *Queries) DeleteUnattachedMedia(ctx context.Context, maxAgeSeconds int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUnattachedMedia, maxAgeSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getMedia = `-- name: GetMedia :one
+SELECT id, created_at, user_id, chirp_id, content_type, size, width, height, storage_key, thumbnail_content_type, thumbnail_key
+FROM media
+WHERE id = $1
+`
+
+func (q *Queries) GetMedia(ctx context.Context, id uuid.UUID) (Media, error) {
+	row := q.db.QueryRowContext(ctx, getMedia, id)
+	var i Media
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.ChirpID,
+		&i.ContentType,
+		&i.Size,
+		&i.Width,
+		&i.Height,
+		&i.StorageKey,
+		&i.ThumbnailContentType,
+		&i.ThumbnailKey,
+	)
+	return i, err
+}
+
+const getVisibleMedia = `-- name: GetVisibleMedia :one
+SELECT media.id, media.created_at, media.user_id, media.chirp_id, media.content_type, media.size, media.width, media.height, media.storage_key, media.thumbnail_content_type, media.thumbnail_key,
+    (avatars.is_avatar OR (media.chirp_id IS NOT NULL AND chirp_visible_to(media.chirp_id, NULL)))::BOOLEAN AS is_public
+FROM media
+CROSS JOIN LATERAL (
+    SELECT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id) AS is_avatar
+) AS avatars
+WHERE media.id = $1
+  AND (avatars.is_avatar
+    OR (media.chirp_id IS NOT NULL AND chirp_visible_to(media.chirp_id, $2))
+    OR (media.chirp_id IS NULL AND media.user_id = $2))
+`
+
+type GetVisibleMediaParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+type GetVisibleMediaRow struct {
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UserID               uuid.UUID
+	ChirpID              uuid.NullUUID
+	ContentType          string
+	Size                 int64
+	Width                int32
+	Height               int32
+	StorageKey           string
+	ThumbnailContentType string
+	ThumbnailKey         string
+	IsPublic             bool
+}
+
+// Returns the media if the viewer, who is NULL when logged out, may see them:
+// avatars are visible to everyone, the images of a chirp to whoever may see
+// the chirp and other uploads only to their uploader. is_public tells whether
+// everyone may see them.
+func (q *Queries) GetVisibleMedia(ctx context.Context, arg GetVisibleMediaParams) (GetVisibleMediaRow, error) {
+	row := q.db.QueryRowContext(ctx, getVisibleMedia, arg.ID, arg.ViewerID)
+	var i GetVisibleMediaRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UserID,
+		&i.ChirpID,
+		&i.ContentType,
+		&i.Size,
+		&i.Width,
+		&i.Height,
+		&i.StorageKey,
+		&i.ThumbnailContentType,
+		&i.ThumbnailKey,
+		&i.IsPublic,
+	)
+	return i, err
+}
+
+const listChirpMedia = `-- name: ListChirpMedia :many
+SELECT id, created_at, user_id, chirp_id, content_type, size, width, height, storage_key, thumbnail_content_type, thumbnail_key
+FROM media
+WHERE chirp_id = ANY($1::UUID[])
+ORDER BY created_at ASC, id ASC
+`
+
+func (q *Queries) ListChirpMedia(ctx context.Context, chirpIds []uuid.UUID) ([]Media, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpMedia, pq.Array(chirpIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Media
+	for rows.Next() {
+		var i Media
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UserID,
+			&i.ChirpID,
+			&i.ContentType,
+			&i.Size,
+			&i.Width,
+			&i.Height,
+			&i.StorageKey,
+			&i.ThumbnailContentType,
+			&i.ThumbnailKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMediaBlobDeletions = `-- name: ListMediaBlobDeletions :many
+SELECT storage_key
+FROM media_blob_deletions
+ORDER BY created_at ASC, storage_key ASC
+LIMIT $1
+`
+
+func (q *Queries) ListMediaBlobDeletions(ctx context.Context, batchSize int32) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listMediaBlobDeletions, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var storage_key string
+		if err := rows.Scan(&storage_key); err != nil {
+			return nil, err
+		}
+		items = append(items, storage_key)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
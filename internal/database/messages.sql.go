@@ -0,0 +1,339 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: messages.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const countExistingUsers = `-- name: CountExistingUsers :one
+SELECT COUNT(*)
+FROM users
+WHERE id = ANY($1::UUID[])
+`
+
+func (q *Queries) CountExistingUsers(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countExistingUsers, pq.Array(ids))
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createConversation = `-- name: CreateConversation :one
+WITH conversation AS (
+    INSERT INTO conversations (id, created_at, updated_at, direct_key)
+    VALUES (gen_random_uuid(), NOW(), NOW(), $1)
+    RETURNING id, created_at, updated_at, direct_key
+), members AS (
+    INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+    SELECT conversation.id, UNNEST($2::UUID[]), NOW()
+    FROM conversation
+)
+SELECT id, created_at, updated_at, direct_key FROM conversation
+`
+
+type CreateConversationParams struct {
+	DirectKey sql.NullString
+	MemberIds []uuid.UUID
+}
+
+type CreateConversationRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DirectKey sql.NullString
+}
+
+// Creates a conversation with its members in a single statement.
+func (q *Queries) CreateConversation(ctx context.Context, arg CreateConversationParams) (CreateConversationRow, error) {
+	row := q.db.QueryRowContext(ctx, createConversation, arg.DirectKey, pq.Array(arg.MemberIds))
+	var i CreateConversationRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DirectKey,
+	)
+	return i, err
+}
+
+const createMessage = `-- name: CreateMessage :one
+WITH message AS (
+    INSERT INTO messages (id, created_at, conversation_id, sender_id, body)
+    VALUES (gen_random_uuid(), NOW(), $1, $2, $3)
+    RETURNING id, created_at, conversation_id, sender_id, body
+), touched AS (
+    UPDATE conversations
+    SET updated_at = message.created_at
+    FROM message
+    WHERE conversations.id = message.conversation_id
+)
+SELECT id, created_at, conversation_id, sender_id, body FROM message
+`
+
+type CreateMessageParams struct {
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	Body           string
+}
+
+type CreateMessageRow struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	Body           string
+}
+
+func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (CreateMessageRow, error) {
+	row := q.db.QueryRowContext(ctx, createMessage, arg.ConversationID, arg.SenderID, arg.Body)
+	var i CreateMessageRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Body,
+	)
+	return i, err
+}
+
+const getConversationByDirectKey = `-- name: GetConversationByDirectKey :one
+SELECT id, created_at, updated_at, direct_key
+FROM conversations
+WHERE direct_key = $1
+`
+
+func (q *Queries) GetConversationByDirectKey(ctx context.Context, directKey sql.NullString) (Conversation, error) {
+	row := q.db.QueryRowContext(ctx, getConversationByDirectKey, directKey)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DirectKey,
+	)
+	return i, err
+}
+
+const getConversationForMember = `-- name: GetConversationForMember :one
+SELECT conversations.id, conversations.created_at, conversations.updated_at, conversations.direct_key
+FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversations.id = $1
+  AND conversation_members.user_id = $2
+`
+
+type GetConversationForMemberParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+// Returns the conversation only if the user is one of its members.
+func (q *Queries) GetConversationForMember(ctx context.Context, arg GetConversationForMemberParams) (Conversation, error) {
+	row := q.db.QueryRowContext(ctx, getConversationForMember, arg.ID, arg.UserID)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DirectKey,
+	)
+	return i, err
+}
+
+const getMessage = `-- name: GetMessage :one
+SELECT id, created_at, conversation_id, sender_id, body
+FROM messages
+WHERE id = $1
+`
+
+func (q *Queries) GetMessage(ctx context.Context, id uuid.UUID) (Message, error) {
+	row := q.db.QueryRowContext(ctx, getMessage, id)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Body,
+	)
+	return i, err
+}
+
+const listConversationMembers = `-- name: ListConversationMembers :many
+SELECT conversation_members.conversation_id, conversation_members.last_read_at,
+    users.id, users.username, users.display_name
+FROM conversation_members
+JOIN users ON users.id = conversation_members.user_id
+WHERE conversation_members.conversation_id = ANY($1::UUID[])
+ORDER BY conversation_members.conversation_id, conversation_members.joined_at, users.id
+`
+
+type ListConversationMembersRow struct {
+	ConversationID uuid.UUID
+	LastReadAt     sql.NullTime
+	ID             uuid.UUID
+	Username       sql.NullString
+	DisplayName    string
+}
+
+func (q *Queries) ListConversationMembers(ctx context.Context, conversationIds []uuid.UUID) ([]ListConversationMembersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listConversationMembers, pq.Array(conversationIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListConversationMembersRow
+	for rows.Next() {
+		var i ListConversationMembersRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.LastReadAt,
+			&i.ID,
+			&i.Username,
+			&i.DisplayName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listConversationsForMember = `-- name: ListConversationsForMember :many
+SELECT conversations.id, conversations.created_at, conversations.updated_at, conversations.direct_key,
+    (SELECT COUNT(*)
+     FROM messages
+     WHERE messages.conversation_id = conversations.id
+       AND messages.sender_id <> conversation_members.user_id
+       AND (conversation_members.last_read_at IS NULL OR messages.created_at > conversation_members.last_read_at)) AS unread_count
+FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversation_members.user_id = $1
+ORDER BY conversations.updated_at DESC, conversations.id DESC
+`
+
+type ListConversationsForMemberRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DirectKey   sql.NullString
+	UnreadCount int64
+}
+
+func (q *Queries) ListConversationsForMember(ctx context.Context, userID uuid.UUID) ([]ListConversationsForMemberRow, error) {
+	rows, err := q.db.QueryContext(ctx, listConversationsForMember, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListConversationsForMemberRow
+	for rows.Next() {
+		var i ListConversationsForMemberRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DirectKey,
+			&i.UnreadCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessages = `-- name: ListMessages :many
+SELECT id, created_at, conversation_id, sender_id, body
+FROM messages
+WHERE conversation_id = $1
+  AND ($2::TIMESTAMP IS NULL
+    OR (created_at, id) < ($2::TIMESTAMP, $3::UUID))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListMessagesParams struct {
+	ConversationID  uuid.UUID
+	BeforeCreatedAt sql.NullTime
+	BeforeID        uuid.NullUUID
+	MaxResults      int32
+}
+
+func (q *Queries) ListMessages(ctx context.Context, arg ListMessagesParams) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, listMessages,
+		arg.ConversationID,
+		arg.BeforeCreatedAt,
+		arg.BeforeID,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Body,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markConversationRead = `-- name: MarkConversationRead :execrows
+UPDATE conversation_members
+SET last_read_at = GREATEST(COALESCE(last_read_at, $1::TIMESTAMP), $1::TIMESTAMP)
+WHERE conversation_id = $2
+  AND user_id = $3
+`
+
+type MarkConversationReadParams struct {
+	ReadAt         time.Time
+	ConversationID uuid.UUID
+	UserID         uuid.UUID
+}
+
+// Moves the member's read position forward to read_at, never back.
+func (q *Queries) MarkConversationRead(ctx context.Context, arg MarkConversationReadParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markConversationRead, arg.ReadAt, arg.ConversationID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
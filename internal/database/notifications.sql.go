@@ -0,0 +1,256 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: notifications.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const countUnreadNotifications = `-- name: CountUnreadNotifications :one
+SELECT COUNT(*)
+FROM notifications
+WHERE notifications.user_id = $1
+  AND notifications.read_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM notification_actors
+    WHERE notification_actors.notification_id = notifications.id
+      AND NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = notifications.user_id AND hidden_users.hidden_id = notification_actors.actor_id
+      )
+  )
+`
+
+// Counts the unread notifications that have an actor not hidden from the user.
+func (q *Queries) CountUnreadNotifications(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnreadNotifications, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createNotification = `-- name: CreateNotification :one
+WITH notification AS (
+    INSERT INTO notifications (id, created_at, updated_at, user_id, type, chirp_id, group_key)
+    SELECT gen_random_uuid(), NOW(), NOW(), $2::UUID, $3::TEXT, $4::UUID, $5::TEXT
+    WHERE NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = $2::UUID AND hidden_users.hidden_id = $1::UUID
+    )
+    ON CONFLICT (user_id, group_key) WHERE read_at IS NULL
+    DO UPDATE SET updated_at = NOW()
+    RETURNING id
+)
+INSERT INTO notification_actors (notification_id, actor_id, created_at)
+SELECT notification.id, $1, NOW()
+FROM notification
+ON CONFLICT (notification_id, actor_id) DO UPDATE SET created_at = EXCLUDED.created_at
+RETURNING notification_id
+`
+
+type CreateNotificationParams struct {
+	ActorID  uuid.UUID
+	UserID   uuid.UUID
+	Type     string
+	ChirpID  uuid.NullUUID
+	GroupKey string
+}
+
+// Adds the actor to the user's unread notification with the same group key,
+// or to a new notification if there is none. Returns no rows if the actor is
+// hidden from the user.
+func (q *Queries) CreateNotification(ctx context.Context, arg CreateNotificationParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, createNotification,
+		arg.ActorID,
+		arg.UserID,
+		arg.Type,
+		arg.ChirpID,
+		arg.GroupKey,
+	)
+	var notification_id uuid.UUID
+	err := row.Scan(&notification_id)
+	return notification_id, err
+}
+
+const listNotificationActors = `-- name: ListNotificationActors :many
+SELECT ranked.notification_id, users.id, users.username, users.display_name
+FROM (
+    SELECT notification_id, actor_id, created_at,
+        ROW_NUMBER() OVER (PARTITION BY notification_id ORDER BY created_at DESC, actor_id) AS position
+    FROM notification_actors
+    WHERE notification_id = ANY($1::UUID[])
+      AND NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = $2 AND hidden_users.hidden_id = notification_actors.actor_id
+      )
+) AS ranked
+JOIN users ON users.id = ranked.actor_id
+WHERE ranked.position <= $3::BIGINT
+ORDER BY ranked.notification_id, ranked.position
+`
+
+type ListNotificationActorsParams struct {
+	NotificationIds []uuid.UUID
+	UserID          uuid.UUID
+	MaxActors       int64
+}
+
+type ListNotificationActorsRow struct {
+	NotificationID uuid.UUID
+	ID             uuid.UUID
+	Username       sql.NullString
+	DisplayName    string
+}
+
+// Returns the most recent actors of each notification, up to max_actors,
+// except the ones hidden from the user.
+func (q *Queries) ListNotificationActors(ctx context.Context, arg ListNotificationActorsParams) ([]ListNotificationActorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listNotificationActors, pq.Array(arg.NotificationIds), arg.UserID, arg.MaxActors)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListNotificationActorsRow
+	for rows.Next() {
+		var i ListNotificationActorsRow
+		if err := rows.Scan(
+			&i.NotificationID,
+			&i.ID,
+			&i.Username,
+			&i.DisplayName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listNotifications = `-- name: ListNotifications :many
+SELECT notifications.id, notifications.created_at, notifications.updated_at, notifications.user_id, notifications.type, notifications.chirp_id, notifications.group_key, notifications.read_at, visible.actor_count
+FROM notifications
+CROSS JOIN LATERAL (
+    SELECT COUNT(*) AS actor_count
+    FROM notification_actors
+    WHERE notification_actors.notification_id = notifications.id
+      AND NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = notifications.user_id AND hidden_users.hidden_id = notification_actors.actor_id
+      )
+) AS visible
+WHERE notifications.user_id = $1
+  AND visible.actor_count > 0
+  AND ($2::TIMESTAMP IS NULL
+    OR (notifications.updated_at, notifications.id) < ($2::TIMESTAMP, $3::UUID))
+ORDER BY notifications.updated_at DESC, notifications.id DESC
+LIMIT $4
+`
+
+type ListNotificationsParams struct {
+	UserID          uuid.UUID
+	BeforeUpdatedAt sql.NullTime
+	BeforeID        uuid.NullUUID
+	MaxResults      int32
+}
+
+type ListNotificationsRow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Type       string
+	ChirpID    uuid.NullUUID
+	GroupKey   string
+	ReadAt     sql.NullTime
+	ActorCount int64
+}
+
+// Leaves out the actors hidden from the user, and the notifications that only
+// have such actors.
+func (q *Queries) ListNotifications(ctx context.Context, arg ListNotificationsParams) ([]ListNotificationsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listNotifications,
+		arg.UserID,
+		arg.BeforeUpdatedAt,
+		arg.BeforeID,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListNotificationsRow
+	for rows.Next() {
+		var i ListNotificationsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Type,
+			&i.ChirpID,
+			&i.GroupKey,
+			&i.ReadAt,
+			&i.ActorCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markAllNotificationsRead = `-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = $1
+  AND read_at IS NULL
+`
+
+func (q *Queries) MarkAllNotificationsRead(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markAllNotificationsRead, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markNotificationRead = `-- name: MarkNotificationRead :execrows
+UPDATE notifications
+SET read_at = COALESCE(read_at, NOW())
+WHERE id = $1
+  AND user_id = $2
+`
+
+type MarkNotificationReadParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) MarkNotificationRead(ctx context.Context, arg MarkNotificationReadParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markNotificationRead, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Ping checks the connection to the database, retrying up to the given number of
+// attempts. The delay between attempts doubles after every failure. It returns
+// the last error if the database never responds or the context is cancelled.
+func Ping(ctx context.Context, db *sql.DB, attempts int, delay time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = db.PingContext(ctx); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to reach the database: %w", err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("failed to reach the database after %d attempts: %w", attempts, err)
+}
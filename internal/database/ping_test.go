@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestPingGivesUp(t *testing.T) {
+	// Nothing listens on port 1, so every attempt is refused immediately
+	db, err := sql.Open("postgres", "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	err = Ping(context.Background(), db, 3, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("Ping should have returned an error")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("Expected the error to report the attempts, got %v", err)
+	}
+	// Two waits between three attempts: 10ms and 20ms
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected Ping to back off between attempts, returned after %v", elapsed)
+	}
+}
+
+func TestPingStopsWhenCancelled(t *testing.T) {
+	db, err := sql.Open("postgres", "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := Ping(ctx, db, 100, time.Second); err == nil {
+		t.Fatal("Ping should have returned an error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected Ping to stop when the context is cancelled, returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,14 @@
+package database
+
+import "strings"
+
+// QueryName returns the name of a sqlc generated query, which sqlc places in a
+// leading "-- name: <Name> :<kind>" comment, or "unknown" for other queries.
+func QueryName(query string) string {
+	rest, ok := strings.CutPrefix(query, "-- name: ")
+	if !ok {
+		return "unknown"
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
@@ -0,0 +1,276 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.27.0
+// source: webhook_events.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+const claimWebhookEvent = `-- name: ClaimWebhookEvent :one
+UPDATE webhook_events
+SET status = 'processing',
+    attempts = attempts + 1,
+    updated_at = NOW()
+WHERE id = $1
+  AND (
+    status IN ('pending', 'failed')
+    OR (status = 'processing' AND updated_at < NOW() - $2::int * INTERVAL '1 second')
+  )
+RETURNING id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts, last_error, processed_at
+`
+
+type ClaimWebhookEventParams struct {
+	ID           uuid.UUID
+	LeaseSeconds int32
+}
+
+// Events left processing for longer than the lease, by a crash or a timeout,
+// can be claimed again.
+func (q *Queries) ClaimWebhookEvent(ctx context.Context, arg ClaimWebhookEventParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, claimWebhookEvent, arg.ID, arg.LeaseSeconds)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Provider,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.ProcessedAt,
+	)
+	return i, err
+}
+
+const createWebhookEvent = `-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    'pending',
+    0
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts, last_error, processed_at
+`
+
+type CreateWebhookEventParams struct {
+	Provider  string
+	EventID   string
+	EventType string
+	Payload   json.RawMessage
+}
+
+func (q *Queries) CreateWebhookEvent(ctx context.Context, arg CreateWebhookEventParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, createWebhookEvent,
+		arg.Provider,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+	)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Provider,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.ProcessedAt,
+	)
+	return i, err
+}
+
+const getWebhookEvent = `-- name: GetWebhookEvent :one
+SELECT id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts, last_error, processed_at
+FROM webhook_events
+WHERE id = $1
+`
+
+func (q *Queries) GetWebhookEvent(ctx context.Context, id uuid.UUID) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookEvent, id)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Provider,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.ProcessedAt,
+	)
+	return i, err
+}
+
+const getWebhookEventByEventID = `-- name: GetWebhookEventByEventID :one
+SELECT id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts, last_error, processed_at
+FROM webhook_events
+WHERE provider = $1 AND event_id = $2
+`
+
+type GetWebhookEventByEventIDParams struct {
+	Provider string
+	EventID  string
+}
+
+func (q *Queries) GetWebhookEventByEventID(ctx context.Context, arg GetWebhookEventByEventIDParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookEventByEventID, arg.Provider, arg.EventID)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Provider,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.ProcessedAt,
+	)
+	return i, err
+}
+
+const listWebhookEvents = `-- name: ListWebhookEvents :many
+SELECT id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts, last_error, processed_at
+FROM webhook_events
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListWebhookEvents(ctx context.Context) ([]WebhookEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listWebhookEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEvent
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Provider,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.ProcessedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEventsByStatus = `-- name: ListWebhookEventsByStatus :many
+SELECT id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts, last_error, processed_at
+FROM webhook_events
+WHERE status = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListWebhookEventsByStatus(ctx context.Context, status string) ([]WebhookEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listWebhookEventsByStatus, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEvent
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Provider,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.ProcessedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markWebhookEventFailed = `-- name: MarkWebhookEventFailed :exec
+UPDATE webhook_events
+SET status = 'failed',
+    last_error = $2,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type MarkWebhookEventFailedParams struct {
+	ID        uuid.UUID
+	LastError sql.NullString
+}
+
+func (q *Queries) MarkWebhookEventFailed(ctx context.Context, arg MarkWebhookEventFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markWebhookEventFailed, arg.ID, arg.LastError)
+	return err
+}
+
+const markWebhookEventProcessed = `-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events
+SET status = $2,
+    last_error = NULL,
+    processed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type MarkWebhookEventProcessedParams struct {
+	ID     uuid.UUID
+	Status string
+}
+
+func (q *Queries) MarkWebhookEventProcessed(ctx context.Context, arg MarkWebhookEventProcessedParams) error {
+	_, err := q.db.ExecContext(ctx, markWebhookEventProcessed, arg.ID, arg.Status)
+	return err
+}
@@ -0,0 +1,36 @@
+// Package eventbus carries the events of the real-time APIs between the
+// instances of Chirpy. Every instance subscribes its clients to its own
+// stream.Broker; a Bus delivers each published event to the Broker of every
+// instance that should see it.
+package eventbus
+
+import (
+	"context"
+
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// Bus publishes events to the brokers of the instances it connects.
+type Bus interface {
+	// Publish delivers the event to the local broker right away and to the
+	// other instances asynchronously. An error means the other instances, and
+	// possibly the local broker, may not receive the event.
+	Publish(ctx context.Context, event stream.Event) error
+}
+
+// Local is a Bus for a single instance: it publishes events to its broker
+// only.
+type Local struct {
+	broker *stream.Broker
+}
+
+// NewLocal returns a Bus that publishes events to broker only.
+func NewLocal(broker *stream.Broker) *Local {
+	return &Local{broker: broker}
+}
+
+// Publish publishes the event to the broker. It never fails.
+func (l *Local) Publish(ctx context.Context, event stream.Event) error {
+	l.broker.Publish(event)
+	return nil
+}
@@ -0,0 +1,152 @@
+package eventbus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/migrations"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+func receive(t *testing.T, sub *stream.Subscription) stream.Event {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return stream.Event{}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	broker := stream.NewBroker(0, 0)
+	sub, _, _ := broker.Subscribe(nil, 0)
+	defer sub.Close()
+
+	authorID := uuid.New()
+	if err := NewLocal(broker).Publish(context.Background(), stream.Event{Type: stream.EventChirpCreated, AuthorID: authorID}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if event := receive(t, sub); event.Type != stream.EventChirpCreated || event.AuthorID != authorID {
+		t.Errorf("Expected the published event, got %+v", event)
+	}
+}
+
+func TestPostgresReceive(t *testing.T) {
+	broker := stream.NewBroker(0, 0)
+	sub, _, _ := broker.Subscribe(nil, 0)
+	defer sub.Close()
+	bus := NewPostgres("", nil, broker)
+
+	authorID, recipientID := uuid.New(), uuid.New()
+	own, _ := json.Marshal(message{Origin: bus.origin, Type: stream.EventChirpDeleted, AuthorID: authorID})
+	other, _ := json.Marshal(message{
+		Origin:     uuid.New(),
+		ID:         42,
+		Type:       stream.EventChirpCreated,
+		AuthorID:   authorID,
+		Recipients: []uuid.UUID{recipientID},
+		Visibility: stream.VisibilityFollowers,
+		Data:       json.RawMessage(`{"body":"hi"}`),
+	})
+
+	// Events the instance published itself are already in its broker
+	bus.receive(context.Background(), string(own))
+	bus.receive(context.Background(), "not json")
+	bus.receive(context.Background(), string(other))
+
+	event := receive(t, sub)
+	if event.ID != 42 || event.Type != stream.EventChirpCreated || event.AuthorID != authorID || !event.IsFor(recipientID) || event.Visibility != stream.VisibilityFollowers || string(event.Data) != `{"body":"hi"}` {
+		t.Errorf("Expected the other instance's event, got %+v", event)
+	}
+	if broker.Subscribers() != 1 {
+		t.Error("Expected the subscriber to still be subscribed")
+	}
+}
+
+// TestPostgres runs two buses against a real database. It needs a disposable
+// Postgres database in CHIRPY_TEST_DB_URL and is skipped otherwise.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_DB_URL is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	publisher := NewPostgres(dbURL, database.New(db), stream.NewBroker(0, 0))
+	broker := stream.NewBroker(0, 0)
+	subscriber := NewPostgres(dbURL, database.New(db), broker)
+	done := make(chan error, 1)
+	go func() { done <- subscriber.Run(ctx) }()
+	sub, _, _ := broker.Subscribe(nil, 0)
+	defer sub.Close()
+
+	// The listener has no way to report that it is listening, so publish until
+	// the first event arrives
+	authorID := uuid.New()
+	var event stream.Event
+	for event.Type == "" {
+		if err := publisher.Publish(ctx, stream.Event{Type: stream.EventChirpCreated, AuthorID: authorID, Data: []byte(`{}`)}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		select {
+		case event = <-sub.Events():
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for the listener")
+		}
+	}
+	if event.AuthorID != authorID {
+		t.Errorf("Expected the published event, got %+v", event)
+	}
+
+	// Both brokers know the event by the ID the publisher took from the
+	// database, so a stream can resume on either instance
+	resumed, _, complete := broker.Subscribe(nil, event.ID)
+	defer resumed.Close()
+	if !complete {
+		t.Errorf("Expected the subscriber's broker to resume from event %d", event.ID)
+	}
+
+	// Events too large for a notification go through the event_payloads table
+	data, _ := json.Marshal(map[string]string{"body": strings.Repeat("a", 2*MaxNotifyPayload)})
+	if err := publisher.Publish(ctx, stream.Event{Type: stream.EventChirpCreated, AuthorID: authorID, Data: data}); err != nil {
+		t.Fatalf("Publishing a large event failed: %v", err)
+	}
+	// Skip the small events of the first attempts that were still in flight
+	for event = receive(t, sub); string(event.Data) == `{}`; {
+		event = receive(t, sub)
+	}
+	if string(event.Data) != string(data) {
+		t.Errorf("Expected the large event's data, got %d bytes", len(event.Data))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+	"github.com/Fepozopo/chirpy/internal/stream"
+)
+
+// Channel is the Postgres notification channel events are sent on.
+const Channel = "chirpy_events"
+
+// MaxNotifyPayload is the size of the largest notification payload, in bytes.
+// Postgres rejects payloads of 8000 bytes or more, so larger events are stored
+// in the event_payloads table, for an hour, and their notification refers to
+// the stored copy.
+const MaxNotifyPayload = 7999
+
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	// pingInterval is how often an idle listener checks its connection, which
+	// would otherwise not notice a silently dropped connection.
+	pingInterval = 90 * time.Second
+)
+
+// message is the payload of a notification. Messages too large for a
+// notification are replaced with one that only has Origin and PayloadID.
+type message struct {
+	// Origin is the instance that published the event, which has already
+	// delivered it to its own broker.
+	Origin uuid.UUID `json:"origin"`
+	// ID is the ID of the event, which the publishing instance takes from the
+	// stream_event_ids sequence so that every broker uses the same one.
+	ID         uint64          `json:"id"`
+	PayloadID  *uuid.UUID      `json:"payload_id,omitempty"`
+	Type       string          `json:"type,omitempty"`
+	AuthorID   uuid.UUID       `json:"author_id"`
+	Recipients []uuid.UUID     `json:"recipients,omitempty"`
+	Visibility string          `json:"visibility,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// Postgres is a Bus that sends events to the other instances with Postgres
+// NOTIFY and receives theirs with LISTEN. Events are numbered by the database,
+// so a stream resumes with the same event ID on any instance. Run must be
+// running to receive events. Events published while an instance is
+// disconnected from the database are lost to it.
+type Postgres struct {
+	dsn    string
+	db     *database.Queries
+	broker *stream.Broker
+	origin uuid.UUID
+
+	// MaxPayload is the size of the largest payload sent in a notification.
+	MaxPayload int
+}
+
+// NewPostgres returns a Bus that publishes events to broker and to the other
+// instances connected to the database at dsn, whose queries are db.
+func NewPostgres(dsn string, db *database.Queries, broker *stream.Broker) *Postgres {
+	return &Postgres{
+		dsn:        dsn,
+		db:         db,
+		broker:     broker,
+		origin:     uuid.New(),
+		MaxPayload: MaxNotifyPayload,
+	}
+}
+
+// Publish numbers the event from the stream_event_ids sequence, publishes it
+// to the local broker and notifies the other instances. An event that cannot
+// be numbered is not published at all, as an ID from the local broker would
+// not be recognized by the others.
+func (p *Postgres) Publish(ctx context.Context, event stream.Event) error {
+	eventID, err := p.db.NextEventID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to number event: %w", err)
+	}
+	event.ID = uint64(eventID)
+	p.broker.Publish(event)
+
+	payload, err := json.Marshal(message{
+		Origin:     p.origin,
+		ID:         event.ID,
+		Type:       event.Type,
+		AuthorID:   event.AuthorID,
+		Recipients: event.Recipients,
+		Visibility: event.Visibility,
+		Data:       event.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	if len(payload) > p.MaxPayload {
+		id := uuid.New()
+		if err := p.db.CreateEventPayload(ctx, database.CreateEventPayloadParams{ID: id, Payload: payload}); err != nil {
+			return fmt.Errorf("failed to store event payload: %w", err)
+		}
+		if payload, err = json.Marshal(message{Origin: p.origin, ID: event.ID, PayloadID: &id}); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if _, err := p.db.DeleteExpiredEventPayloads(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to delete expired event payloads", "error", err)
+		}
+	}
+
+	if err := p.db.NotifyEvent(ctx, database.NotifyEventParams{Channel: Channel, Payload: string(payload)}); err != nil {
+		return fmt.Errorf("failed to notify event: %w", err)
+	}
+	return nil
+}
+
+// Run receives the events published by the other instances and publishes them
+// to the local broker, until ctx is done. It reconnects on its own when the
+// connection to the database drops.
+func (p *Postgres) Run(ctx context.Context) error {
+	listener := pq.NewListener(p.dsn, minReconnectInterval, maxReconnectInterval, logListenerEvent)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		// Closing the listener also interrupts a Listen waiting for a connection
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		listener.Close()
+	}()
+
+	if err := listener.Listen(Channel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to listen for events: %w", err)
+	}
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification, ok := <-listener.Notify:
+			if !ok {
+				return nil
+			}
+			// A nil notification follows a reconnection
+			if notification != nil {
+				p.receive(ctx, notification.Extra)
+			}
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
+
+// receive publishes an event notified by another instance to the local broker.
+func (p *Postgres) receive(ctx context.Context, payload string) {
+	var msg message
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode event notification", "error", err)
+		return
+	}
+	if msg.Origin == p.origin {
+		return
+	}
+
+	if msg.PayloadID != nil {
+		stored, err := p.db.GetEventPayload(ctx, *msg.PayloadID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load event payload", "payload_id", msg.PayloadID, "error", err)
+			return
+		}
+		if err := json.Unmarshal(stored, &msg); err != nil {
+			slog.ErrorContext(ctx, "Failed to decode event payload", "payload_id", msg.PayloadID, "error", err)
+			return
+		}
+	}
+
+	p.broker.Publish(stream.Event{
+		ID:         msg.ID,
+		Type:       msg.Type,
+		AuthorID:   msg.AuthorID,
+		Recipients: msg.Recipients,
+		Visibility: msg.Visibility,
+		Data:       msg.Data,
+	})
+}
+
+// logListenerEvent logs the connection changes of a listener.
+func logListenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventConnected:
+		slog.Info("Event bus connected")
+	case pq.ListenerEventDisconnected:
+		slog.Warn("Event bus disconnected, events published meanwhile are lost", "error", err)
+	case pq.ListenerEventReconnected:
+		slog.Info("Event bus reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		slog.Warn("Event bus failed to connect", "error", err)
+	}
+}
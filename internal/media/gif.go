@@ -0,0 +1,68 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errTruncatedGIF is returned by gifFramePixels for GIFs that end before their
+// trailer.
+var errTruncatedGIF = errors.New("truncated GIF")
+
+// gifFramePixels walks the blocks of a GIF without decoding them and returns
+// the number of pixels of all its frames together, which is the memory
+// gif.DecodeAll will need for them. It stops counting once the total exceeds
+// limit, so that a file with millions of tiny frames is not walked to the end.
+func gifFramePixels(data []byte, limit int) (int, error) {
+	// Header and logical screen descriptor, followed by the optional global
+	// color table
+	const headerSize = 6 + 7
+	if len(data) < headerSize {
+		return 0, errTruncatedGIF
+	}
+	pos := headerSize
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+
+	total := 0
+	for total <= limit {
+		if pos >= len(data) {
+			return 0, errTruncatedGIF
+		}
+		switch data[pos] {
+		case 0x21: // Extension: introducer, label and data sub-blocks
+			pos += 2
+		case 0x2C: // Image descriptor, optional local color table, LZW code size and data sub-blocks
+			if pos+10 > len(data) {
+				return 0, errTruncatedGIF
+			}
+			width := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			total += width * height
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			pos++
+		case 0x3B: // Trailer
+			return total, nil
+		default:
+			return 0, errors.New("unknown GIF block")
+		}
+
+		// Skip the data sub-blocks up to the terminating empty one
+		for {
+			if pos >= len(data) {
+				return 0, errTruncatedGIF
+			}
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	return total, nil
+}
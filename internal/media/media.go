@@ -0,0 +1,157 @@
+// Package media validates and cleans uploaded images. Images are decoded and
+// encoded again, which drops all metadata, including EXIF data such as the GPS
+// position a photo was taken at, and thumbnails are generated from them.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+// Content types of the supported images.
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeGIF  = "image/gif"
+)
+
+// MaxPixels bounds the size of a decoded image, guarding against small files
+// that decode to huge images.
+const MaxPixels = 40_000_000
+
+// MaxAnimationPixels bounds the pixels of all frames of an animated GIF
+// together, as every frame is decoded at once.
+const MaxAnimationPixels = 100_000_000
+
+// jpegQuality is the quality images and thumbnails are encoded with as JPEG.
+const jpegQuality = 90
+
+var (
+	// ErrUnsupportedType is returned for files that are not JPEG, PNG or GIF
+	// images.
+	ErrUnsupportedType = errors.New("unsupported media type")
+	// ErrInvalidImage is returned for files that cannot be decoded.
+	ErrInvalidImage = errors.New("invalid image")
+	// ErrTooManyPixels is returned for images larger than MaxPixels and
+	// animations larger than MaxAnimationPixels.
+	ErrTooManyPixels = errors.New("image has too many pixels")
+)
+
+// Image is a cleaned image and its thumbnail.
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+
+	ThumbnailContentType string
+	Thumbnail            []byte
+}
+
+// Process detects the type of an uploaded image from its content, ignoring the
+// type claimed by the client, and returns it without metadata, along with a
+// thumbnail that fits in a square of thumbnailSize pixels. JPEG images are
+// rotated upright according to their EXIF orientation before it is dropped.
+func Process(data []byte, thumbnailSize int) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case TypeJPEG, TypePNG, TypeGIF:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	if contentType == TypeGIF {
+		pixels, err := gifFramePixels(data, MaxAnimationPixels)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		if pixels > MaxAnimationPixels {
+			return nil, ErrTooManyPixels
+		}
+	}
+
+	var cleaned bytes.Buffer
+	var img image.Image
+	switch contentType {
+	case TypeJPEG:
+		if img, err = jpeg.Decode(bytes.NewReader(data)); err == nil {
+			img = orient(img, exifOrientation(data))
+			err = jpeg.Encode(&cleaned, img, &jpeg.Options{Quality: jpegQuality})
+		}
+	case TypePNG:
+		if img, err = png.Decode(bytes.NewReader(data)); err == nil {
+			err = png.Encode(&cleaned, img)
+		}
+	case TypeGIF:
+		// Every frame is kept, so animations survive; comments and application
+		// extensions other than the loop count are dropped
+		var animation *gif.GIF
+		if animation, err = gif.DecodeAll(bytes.NewReader(data)); err == nil {
+			img = animation.Image[0]
+			err = gif.EncodeAll(&cleaned, animation)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	thumbnail, thumbnailType, err := makeThumbnail(img, thumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	return &Image{
+		ContentType:          contentType,
+		Data:                 cleaned.Bytes(),
+		Width:                bounds.Dx(),
+		Height:               bounds.Dy(),
+		ThumbnailContentType: thumbnailType,
+		Thumbnail:            thumbnail,
+	}, nil
+}
+
+// makeThumbnail scales the image down to fit in a square of size pixels,
+// keeping its aspect ratio. Images that already fit are not scaled up. Opaque
+// images become JPEG thumbnails, others PNG to keep their transparency.
+func makeThumbnail(img image.Image, size int) ([]byte, string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	thumbnail := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if thumbnail.Opaque() {
+		if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, "", fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		return buf.Bytes(), TypeJPEG, nil
+	}
+	if err := png.Encode(&buf, thumbnail); err != nil {
+		return nil, "", fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), TypePNG, nil
+}
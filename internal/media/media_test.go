@@ -0,0 +1,210 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a w×h image that is white except for a red block of up to
+// 8×8 pixels in its top-left corner, large enough to survive JPEG compression.
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	for y := 0; y < min(h, 8); y++ {
+		for x := 0; x < min(w, 8); x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	return img
+}
+
+// withEXIF inserts an APP1 segment with the given orientation and a GPS-like
+// marker into a JPEG image, right after its start of image marker.
+func withEXIF(t *testing.T, jpegData []byte, orientation uint16) []byte {
+	t.Helper()
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPS 52.37N 4.90E")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(jpegData[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(jpegData[2:])
+	return out.Bytes()
+}
+
+func TestProcessStripsEXIFAndOrients(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(40, 20), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := withEXIF(t, buf.Bytes(), 6)
+	if exifOrientation(data) != 6 {
+		t.Fatalf("exifOrientation = %d, want 6", exifOrientation(data))
+	}
+
+	img, err := Process(data, 320)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if img.ContentType != TypeJPEG {
+		t.Errorf("ContentType = %q, want %q", img.ContentType, TypeJPEG)
+	}
+	if bytes.Contains(img.Data, []byte("Exif")) || bytes.Contains(img.Data, []byte("GPS")) {
+		t.Error("Processed image still contains the EXIF data")
+	}
+
+	// Orientation 6 is turned clockwise, so the image becomes portrait and the
+	// top-left corner moves to the top-right
+	if img.Width != 20 || img.Height != 40 {
+		t.Fatalf("Size = %d×%d, want 20×40", img.Width, img.Height)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatalf("Processed image cannot be decoded: %v", err)
+	}
+	if r, g, _, _ := decoded.At(16, 3).RGBA(); r < 0xc000 || g > 0x4000 {
+		t.Errorf("Expected the top-right corner to be red, got %v", decoded.At(16, 3))
+	}
+	if r, g, _, _ := decoded.At(3, 3).RGBA(); r < 0xc000 || g < 0xc000 {
+		t.Errorf("Expected the top-left corner to be white, got %v", decoded.At(3, 3))
+	}
+}
+
+func TestProcessThumbnail(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		wantWidth, wantHeight int
+	}{
+		{name: "landscape", width: 1000, height: 500, wantWidth: 320, wantHeight: 160},
+		{name: "portrait", width: 300, height: 600, wantWidth: 160, wantHeight: 320},
+		{name: "small", width: 100, height: 50, wantWidth: 100, wantHeight: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, testImage(tt.width, tt.height)); err != nil {
+				t.Fatal(err)
+			}
+			img, err := Process(buf.Bytes(), 320)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if img.ContentType != TypePNG || img.Width != tt.width || img.Height != tt.height {
+				t.Errorf("Got %s %d×%d, want %s %d×%d", img.ContentType, img.Width, img.Height, TypePNG, tt.width, tt.height)
+			}
+
+			config, format, err := image.DecodeConfig(bytes.NewReader(img.Thumbnail))
+			if err != nil {
+				t.Fatalf("Thumbnail cannot be decoded: %v", err)
+			}
+			if format != "jpeg" || img.ThumbnailContentType != TypeJPEG {
+				t.Errorf("Thumbnail of an opaque image is %s, want jpeg", format)
+			}
+			if config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+				t.Errorf("Thumbnail is %d×%d, want %d×%d", config.Width, config.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	// A valid 1×1 PNG whose header claims it is 100000×100000 pixels
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	huge := buf.Bytes()
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	// A GIF with a 4000×4000 screen and seven frames that fill it, each within
+	// MaxPixels but together more than MaxAnimationPixels
+	animation := []byte("GIF89a")
+	animation = binary.LittleEndian.AppendUint16(animation, 4000)
+	animation = binary.LittleEndian.AppendUint16(animation, 4000)
+	animation = append(animation, 0, 0, 0)
+	for range 7 {
+		animation = append(animation, 0x2C, 0, 0, 0, 0)
+		animation = binary.LittleEndian.AppendUint16(animation, 4000)
+		animation = binary.LittleEndian.AppendUint16(animation, 4000)
+		animation = append(animation, 0x80, 0, 0, 0, 255, 255, 255, 2, 2, 0x4C, 0x01, 0)
+	}
+	animation = append(animation, 0x3B)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "text", data: []byte("just some text"), want: ErrUnsupportedType},
+		{name: "svg", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), want: ErrUnsupportedType},
+		{name: "truncated", data: buf.Bytes()[:30], want: ErrInvalidImage},
+		{name: "too many pixels", data: huge, want: ErrTooManyPixels},
+		{name: "too many animation pixels", data: animation, want: ErrTooManyPixels},
+		{name: "truncated animation", data: animation[:len(animation)-20], want: ErrInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data, 320); !errors.Is(err, tt.want) {
+				t.Errorf("Process returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIFFramePixels(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 10, 20), []color.Color{color.White, color.Black})
+	animation := &gif.GIF{
+		Image: []*image.Paletted{frame, frame, frame},
+		Delay: []int{10, 10, 10},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	if pixels, err := gifFramePixels(buf.Bytes(), MaxAnimationPixels); err != nil || pixels != 600 {
+		t.Errorf("gifFramePixels = %d, %v, want 600", pixels, err)
+	}
+	if pixels, err := gifFramePixels(buf.Bytes(), 300); err != nil || pixels <= 300 {
+		t.Errorf("gifFramePixels with a limit of 300 = %d, %v, want more than 300", pixels, err)
+	}
+	if _, err := gifFramePixels(buf.Bytes()[:buf.Len()-1], MaxAnimationPixels); err == nil {
+		t.Error("gifFramePixels of a GIF without its trailer succeeded")
+	}
+
+	img, err := Process(buf.Bytes(), 320)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if decoded, err := gif.DecodeAll(bytes.NewReader(img.Data)); err != nil || len(decoded.Image) != 3 {
+		t.Errorf("Processed GIF could not be decoded with 3 frames: %v", err)
+	}
+}
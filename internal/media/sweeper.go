@@ -0,0 +1,96 @@
+package media
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Fepozopo/chirpy/internal/blobstore"
+	"github.com/Fepozopo/chirpy/internal/database"
+)
+
+// Sweeper removes the blobs of deleted media from the blob store. Media rows
+// are deleted along with their chirp or uploader by cascade, and a trigger
+// queues their blobs in the media_blob_deletions table, so blobs are collected
+// however the rows went away. Uploads that are never attached to a chirp or
+// used as an avatar are deleted once they are older than UnattachedTTL.
+type Sweeper struct {
+	db    *database.Queries
+	blobs blobstore.BlobStore
+
+	Interval      time.Duration
+	UnattachedTTL time.Duration
+	BatchSize     int32
+}
+
+// NewSweeper returns a Sweeper that deletes the blobs queued in the database
+// from the blob store.
+func NewSweeper(db *database.Queries, blobs blobstore.BlobStore) *Sweeper {
+	return &Sweeper{
+		db:            db,
+		blobs:         blobs,
+		Interval:      10 * time.Minute,
+		UnattachedTTL: 24 * time.Hour,
+		BatchSize:     100,
+	}
+}
+
+// Run sweeps every Interval until the context is canceled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.Sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes the expired unattached uploads, then the queued blobs. Blobs
+// that fail to be deleted stay queued and are retried by the next sweep.
+func (s *Sweeper) Sweep(ctx context.Context) {
+	if deleted, err := s.db.DeleteUnattachedMedia(ctx, int32(s.UnattachedTTL/time.Second)); err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Failed to delete unattached media", "error", err)
+		}
+		return
+	} else if deleted > 0 {
+		slog.InfoContext(ctx, "Deleted unattached media", "count", deleted)
+	}
+
+	for ctx.Err() == nil {
+		keys, err := s.db.ListMediaBlobDeletions(ctx, s.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Failed to list media blob deletions", "error", err)
+			}
+			return
+		}
+
+		deleted := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if err := s.blobs.Delete(ctx, key); err != nil {
+				slog.ErrorContext(ctx, "Failed to delete media file", "key", key, "error", err)
+				continue
+			}
+			deleted = append(deleted, key)
+		}
+		if len(deleted) > 0 {
+			if err := s.db.DeleteMediaBlobDeletions(ctx, deleted); err != nil {
+				slog.ErrorContext(ctx, "Failed to record media blob deletions", "error", err)
+				return
+			}
+		}
+
+		// Stop once the queue is drained, or when every blob of a batch failed
+		// so that the same batch is not retried in a busy loop
+		if len(keys) < int(s.BatchSize) || len(deleted) == 0 {
+			return
+		}
+	}
+}
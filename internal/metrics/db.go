@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+)
+
+// instrumentedDB wraps a database.DBTX and observes the duration of every
+// query in the DBQueryDuration histogram.
+type instrumentedDB struct {
+	db      database.DBTX
+	metrics *Metrics
+}
+
+// InstrumentDB wraps db so that the duration of every query is recorded,
+// labelled with the sqlc query name.
+func (m *Metrics) InstrumentDB(db database.DBTX) database.DBTX {
+	if m == nil {
+		return db
+	}
+	return &instrumentedDB{db: db, metrics: m}
+}
+
+func (i *instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := i.db.ExecContext(ctx, query, args...)
+	i.observe(query, start, err)
+	return result, err
+}
+
+func (i *instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := i.db.PrepareContext(ctx, query)
+	i.observe(query, start, err)
+	return stmt, err
+}
+
+func (i *instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	i.observe(query, start, err)
+	return rows, err
+}
+
+func (i *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := i.db.QueryRowContext(ctx, query, args...)
+	i.observe(query, start, row.Err())
+	return row
+}
+
+func (i *instrumentedDB) observe(query string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		outcome = "error"
+	}
+	i.metrics.DBQueryDuration.WithLabelValues(database.QueryName(query), outcome).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Metrics holds the Prometheus registry and every collector Chirpy exposes. All
+// methods are safe to call on a nil *Metrics, in which case they do nothing.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	FileserverHits  prometheus.Counter
+	HTTPRequests    *prometheus.CounterVec
+	HTTPDuration    *prometheus.HistogramVec
+	DBQueryDuration *prometheus.HistogramVec
+	AuthFailures    *prometheus.CounterVec
+	ChirpsCreated   prometheus.Counter
+
+	StreamConnections prometheus.Gauge
+	StreamDisconnects *prometheus.CounterVec
+
+	WebSocketConnections prometheus.Gauge
+	WebSocketDisconnects *prometheus.CounterVec
+}
+
+// New creates a registry with the Go runtime and process collectors and all of
+// Chirpy's own collectors registered.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		FileserverHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_fileserver_hits_total",
+			Help: "Number of requests served by the /app/ file server.",
+		}),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "HTTP request latency by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		DBQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_db_query_duration_seconds",
+			Help:    "Database query latency by query name and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"query", "outcome"}),
+		AuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_auth_failures_total",
+			Help: "Number of rejected authentication attempts by reason.",
+		}, []string{"reason"}),
+		ChirpsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_chirps_created_total",
+			Help: "Number of chirps created.",
+		}),
+		StreamConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chirpy_stream_connections",
+			Help: "Number of open real-time stream connections.",
+		}),
+		StreamDisconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_stream_disconnects_total",
+			Help: "Number of closed real-time stream connections by reason.",
+		}, []string{"reason"}),
+		WebSocketConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chirpy_websocket_connections",
+			Help: "Number of open WebSocket connections.",
+		}),
+		WebSocketDisconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_websocket_disconnects_total",
+			Help: "Number of closed WebSocket connections by reason.",
+		}, []string{"reason"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.FileserverHits,
+		m.HTTPRequests,
+		m.HTTPDuration,
+		m.DBQueryDuration,
+		m.AuthFailures,
+		m.ChirpsCreated,
+		m.StreamConnections,
+		m.StreamDisconnects,
+		m.WebSocketConnections,
+		m.WebSocketDisconnects,
+	)
+
+	return m
+}
+
+// Handler returns an http.Handler that serves the registry in the Prometheus
+// text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// AuthFailure counts a rejected authentication attempt with the given reason.
+func (m *Metrics) AuthFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.AuthFailures.WithLabelValues(reason).Inc()
+}
+
+// ChirpCreated counts a created chirp.
+func (m *Metrics) ChirpCreated() {
+	if m == nil {
+		return
+	}
+	m.ChirpsCreated.Inc()
+}
+
+// StreamOpened counts an opened real-time stream connection.
+func (m *Metrics) StreamOpened() {
+	if m == nil {
+		return
+	}
+	m.StreamConnections.Inc()
+}
+
+// StreamClosed counts a closed real-time stream connection, with the reason it
+// was closed for.
+func (m *Metrics) StreamClosed(reason string) {
+	if m == nil {
+		return
+	}
+	m.StreamConnections.Dec()
+	m.StreamDisconnects.WithLabelValues(reason).Inc()
+}
+
+// WebSocketOpened counts an opened WebSocket connection.
+func (m *Metrics) WebSocketOpened() {
+	if m == nil {
+		return
+	}
+	m.WebSocketConnections.Inc()
+}
+
+// WebSocketClosed counts a closed WebSocket connection, with the reason it was
+// closed for.
+func (m *Metrics) WebSocketClosed(reason string) {
+	if m == nil {
+		return
+	}
+	m.WebSocketConnections.Dec()
+	m.WebSocketDisconnects.WithLabelValues(reason).Inc()
+}
+
+// FileserverHit counts a request served by the file server.
+func (m *Metrics) FileserverHit() {
+	if m == nil {
+		return
+	}
+	m.FileserverHits.Inc()
+}
+
+// Value returns the current value of a counter, or 0 if it cannot be read.
+func Value(counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	if counter == nil || counter.Write(&metric) != nil {
+		return 0
+	}
+	return metric.GetCounter().GetValue()
+}
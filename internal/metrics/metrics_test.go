@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.FileserverHit()
+	m.FileserverHit()
+	m.AuthFailure("invalid_token")
+	m.StreamOpened()
+	m.StreamOpened()
+	m.StreamClosed("slow_consumer")
+	m.WebSocketOpened()
+	m.WebSocketClosed("token_expired")
+
+	if got := Value(m.FileserverHits); got != 2 {
+		t.Fatalf("Expected 2 file server hits, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"chirpy_fileserver_hits_total 2",
+		`chirpy_auth_failures_total{reason="invalid_token"} 1`,
+		"chirpy_stream_connections 1",
+		`chirpy_stream_disconnects_total{reason="slow_consumer"} 1`,
+		"chirpy_websocket_connections 0",
+		`chirpy_websocket_disconnects_total{reason="token_expired"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.FileserverHit()
+	m.AuthFailure("invalid_token")
+	m.ChirpCreated()
+	m.StreamOpened()
+	m.StreamClosed("client_gone")
+	m.WebSocketOpened()
+	m.WebSocketClosed("client_gone")
+}
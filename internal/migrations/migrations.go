@@ -0,0 +1,120 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+
+	"github.com/Fepozopo/chirpy/sql/schema"
+)
+
+// ErrSchemaBehind is returned by Check when the database has not been migrated
+// to the newest migration embedded in the binary.
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+// Migrator applies the migrations embedded from sql/schema. Migrations that
+// change the schema hold a Postgres advisory lock, so that several instances
+// starting with auto-migration enabled apply them only once.
+type Migrator struct {
+	provider *goose.Provider
+}
+
+// New returns a Migrator for the given database. Results of applied migrations
+// are reported by the caller, so goose itself logs nothing.
+func New(db *sql.DB) (*Migrator, error) {
+	locker, err := lock.NewPostgresSessionLocker(
+		// Retry every 5 seconds for up to 5 minutes
+		lock.WithLockTimeout(5, 60),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration lock: %w", err)
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, schema.FS,
+		goose.WithSessionLocker(locker),
+		goose.WithDisableGlobalRegistry(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return &Migrator{provider: provider}, nil
+}
+
+// Target returns the version of the newest embedded migration.
+func (m *Migrator) Target() int64 {
+	sources := m.provider.ListSources()
+	if len(sources) == 0 {
+		return 0
+	}
+	return sources[len(sources)-1].Version
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, error) {
+	return m.provider.Up(ctx)
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) (*goose.MigrationResult, error) {
+	return m.provider.Down(ctx)
+}
+
+// Redo rolls back the most recently applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) ([]*goose.MigrationResult, error) {
+	down, err := m.provider.Down(ctx)
+	if err != nil {
+		return nil, err
+	}
+	up, err := m.provider.UpByOne(ctx)
+	if err != nil {
+		return []*goose.MigrationResult{down}, err
+	}
+	return []*goose.MigrationResult{down, up}, nil
+}
+
+// Versions returns the version of the newest migration applied to the database
+// and the version of the newest embedded migration.
+func (m *Migrator) Versions(ctx context.Context) (current, target int64, err error) {
+	return m.provider.GetVersions(ctx)
+}
+
+// Check returns an error wrapping ErrSchemaBehind if the database is missing
+// any of the embedded migrations. A database that is ahead of the binary, as
+// during a rolling deployment, passes the check.
+func (m *Migrator) Check(ctx context.Context) error {
+	current, target, err := m.Versions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if current < target {
+		return fmt.Errorf("%w: schema version is %d, expected %d", ErrSchemaBehind, current, target)
+	}
+	return nil
+}
+
+// PrintStatus writes a table of every embedded migration and when it was
+// applied to w.
+func (m *Migrator) PrintStatus(ctx context.Context, w io.Writer) error {
+	statuses, err := m.provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tMIGRATION\tSTATE\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if !status.AppliedAt.IsZero() {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.Source.Version, status.Source.Path, status.State, appliedAt)
+	}
+	return tw.Flush()
+}
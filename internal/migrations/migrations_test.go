@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"database/sql"
+	"io/fs"
+	"testing"
+
+	_ "github.com/lib/pq"
+
+	"github.com/Fepozopo/chirpy/sql/schema"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	// Loading the migrations does not connect to the database
+	db, err := sql.Open("postgres", "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	files, err := fs.Glob(schema.FS, "*.sql")
+	if err != nil {
+		t.Fatalf("Failed to list migrations: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("Expected migrations to be embedded")
+	}
+	if got := migrator.Target(); got != int64(len(files)) {
+		t.Errorf("Expected the target version to be %d, got %d", len(files), got)
+	}
+}
@@ -0,0 +1,238 @@
+// Package stream fans events out to the clients of the real-time APIs.
+// A Broker keeps a bounded buffer of recent events, so that clients that
+// reconnect can resume where they left off, and disconnects clients that do not
+// keep up rather than letting them slow down publishers.
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the published events.
+const (
+	EventChirpCreated   = "chirp.created"
+	EventChirpDeleted   = "chirp.deleted"
+	EventUserFollowed   = "user.followed"
+	EventUserUnfollowed = "user.unfollowed"
+	EventUserUpgraded   = "user.upgraded"
+	EventMessageCreated = "message.created"
+
+	EventNotificationCreated = "notification.created"
+
+	// Events about blocks and mutes only update the streams of the users
+	// involved and are never sent to clients.
+	EventUserBlocked   = "user.blocked"
+	EventUserUnblocked = "user.unblocked"
+	EventUserMuted     = "user.muted"
+	EventUserUnmuted   = "user.unmuted"
+)
+
+// Default sizes of the replay buffer and of the queue of each subscriber.
+const (
+	DefaultReplaySize = 1000
+	DefaultQueueSize  = 64
+)
+
+// Visibilities of chirps. Chirps that are not public only reach the users who
+// may see them.
+const (
+	VisibilityPublic    = "public"
+	VisibilityUnlisted  = "unlisted"
+	VisibilityFollowers = "followers"
+	VisibilityMentioned = "mentioned"
+)
+
+// Event is a published event. IDs increase with every event, and are shared by
+// the brokers of all instances when an event bus assigns them. AuthorID is the
+// user who caused the event, and Recipients are the users it is addressed to,
+// such as the users mentioned in a chirp or the user who was followed.
+// Visibility is the visibility of a chirp to everyone but its author and
+// recipients, where the chirps of protected users are followers-only; empty
+// means public.
+type Event struct {
+	ID         uint64
+	Type       string
+	AuthorID   uuid.UUID
+	Recipients []uuid.UUID
+	Visibility string
+	Data       []byte
+}
+
+// IsChirp reports whether the event is about a chirp.
+func (e Event) IsChirp() bool {
+	return e.Type == EventChirpCreated || e.Type == EventChirpDeleted
+}
+
+// IsPublicChirp reports whether the event is about a chirp listed for anyone.
+func (e Event) IsPublicChirp() bool {
+	return e.IsChirp() && (e.Visibility == "" || e.Visibility == VisibilityPublic)
+}
+
+// IsOpenChirp reports whether the event is about a chirp anyone may see, which
+// includes the unlisted ones that are only shown with their author's chirps.
+func (e Event) IsOpenChirp() bool {
+	return e.IsPublicChirp() || (e.IsChirp() && e.Visibility == VisibilityUnlisted)
+}
+
+// ReachesFollower reports whether the user, who is the author of the chirp or
+// follows them, may see the chirp the event is about: they may unless it is
+// only for the users it mentions.
+func (e Event) ReachesFollower(userID uuid.UUID) bool {
+	return e.IsChirp() && (e.Visibility != VisibilityMentioned || e.AuthorID == userID || e.IsFor(userID))
+}
+
+// IsFor reports whether the event is addressed to the user.
+func (e Event) IsFor(userID uuid.UUID) bool {
+	for _, id := range e.Recipients {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects the events a subscriber receives.
+type Filter func(Event) bool
+
+// Broker is an in-process publish/subscribe hub. All methods are safe to call
+// on a nil *Broker, which drops published events and has no subscribers.
+type Broker struct {
+	queueSize int
+
+	mu          sync.Mutex
+	lastID      uint64
+	replay      []Event // ring buffer of the most recent events
+	next        int     // index in replay the next event is stored at
+	size        int     // number of events in replay
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker returns a broker that keeps the last replaySize events for
+// resuming subscribers and queues up to queueSize events per subscriber.
+// Non-positive sizes select the defaults.
+func NewBroker(replaySize, queueSize int) *Broker {
+	if replaySize <= 0 {
+		replaySize = DefaultReplaySize
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Broker{
+		queueSize: queueSize,
+		// IDs start from the current time, so that they keep increasing across
+		// restarts and an ID from before a restart is recognized as too old
+		lastID:      uint64(time.Now().UnixMicro()),
+		replay:      make([]Event, replaySize),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish delivers an event to every subscriber whose filter matches. An event
+// without an ID is assigned the next one; an event bus that numbers events for
+// all instances passes them with their ID. It never blocks: subscribers whose
+// queue is full are disconnected with ErrSlowConsumer. It returns the event
+// with its ID.
+func (b *Broker) Publish(event Event) Event {
+	if b == nil {
+		return event
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event.ID == 0 {
+		b.lastID++
+		event.ID = b.lastID
+	} else {
+		b.lastID = max(b.lastID, event.ID)
+	}
+	b.replay[b.next] = event
+	b.next = (b.next + 1) % len(b.replay)
+	b.size = min(b.size+1, len(b.replay))
+
+	for sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.drop(sub, ErrSlowConsumer)
+		}
+	}
+	return event
+}
+
+// Subscribe registers a subscriber for the events matching filter, which may
+// be nil to receive every event. If lastID is not zero, the matching events
+// published after it are returned to be sent before the subscription's
+// events; if some of them are no longer buffered, complete is false and the
+// subscriber has missed events.
+func (b *Broker) Subscribe(filter Filter, lastID uint64) (sub *Subscription, replay []Event, complete bool) {
+	sub = &Subscription{
+		events: make(chan Event, b.queueSizeOrDefault()),
+		done:   make(chan struct{}),
+		filter: filter,
+		broker: b,
+	}
+	if b == nil {
+		return sub, nil, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = true
+	if lastID != 0 {
+		// The buffer covers the events after oldest-1. Events from other
+		// instances may arrive out of order, so the bounds are the smallest and
+		// largest buffered IDs. An ID past newest comes from a broker that saw
+		// events this one did not, and cannot be resumed either.
+		oldest, newest := b.lastID+1, b.lastID
+		start := (b.next - b.size + len(b.replay)) % len(b.replay)
+		for i := 0; i < b.size; i++ {
+			event := b.replay[(start+i)%len(b.replay)]
+			if i == 0 || event.ID < oldest {
+				oldest = event.ID
+			}
+			if i == 0 || event.ID > newest {
+				newest = event.ID
+			}
+			if event.ID > lastID && (filter == nil || filter(event)) {
+				replay = append(replay, event)
+			}
+		}
+		complete = lastID+1 >= oldest && lastID <= newest
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub, replay, complete
+}
+
+func (b *Broker) queueSizeOrDefault() int {
+	if b == nil {
+		return DefaultQueueSize
+	}
+	return b.queueSize
+}
+
+// Subscribers returns the number of current subscribers.
+func (b *Broker) Subscribers() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// drop removes a subscriber and closes its done channel. The caller must hold
+// b.mu.
+func (b *Broker) drop(sub *Subscription, err error) {
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	sub.err = err
+	close(sub.done)
+}
@@ -0,0 +1,230 @@
+package stream
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	default:
+		t.Fatal("Expected an event to be queued")
+		return Event{}
+	}
+}
+
+func TestBrokerFilters(t *testing.T) {
+	b := NewBroker(10, 10)
+	alice, bob := uuid.New(), uuid.New()
+
+	all, _, _ := b.Subscribe(nil, 0)
+	onlyBob, _, _ := b.Subscribe(func(e Event) bool { return e.AuthorID == bob }, 0)
+	defer all.Close()
+	defer onlyBob.Close()
+
+	first := b.Publish(Event{Type: EventChirpCreated, AuthorID: alice, Data: []byte(`1`)})
+	second := b.Publish(Event{Type: EventChirpCreated, AuthorID: bob, Data: []byte(`2`)})
+	if second.ID != first.ID+1 {
+		t.Errorf("IDs %d and %d are not consecutive", first.ID, second.ID)
+	}
+
+	if got := receive(t, all); got.ID != first.ID {
+		t.Errorf("Expected event %d, got %d", first.ID, got.ID)
+	}
+	if got := receive(t, all); got.ID != second.ID {
+		t.Errorf("Expected event %d, got %d", second.ID, got.ID)
+	}
+	if got := receive(t, onlyBob); got.ID != second.ID {
+		t.Errorf("Expected event %d, got %d", second.ID, got.ID)
+	}
+	if len(onlyBob.Events()) != 0 {
+		t.Error("Filtered subscriber received another author's event")
+	}
+}
+
+func TestEventIsFor(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	event := Event{Type: EventChirpCreated, AuthorID: alice, Recipients: []uuid.UUID{bob}}
+	if !event.IsChirp() {
+		t.Error("expected a chirp event")
+	}
+	if !event.IsFor(bob) || event.IsFor(alice) {
+		t.Errorf("IsFor: want only the recipient, got bob=%v alice=%v", event.IsFor(bob), event.IsFor(alice))
+	}
+	if (Event{Type: EventUserFollowed}).IsChirp() {
+		t.Error("expected a follow event not to be a chirp event")
+	}
+	if !event.IsPublicChirp() || (Event{Type: EventChirpCreated, Visibility: VisibilityFollowers}).IsPublicChirp() {
+		t.Error("expected only the public chirp event to be public")
+	}
+}
+
+func TestEventVisibility(t *testing.T) {
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	tests := []struct {
+		visibility    string
+		public, open  bool
+		reachesCarol  bool
+		reachesBob    bool
+		reachesAuthor bool
+	}{
+		{"", true, true, true, true, true},
+		{VisibilityPublic, true, true, true, true, true},
+		{VisibilityUnlisted, false, true, true, true, true},
+		{VisibilityFollowers, false, false, true, true, true},
+		{VisibilityMentioned, false, false, false, true, true},
+	}
+	for _, tt := range tests {
+		event := Event{Type: EventChirpCreated, AuthorID: alice, Recipients: []uuid.UUID{bob}, Visibility: tt.visibility}
+		if got := event.IsPublicChirp(); got != tt.public {
+			t.Errorf("%q: IsPublicChirp = %v, want %v", tt.visibility, got, tt.public)
+		}
+		if got := event.IsOpenChirp(); got != tt.open {
+			t.Errorf("%q: IsOpenChirp = %v, want %v", tt.visibility, got, tt.open)
+		}
+		if got := event.ReachesFollower(carol); got != tt.reachesCarol {
+			t.Errorf("%q: ReachesFollower(follower) = %v, want %v", tt.visibility, got, tt.reachesCarol)
+		}
+		if got := event.ReachesFollower(bob); got != tt.reachesBob {
+			t.Errorf("%q: ReachesFollower(recipient) = %v, want %v", tt.visibility, got, tt.reachesBob)
+		}
+		if got := event.ReachesFollower(alice); got != tt.reachesAuthor {
+			t.Errorf("%q: ReachesFollower(author) = %v, want %v", tt.visibility, got, tt.reachesAuthor)
+		}
+	}
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(3, 10)
+	author := uuid.New()
+	var ids []uint64
+	for range 5 {
+		ids = append(ids, b.Publish(Event{Type: EventChirpCreated, AuthorID: author}).ID)
+	}
+
+	// The buffer holds the last three events
+	tests := []struct {
+		name         string
+		lastID       uint64
+		wantReplay   []uint64
+		wantComplete bool
+	}{
+		{name: "fresh", lastID: 0, wantComplete: true},
+		{name: "up to date", lastID: ids[4], wantComplete: true},
+		{name: "within buffer", lastID: ids[2], wantReplay: ids[3:], wantComplete: true},
+		{name: "oldest buffered", lastID: ids[1], wantReplay: ids[2:], wantComplete: true},
+		{name: "evicted", lastID: ids[0], wantReplay: ids[2:], wantComplete: false},
+		{name: "from the future", lastID: ids[4] + 100, wantComplete: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, complete := b.Subscribe(nil, tt.lastID)
+			defer sub.Close()
+			var got []uint64
+			for _, event := range replay {
+				got = append(got, event.ID)
+			}
+			if len(got) != len(tt.wantReplay) || complete != tt.wantComplete {
+				t.Fatalf("Expected %v (complete %t), got %v (complete %t)", tt.wantReplay, tt.wantComplete, got, complete)
+			}
+			for i := range got {
+				if got[i] != tt.wantReplay[i] {
+					t.Errorf("Expected %v, got %v", tt.wantReplay, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBrokerSharedIDs(t *testing.T) {
+	// Two instances receive the same bus-numbered events, the second one with
+	// two of them swapped
+	first, second := NewBroker(3, 10), NewBroker(3, 10)
+	author := uuid.New()
+	for _, id := range []uint64{11, 12, 13, 14} {
+		first.Publish(Event{ID: id, Type: EventChirpCreated, AuthorID: author})
+	}
+	for _, id := range []uint64{11, 12, 14, 13} {
+		if got := second.Publish(Event{ID: id, Type: EventChirpCreated, AuthorID: author}); got.ID != id {
+			t.Errorf("Publish assigned %d, want the bus's %d", got.ID, id)
+		}
+	}
+
+	// A client of the first instance resumes on the second one, which has
+	// evicted event 11 but buffered 12 to 14
+	tests := []struct {
+		name         string
+		lastID       uint64
+		wantReplay   []uint64
+		wantComplete bool
+	}{
+		{name: "within buffer", lastID: 12, wantReplay: []uint64{14, 13}, wantComplete: true},
+		{name: "oldest buffered", lastID: 11, wantReplay: []uint64{12, 14, 13}, wantComplete: true},
+		{name: "evicted", lastID: 10, wantReplay: []uint64{12, 14, 13}, wantComplete: false},
+		{name: "not yet received", lastID: 15, wantComplete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, replay, complete := second.Subscribe(nil, tt.lastID)
+			defer sub.Close()
+			var got []uint64
+			for _, event := range replay {
+				got = append(got, event.ID)
+			}
+			if !slices.Equal(got, tt.wantReplay) || complete != tt.wantComplete {
+				t.Errorf("Expected %v (complete %t), got %v (complete %t)", tt.wantReplay, tt.wantComplete, got, complete)
+			}
+		})
+	}
+}
+
+func TestBrokerDropsSlowConsumers(t *testing.T) {
+	b := NewBroker(10, 2)
+	slow, _, _ := b.Subscribe(nil, 0)
+	fast, _, _ := b.Subscribe(nil, 0)
+	defer fast.Close()
+
+	for range 3 {
+		b.Publish(Event{Type: EventChirpCreated, AuthorID: uuid.New()})
+		receive(t, fast)
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("Expected the slow subscriber to be disconnected")
+	}
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Errorf("Expected ErrSlowConsumer, got %v", slow.Err())
+	}
+	if fast.Err() != nil {
+		t.Errorf("Fast subscriber ended with %v", fast.Err())
+	}
+	if b.Subscribers() != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", b.Subscribers())
+	}
+
+	fast.Close()
+	fast.Close()
+	if !errors.Is(fast.Err(), ErrClosed) || b.Subscribers() != 0 {
+		t.Errorf("Expected the closed subscriber to be removed, got %v and %d subscribers", fast.Err(), b.Subscribers())
+	}
+}
+
+func TestNilBroker(t *testing.T) {
+	var b *Broker
+	b.Publish(Event{Type: EventChirpDeleted, AuthorID: uuid.New()})
+	sub, replay, complete := b.Subscribe(nil, 42)
+	if len(replay) != 0 || !complete || b.Subscribers() != 0 {
+		t.Errorf("Expected a nil broker to have nothing to replay")
+	}
+	sub.Close()
+	sub.Close()
+}
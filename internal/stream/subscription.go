@@ -0,0 +1,57 @@
+package stream
+
+import "errors"
+
+var (
+	// ErrSlowConsumer ends subscriptions that fell too far behind.
+	ErrSlowConsumer = errors.New("subscriber is too slow")
+	// ErrClosed ends subscriptions that were closed by their owner.
+	ErrClosed = errors.New("subscription closed")
+)
+
+// Subscription receives the events published to a Broker until it is closed,
+// by its owner or by the broker.
+type Subscription struct {
+	events chan Event
+	done   chan struct{}
+	err    error // set before done is closed
+	filter Filter
+	broker *Broker
+}
+
+// Events returns the channel the subscription's events are delivered on. It is
+// never closed; select on Done as well.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done returns a channel that is closed when the subscription ends.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, once Done is closed.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	if s.broker == nil {
+		select {
+		case <-s.done:
+		default:
+			s.err = ErrClosed
+			close(s.done)
+		}
+		return
+	}
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s, ErrClosed)
+}
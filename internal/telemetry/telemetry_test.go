@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakeDB is a database.DBTX whose ExecContext returns the configured error.
+type fakeDB struct {
+	err error
+}
+
+func (f fakeDB) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, f.err
+}
+
+func (f fakeDB) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, f.err
+}
+
+func (f fakeDB) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, f.err
+}
+
+func (f fakeDB) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return nil
+}
+
+func TestSetup(t *testing.T) {
+	for _, exporter := range []string{"", ExporterNone, ExporterStdout} {
+		shutdown, err := Setup(context.Background(), exporter, "chirpy-test")
+		if err != nil {
+			t.Fatalf("Failed to set up %q exporter: %v", exporter, err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatalf("Failed to shut down %q exporter: %v", exporter, err)
+		}
+	}
+
+	if _, err := Setup(context.Background(), "zipkin", "chirpy-test"); err == nil {
+		t.Fatal("Setup should have returned an error for an unknown exporter")
+	}
+}
+
+func TestWrapDB(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	WrapDB(fakeDB{}).ExecContext(ctx, "-- name: DeleteChirp :exec\nDELETE FROM chirps WHERE id = $1")
+	WrapDB(fakeDB{err: errors.New("connection refused")}).ExecContext(ctx, "-- name: DeleteAllUsers :exec\nDELETE FROM users")
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "db DeleteChirp" {
+		t.Fatalf("Expected span name %q, got %q", "db DeleteChirp", spans[0].Name())
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("Query span should be a child of the span in the context")
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Fatal("Failed query span should have an error status")
+	}
+}
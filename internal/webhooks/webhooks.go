@@ -0,0 +1,381 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+)
+
+// Event types that can be subscribed to.
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+	EventUserUpgraded = "user.upgraded"
+)
+
+// EventTypes lists every event type that can be subscribed to.
+var EventTypes = []string{EventChirpCreated, EventChirpDeleted, EventUserUpgraded}
+
+// IsEventType reports whether the given string is a known event type.
+func IsEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if eventType == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "Chirpy-Event"
+	HeaderDelivery  = "Chirpy-Delivery"
+	HeaderSignature = "Chirpy-Signature"
+)
+
+// Envelope is the JSON body of a webhook delivery.
+type Envelope struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// NewSecret generates a new random signing secret for a subscription.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random data: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the value of the Chirpy-Signature header for the given body. The
+// signature is the hex-encoded HMAC-SHA256 of "<timestamp>.<body>" keyed with the
+// subscription secret, so receivers can reject replayed deliveries by checking
+// the timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns how long to wait before the next attempt after the given
+// number of failed attempts. The delay doubles with every attempt, starting at
+// base and capped at one hour.
+func Backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= time.Hour {
+			return time.Hour
+		}
+	}
+	return delay
+}
+
+// ErrForbiddenAddress is returned for webhook URLs and connections to loopback,
+// private, link-local, multicast, unspecified or other special-purpose
+// addresses, which would let subscribers reach the server's own network.
+var ErrForbiddenAddress = errors.New("address is not publicly routable")
+
+// forbiddenPrefixes are the special-purpose ranges that the netip.Addr
+// predicates do not cover.
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // This network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, and the broadcast address
+	netip.MustParsePrefix("::/96"),          // IPv4-compatible
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),       // Discard-only
+	netip.MustParsePrefix("2001::/32"),      // Teredo, which embeds an obfuscated IPv4 address
+}
+
+// Prefixes of IPv6 addresses that embed an IPv4 address, which is checked in
+// their place
+var (
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// IsPublicAddr reports whether deliveries may be sent to the address.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.Is6() {
+		bytes := addr.As16()
+		switch {
+		case nat64Prefix.Contains(addr):
+			addr = netip.AddrFrom4([4]byte(bytes[12:16]))
+		case sixToFourPrefix.Contains(addr):
+			addr = netip.AddrFrom4([4]byte(bytes[2:6]))
+		}
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsUnspecified() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast()
+}
+
+// CheckURL returns ErrForbiddenAddress if the URL names localhost or an address
+// deliveries may not be sent to. Host names are only resolved when connecting,
+// where NewClient checks the address again.
+func CheckURL(rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !IsPublicAddr(addr) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client for deliveries that refuses to connect to
+// addresses that are not public, checked after name resolution so that DNS
+// cannot point a subscription at the server's network, and does not follow
+// redirects.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Dispatcher records outbound webhook deliveries and sends them asynchronously.
+// Deliveries are stored in the webhook_deliveries table, so pending deliveries
+// survive a restart and are retried with exponential backoff until they succeed
+// or run out of attempts.
+type Dispatcher struct {
+	db     *database.Queries
+	client *http.Client
+	wake   chan struct{}
+
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	PollInterval time.Duration
+	BatchSize    int32
+}
+
+// NewDispatcher returns a Dispatcher that stores deliveries with the given
+// queries.
+func NewDispatcher(db *database.Queries) *Dispatcher {
+	return &Dispatcher{
+		db:           db,
+		client:       NewClient(10 * time.Second),
+		wake:         make(chan struct{}, 1),
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		PollInterval: 5 * time.Second,
+		BatchSize:    20,
+	}
+}
+
+// Emit records a delivery of the event for every active subscription to the
+// event type. If userID is valid, only subscriptions owned by that user receive
+// the event. The deliveries are sent in the background by Run.
+func (d *Dispatcher) Emit(ctx context.Context, eventType string, userID uuid.NullUUID, data any) error {
+	subscriptions, err := d.db.GetWebhookSubscriptionsForEvent(ctx, database.GetWebhookSubscriptionsForEventParams{
+		EventType: eventType,
+		UserID:    userID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find subscriptions: %w", err)
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(Envelope{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	for _, subscription := range subscriptions {
+		_, err := d.db.CreateWebhookDelivery(ctx, database.CreateWebhookDeliveryParams{
+			SubscriptionID: subscription.ID,
+			EventType:      eventType,
+			Payload:        payload,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record delivery: %w", err)
+		}
+	}
+
+	// Wake the delivery loop without blocking if it is already awake
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends due deliveries until the context is cancelled. It polls for due
+// deliveries every PollInterval and whenever Emit records a new one. A delivery
+// that is being sent when the context is cancelled is finished and recorded,
+// within the client timeout, so that it is not sent again; the rest of its
+// batch is left to be claimed again after the lease.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue claims a batch of due deliveries and sends them.
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	// Claimed deliveries are leased past the client timeout, so that they are
+	// retried if this instance dies before recording the outcome.
+	lease := int32(2 * d.client.Timeout / time.Second)
+	deliveries, err := d.db.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+		LeaseSeconds: lease,
+		BatchSize:    d.BatchSize,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Failed to claim webhook deliveries", "error", err)
+		}
+		return
+	}
+
+	sendCtx := context.WithoutCancel(ctx)
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(sendCtx, delivery)
+	}
+}
+
+// deliver sends a single delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery database.WebhookDelivery) {
+	subscription, err := d.db.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find webhook subscription", "subscription_id", delivery.SubscriptionID, "error", err)
+		return
+	}
+
+	status, err := Send(ctx, d.client, subscription.Url, subscription.Secret, delivery)
+	responseStatus := sql.NullInt32{Int32: int32(status), Valid: status != 0}
+	if err == nil {
+		if err := d.db.MarkWebhookDeliverySucceeded(ctx, database.MarkWebhookDeliverySucceededParams{
+			ID:             delivery.ID,
+			ResponseStatus: responseStatus,
+		}); err != nil {
+			slog.ErrorContext(ctx, "Failed to record webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	lastError := sql.NullString{String: err.Error(), Valid: true}
+	if int(delivery.Attempts) >= d.MaxAttempts {
+		err = d.db.MarkWebhookDeliveryFailed(ctx, database.MarkWebhookDeliveryFailedParams{
+			ID:             delivery.ID,
+			ResponseStatus: responseStatus,
+			LastError:      lastError,
+		})
+	} else {
+		err = d.db.MarkWebhookDeliveryRetry(ctx, database.MarkWebhookDeliveryRetryParams{
+			DelaySeconds:   int32(Backoff(d.BaseBackoff, int(delivery.Attempts)) / time.Second),
+			ResponseStatus: responseStatus,
+			LastError:      lastError,
+			ID:             delivery.ID,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+// Send posts a delivery to the given URL, signed with the subscription secret.
+// It returns the response status code, or 0 if no response was received, and an
+// error unless the receiver responded with a 2xx status code.
+func Send(ctx context.Context, client *http.Client, url, secret string, delivery database.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderSignature, Sign(secret, time.Now(), delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
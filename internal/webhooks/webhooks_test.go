@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Fepozopo/chirpy/internal/database"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"chirp.created"}`)
+	timestamp := time.Unix(1700000000, 0)
+
+	signature := Sign("mySecret", timestamp, body)
+
+	mac := hmac.New(sha256.New, []byte("mySecret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := "t=1700000000,v1=" + hex.EncodeToString(mac.Sum(nil))
+	if signature != expected {
+		t.Fatalf("Sign() = %q, want %q", signature, expected)
+	}
+
+	if Sign("otherSecret", timestamp, body) == signature {
+		t.Fatal("Signatures with different secrets should not match")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := 30 * time.Second
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(base, tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%v, %d) = %v, want %v", base, tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	delivery := database.WebhookDelivery{
+		ID:        uuid.New(),
+		EventType: EventChirpCreated,
+		Payload:   []byte(`{"type":"chirp.created"}`),
+	}
+
+	var gotSignature, gotEvent, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(HeaderSignature)
+		gotEvent = r.Header.Get(HeaderEvent)
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	status, err := Send(context.Background(), server.Client(), server.URL, "mySecret", delivery)
+	if err != nil {
+		t.Fatalf("Failed to send delivery: %v", err)
+	}
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, status)
+	}
+	if gotEvent != EventChirpCreated {
+		t.Fatalf("Expected event header %q, got %q", EventChirpCreated, gotEvent)
+	}
+	if gotBody != string(delivery.Payload) {
+		t.Fatalf("Expected body %q, got %q", delivery.Payload, gotBody)
+	}
+	if !strings.HasPrefix(gotSignature, "t=") || !strings.Contains(gotSignature, ",v1=") {
+		t.Fatalf("Unexpected signature header %q", gotSignature)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	status, err = Send(context.Background(), failing.Client(), failing.URL, "mySecret", delivery)
+	if err == nil {
+		t.Fatal("Send should have returned an error")
+	}
+	if status != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, status)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://example.com/hook", nil},
+		{"http://93.184.216.34/hook", nil},
+		{"http://localhost:5432", ErrForbiddenAddress},
+		{"http://api.localhost./hook", ErrForbiddenAddress},
+		{"http://127.0.0.1/hook", ErrForbiddenAddress},
+		{"http://169.254.169.254/latest/meta-data", ErrForbiddenAddress},
+		{"http://10.0.0.1/hook", ErrForbiddenAddress},
+		{"http://192.168.1.1/hook", ErrForbiddenAddress},
+		{"http://0.0.0.0/hook", ErrForbiddenAddress},
+		{"http://[::1]/hook", ErrForbiddenAddress},
+		{"http://[fe80::1]/hook", ErrForbiddenAddress},
+		{"http://[::ffff:127.0.0.1]/hook", ErrForbiddenAddress},
+		{"http://100.64.0.1/hook", ErrForbiddenAddress},
+		{"http://100.127.255.254/hook", ErrForbiddenAddress},
+		{"http://100.128.0.1/hook", nil},
+		{"http://0.1.2.3/hook", ErrForbiddenAddress},
+		{"http://198.18.0.1/hook", ErrForbiddenAddress},
+		{"http://255.255.255.255/hook", ErrForbiddenAddress},
+		{"http://[::127.0.0.1]/hook", ErrForbiddenAddress},
+		{"http://[64:ff9b::10.0.0.1]/hook", ErrForbiddenAddress},
+		{"http://[64:ff9b::a9fe:a9fe]/hook", ErrForbiddenAddress},
+		{"http://[64:ff9b::93.184.216.34]/hook", nil},
+		{"http://[2002:7f00:1::]/hook", ErrForbiddenAddress},
+		{"http://[2002:c0a8:101::1]/hook", ErrForbiddenAddress},
+		{"http://[2002:5db8:d822::1]/hook", nil},
+		{"http://[2001:0:4136:e378::1]/hook", ErrForbiddenAddress},
+		{"http://[2606:4700:4700::1111]/hook", nil},
+	}
+	for _, tt := range tests {
+		if err := CheckURL(tt.url); !errors.Is(err, tt.want) {
+			t.Errorf("CheckURL(%q) = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestNewClientRefusesPrivateAddresses(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	delivery := database.WebhookDelivery{ID: uuid.New(), EventType: EventChirpCreated, Payload: []byte(`{}`)}
+	status, err := Send(context.Background(), NewClient(time.Second), server.URL, "mySecret", delivery)
+	if !errors.Is(err, ErrForbiddenAddress) || status != 0 || called {
+		t.Fatalf("Expected the loopback server to be refused, got status %d and %v", status, err)
+	}
+}
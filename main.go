@@ -1,14 +1,29 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	api "github.com/Fepozopo/chirpy/api"
+	"github.com/Fepozopo/chirpy/internal/auth"
+	"github.com/Fepozopo/chirpy/internal/blobstore"
+	"github.com/Fepozopo/chirpy/internal/config"
 	database "github.com/Fepozopo/chirpy/internal/database"
-	"github.com/joho/godotenv"
+	"github.com/Fepozopo/chirpy/internal/eventbus"
+	"github.com/Fepozopo/chirpy/internal/media"
+	"github.com/Fepozopo/chirpy/internal/metrics"
+	"github.com/Fepozopo/chirpy/internal/migrations"
+	"github.com/Fepozopo/chirpy/internal/stream"
+	"github.com/Fepozopo/chirpy/internal/telemetry"
+	"github.com/Fepozopo/chirpy/internal/webhooks"
 	_ "github.com/lib/pq"
 )
 
@@ -17,64 +32,318 @@ func main() {
 }
 
 func mainHelper() int {
-	filepathRoot := "./app"
-	port := "8080"
+	configFile := flag.String("config", os.Getenv("CHIRPY_CONFIG"), "path to an optional YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	autoMigrate := flag.Bool("auto-migrate", false, "apply pending migrations before serving")
+	flag.Usage = usage
+	flag.Parse()
 
-	// Open a connection to the database and environment variables
-	godotenv.Load()
-	tokenSecret := os.Getenv("TOKEN_SECRET")
-	dbURL := os.Getenv("DB_URL")
-	stripeKey := os.Getenv("STRIPE_KEY")
+	// Log structured JSON to stdout
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
 
-	db, err := sql.Open("postgres", dbURL)
+	// Load the configuration from defaults, the config file, .env and the environment
+	loader, err := config.NewLoader(*configFile, ".env")
 	if err != nil {
-		log.Printf("Failed to open a connection to the database: %v\n", err)
+		slog.Error("Failed to read configuration", "error", err)
+		return 1
+	}
+	var cfg config.Config
+	apiCfg := &api.ApiConfig{}
+	cfgErr := loader.Load(&cfg)
+	apiCfgErr := loader.Load(apiCfg)
+	if *printConfig {
+		loader.Print(os.Stdout)
+		if err := errors.Join(cfgErr, apiCfgErr); err != nil {
+			slog.Error("Invalid configuration", "error", err)
+			return 1
+		}
+		return 0
+	}
+
+	// Commands other than serve only need the server settings
+	command := flag.Arg(0)
+	if command == "" || command == "serve" {
+		cfgErr = errors.Join(cfgErr, apiCfgErr)
+	}
+	if cfgErr != nil {
+		slog.Error("Invalid configuration", "error", cfgErr)
+		return 1
+	}
+
+	switch command {
+	case "", "serve":
+		return serve(cfg, apiCfg, *autoMigrate)
+	case "migrate":
+		return runMigrate(cfg, flag.Args()[1:])
+	case "users":
+		return runUsers(cfg, flag.Args()[1:])
+	case "chirps":
+		return runChirps(cfg, flag.Args()[1:])
+	case "seed":
+		return runSeed(cfg, apiCfg.Platform, flag.Args()[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		usage()
+		return 2
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: chirpy [flags] [command]
+
+Commands:
+  serve                            run the API server (the default)
+  migrate up|down|status|redo      manage the database schema
+  users <command>                  create, list, delete and manage users
+  chirps delete <chirp ID>         delete a chirp
+  seed                             fill a development database with fake data
+
+Run a command without arguments for its usage.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// openDB opens the database and waits until it responds.
+func openDB(ctx context.Context, cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DBURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a connection to the database: %w", err)
+	}
+
+	// Wait for the database, which may still be starting up alongside the server
+	if err := database.Ping(ctx, db, cfg.DBPingAttempts, cfg.DBPingBackoff); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// newPasswordHasher builds the password hasher from the configuration.
+func newPasswordHasher(cfg config.Config) *auth.PasswordHasher {
+	hasher := auth.DefaultPasswordHasher()
+	hasher.Algorithm = cfg.PasswordHashAlgorithm
+	hasher.Argon2id.Memory = uint32(cfg.Argon2Memory)
+	hasher.Argon2id.Iterations = uint32(cfg.Argon2Iterations)
+	hasher.Argon2id.Parallelism = uint8(cfg.Argon2Parallelism)
+	hasher.BcryptCost = cfg.BcryptCost
+	return hasher
+}
+
+// newPasswordPolicy builds the password policy from the configuration. Passwords
+// longer than the configured hash algorithm supports are rejected.
+func newPasswordPolicy(cfg config.Config) (*auth.PasswordPolicy, error) {
+	policy := auth.DefaultPasswordPolicy()
+	policy.MinLength = cfg.PasswordMinLength
+	if maxBytes := newPasswordHasher(cfg).MaxPasswordBytes(); maxBytes > 0 {
+		policy.MaxBytes = maxBytes
+	}
+	policy.Blocklist = append(policy.Blocklist, cfg.PasswordBlocklist...)
+	policy.BreachedMinCount = cfg.BreachedPasswordsMinCount
+
+	switch {
+	case !cfg.BreachedPasswordsCheck:
+		policy.Breached = nil
+	case cfg.BreachedPasswordsFile != "":
+		corpus, err := auth.LoadBreachedCorpus(cfg.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = corpus
+	}
+	return policy, nil
+}
+
+// newBlobStore returns the store for uploaded media selected by MEDIA_STORE.
+func newBlobStore(cfg config.Config) (blobstore.BlobStore, error) {
+	if cfg.MediaStore == "s3" {
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required when MEDIA_STORE is s3")
+		}
+		return blobstore.NewS3(blobstore.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		}, nil)
+	}
+	return blobstore.NewLocal(cfg.MediaDir)
+}
+
+// newEventBus returns the event bus selected by EVENT_BUS. The Postgres bus
+// receives the events of the other instances until ctx is done; the returned
+// channel is closed once it stopped.
+func newEventBus(ctx context.Context, cfg config.Config, db *database.Queries, broker *stream.Broker) (eventbus.Bus, <-chan struct{}) {
+	done := make(chan struct{})
+	if cfg.EventBus != "postgres" {
+		close(done)
+		return eventbus.NewLocal(broker), done
+	}
+
+	bus := eventbus.NewPostgres(cfg.DBURL, db, broker)
+	go func() {
+		defer close(done)
+		if err := bus.Run(ctx); err != nil {
+			slog.Error("Event bus stopped, events of other instances are no longer received", "error", err)
+		}
+	}()
+	return bus, done
+}
+
+// serve runs the API server until SIGINT or SIGTERM. It refuses to start if the
+// database is missing any of the embedded migrations, applying them first if
+// autoMigrate is set.
+func serve(cfg config.Config, apiCfg *api.ApiConfig, autoMigrate bool) int {
+	// Cancel the context on SIGINT or SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Install the tracer provider before anything creates spans
+	shutdownTracing, err := telemetry.Setup(ctx, cfg.TracesExporter, "chirpy")
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		return 1
+	}
+	defer shutdownTracing(context.Background())
+
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		slog.Error("Failed to load the password policy", "error", err)
+		return 1
+	}
+
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		slog.Error("Failed to set up the media store", "error", err)
+		return 1
+	}
+
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to connect to the database", "error", err)
+		return 1
 	}
 	defer db.Close()
 
-	// Create a new Queries instance and initialize the ApiConfig struct
-	dbQueries := database.New(db)
-	apiCfg := &api.ApiConfig{
-		DbQueries:   dbQueries,
-		TokenSecret: tokenSecret,
-		StripeKey:   stripeKey,
+	migrator, err := migrations.New(db)
+	if err != nil {
+		slog.Error("Failed to load migrations", "error", err)
+		return 1
+	}
+	if autoMigrate {
+		results, err := migrator.Up(ctx)
+		for _, result := range results {
+			slog.Info("Applied migration", "migration", result.Source.Path, "duration", result.Duration)
+		}
+		if err != nil {
+			slog.Error("Failed to apply migrations", "error", err)
+			return 1
+		}
+	}
+	if err := migrator.Check(ctx); err != nil {
+		slog.Error("Refusing to serve, run `chirpy migrate up` or start with -auto-migrate", "error", err)
+		return 1
 	}
 
+	// Create the metrics registry and a Queries instance whose queries are timed and traced
+	appMetrics := metrics.New()
+	dbQueries := database.New(telemetry.WrapDB(appMetrics.InstrumentDB(db)))
+	// The dispatcher outlives the signal until the in-flight requests, which
+	// may emit deliveries, are drained
+	dispatcher := webhooks.NewDispatcher(dbQueries)
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	defer stopDispatcher()
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.Run(dispatcherCtx)
+	}()
+
+	// The sweeper deletes the blobs of media removed by cascade and of uploads
+	// that were never attached
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		media.NewSweeper(dbQueries, blobs).Run(ctx)
+	}()
+
+	apiCfg.DB = db
+	apiCfg.DbQueries = dbQueries
+	apiCfg.Migrations = migrator
+	apiCfg.PasswordPolicy = passwordPolicy
+	apiCfg.PasswordHasher = newPasswordHasher(cfg)
+	apiCfg.Webhooks = dispatcher
+	apiCfg.Blobs = blobs
+	apiCfg.MediaMaxBytes = cfg.MediaMaxBytes
+	apiCfg.ThumbnailSize = cfg.ThumbnailSize
+	broker := stream.NewBroker(cfg.StreamReplaySize, cfg.StreamQueueSize)
+	bus, busDone := newEventBus(ctx, cfg, dbQueries, broker)
+	apiCfg.Stream = broker
+	apiCfg.Events = bus
+	apiCfg.StreamHeartbeat = cfg.StreamHeartbeatInterval
+	apiCfg.Metrics = appMetrics
+
 	// Create a new ServeMux
 	mux := http.NewServeMux()
 
-	// Endpoints
-	mux.HandleFunc("GET /api/healthz", apiCfg.HandleHealthz)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.HandleMetrics)
-	mux.HandleFunc("POST /admin/reset", apiCfg.HandleReset)
-	mux.HandleFunc("POST /api/chirps", apiCfg.HandleCreateChirp)
-	mux.HandleFunc("POST /api/users", apiCfg.HandleCreateUser)
-	mux.HandleFunc("GET /api/chirps", apiCfg.HandleGetAllChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.HandleGetChirp)
-	mux.HandleFunc("POST /api/login", apiCfg.HandleLoginUser)
-	mux.HandleFunc("POST /api/refresh", apiCfg.HandleRefresh)
-	mux.HandleFunc("POST /api/revoke", apiCfg.HandleRevoke)
-	mux.HandleFunc("PUT /api/users", apiCfg.HandleUpdateUser)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.HandleDeleteChirp)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.HandleStripeEvent)
+	// Endpoints, all documented in the OpenAPI specification
+	for _, route := range apiCfg.Routes() {
+		mux.Handle(route.Pattern, route.Handler)
+	}
 
 	// Custom FileServer to handle /app/ path
-	fileServer := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	fileServer := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.FilepathRoot)))
 	mux.Handle("/app/", apiCfg.MiddlewareMetricsInc(fileServer))
 
 	// Create a new http.Server struct
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:              ":" + cfg.Port,
+		Handler:           apiCfg.MiddlewareRequestID(apiCfg.MiddlewareTracing(apiCfg.MiddlewareLogging(apiCfg.MiddlewareInstrument(mux)))),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
+	// Shutdown does not wait for WebSocket connections and would wait for event
+	// streams until the timeout; close them instead
+	server.RegisterOnShutdown(apiCfg.CloseWebSockets)
+	server.RegisterOnShutdown(apiCfg.CloseStreams)
 
 	// Start the server
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Printf("Server failed to start: %v\n", err)
-		return 1
+	slog.Info("Serving files", "root", cfg.FilepathRoot, "port", cfg.Port)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Run until a signal arrives or the server fails
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed to start", "error", err)
+		exitCode = 1
+		stop()
+	case <-ctx.Done():
+		stop()
+		slog.Info("Shutting down, draining in-flight requests", "timeout", cfg.ShutdownTimeout)
+
+		// Stop accepting connections and wait for in-flight requests to finish
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to drain in-flight requests", "error", err)
+			exitCode = 1
+		}
 	}
 
-	// No errors, return 0
-	return 0
+	// Stop the dispatcher, which finishes and records the delivery it is sending
+	stopDispatcher()
+	<-dispatcherDone
+	<-sweeperDone
+	<-busDone
+	slog.Info("Server stopped")
+
+	return exitCode
 }
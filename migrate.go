@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pressly/goose/v3"
+
+	"github.com/Fepozopo/chirpy/internal/config"
+	"github.com/Fepozopo/chirpy/internal/migrations"
+)
+
+const migrateUsage = "usage: chirpy migrate up|down|status|redo"
+
+// runMigrate runs the migrate command, which applies or rolls back the
+// migrations embedded from sql/schema.
+func runMigrate(cfg config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to connect to the database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		slog.Error("Failed to load migrations", "error", err)
+		return 1
+	}
+
+	var results []*goose.MigrationResult
+	switch args[0] {
+	case "up":
+		results, err = migrator.Up(ctx)
+	case "down":
+		var result *goose.MigrationResult
+		result, err = migrator.Down(ctx)
+		if result != nil {
+			results = append(results, result)
+		}
+	case "redo":
+		results, err = migrator.Redo(ctx)
+	case "status":
+		err = migrator.PrintStatus(ctx, os.Stdout)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	if err != nil {
+		slog.Error("Migration failed", "command", args[0], "error", err)
+		return 1
+	}
+	if args[0] == "up" && len(results) == 0 {
+		fmt.Println("No pending migrations")
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+-- name: BlockUser :execrows
+-- Blocks a user and ends the follows and follow requests between the two
+-- users.
+WITH unfollowed AS (
+    DELETE FROM follows
+    WHERE (follower_id = sqlc.arg(blocker_id) AND followee_id = sqlc.arg(blocked_id))
+       OR (follower_id = sqlc.arg(blocked_id) AND followee_id = sqlc.arg(blocker_id))
+), unrequested AS (
+    DELETE FROM follow_requests
+    WHERE (requester_id = sqlc.arg(blocker_id) AND target_id = sqlc.arg(blocked_id))
+       OR (requester_id = sqlc.arg(blocked_id) AND target_id = sqlc.arg(blocker_id))
+)
+INSERT INTO blocks (blocker_id, blocked_id, created_at)
+VALUES (sqlc.arg(blocker_id), sqlc.arg(blocked_id), NOW())
+ON CONFLICT (blocker_id, blocked_id) DO NOTHING;
+
+-- name: UnblockUser :execrows
+DELETE FROM blocks
+WHERE blocker_id = $1
+  AND blocked_id = $2;
+
+-- name: ListBlockedUsers :many
+SELECT users.id, users.username, users.display_name, blocks.created_at
+FROM blocks
+JOIN users ON users.id = blocks.blocked_id
+WHERE blocks.blocker_id = sqlc.arg(user_id)
+ORDER BY blocks.created_at DESC, users.id;
+
+-- name: MuteUser :execrows
+INSERT INTO mutes (muter_id, muted_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (muter_id, muted_id) DO NOTHING;
+
+-- name: UnmuteUser :execrows
+DELETE FROM mutes
+WHERE muter_id = $1
+  AND muted_id = $2;
+
+-- name: ListMutedUsers :many
+SELECT users.id, users.username, users.display_name, mutes.created_at
+FROM mutes
+JOIN users ON users.id = mutes.muted_id
+WHERE mutes.muter_id = $1
+ORDER BY mutes.created_at DESC, users.id;
+
+-- name: ListHiddenUsers :many
+-- Returns the users hidden from the user and why: 'block' for the users they
+-- blocked, 'blocked_by' for the users who blocked them and 'mute' for the users
+-- they muted.
+SELECT blocks.blocked_id AS hidden_id, 'block'::TEXT AS reason
+FROM blocks
+WHERE blocks.blocker_id = sqlc.arg(user_id)
+UNION ALL
+SELECT blocks.blocker_id AS hidden_id, 'blocked_by'::TEXT AS reason
+FROM blocks
+WHERE blocks.blocked_id = sqlc.arg(user_id)
+UNION ALL
+SELECT mutes.muted_id AS hidden_id, 'mute'::TEXT AS reason
+FROM mutes
+WHERE mutes.muter_id = sqlc.arg(user_id);
+
+-- name: IsBlockedWithAny :one
+-- Reports whether the user blocked, or was blocked by, any of the others.
+SELECT EXISTS (
+    SELECT 1
+    FROM blocks
+    WHERE (blocker_id = sqlc.arg(user_id) AND blocked_id = ANY(sqlc.arg(other_ids)::UUID[]))
+       OR (blocked_id = sqlc.arg(user_id) AND blocker_id = ANY(sqlc.arg(other_ids)::UUID[]))
+);
+
+-- name: AnyBlockedAmong :one
+-- Reports whether any of the users blocked another one of them.
+SELECT EXISTS (
+    SELECT 1
+    FROM blocks
+    WHERE blocker_id = ANY(sqlc.arg(user_ids)::UUID[])
+      AND blocked_id = ANY(sqlc.arg(user_ids)::UUID[])
+);
+
+-- name: FilterMentionable :many
+-- Returns the users among user_ids who may learn that the author mentioned
+-- them in a chirp with the visibility: the ones the author is not hidden from
+-- and, for followers-only chirps or protected authors, who follow the author.
+-- Chirps for mentioned users only reach every one of them.
+SELECT users.id
+FROM users
+WHERE users.id = ANY(sqlc.arg(user_ids)::UUID[])
+  AND NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = users.id AND hidden_users.hidden_id = sqlc.arg(author_id)
+  )
+  AND (sqlc.arg(visibility)::TEXT = 'mentioned'
+    OR (sqlc.arg(visibility)::TEXT <> 'followers'
+      AND NOT EXISTS (SELECT 1 FROM users AS authors WHERE authors.id = sqlc.arg(author_id) AND authors.is_protected))
+    OR EXISTS (
+      SELECT 1 FROM follows
+      WHERE follows.follower_id = users.id AND follows.followee_id = sqlc.arg(author_id)
+    ));
@@ -1,21 +1,50 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to_id, visibility, content_warning, sensitive)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
 )
 RETURNING *;
 
+-- name: CreateChirpMentions :exec
+INSERT INTO chirp_mentions (chirp_id, user_id)
+SELECT sqlc.arg(chirp_id), unnest(sqlc.arg(user_ids)::UUID[])
+ON CONFLICT (chirp_id, user_id) DO NOTHING;
+
+-- name: ListChirpMentions :many
+SELECT user_id FROM chirp_mentions
+WHERE chirp_id = $1;
+
 -- name: DeleteAllChirps :exec
 DELETE FROM chirps;
 
 -- name: GetAllChirps :many
+-- Leaves out the chirps viewer_id, if set, may not see and those of the users
+-- hidden from them. Unlisted chirps are only listed for their author.
+SELECT *
+FROM chirps
+WHERE NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = sqlc.narg(viewer_id) AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, sqlc.narg(viewer_id))
+  AND (chirps.visibility <> 'unlisted' OR chirps.user_id = sqlc.narg(viewer_id))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.narg(max_results) OFFSET sqlc.arg(skip);
+
+-- name: GetVisibleChirp :one
+-- Returns the chirp only if viewer_id, if set, may see it.
 SELECT *
 FROM chirps
-ORDER BY created_at ASC;
+WHERE chirps.id = sqlc.arg(id)
+  AND chirp_visible_to(chirps.id, sqlc.narg(viewer_id));
 
 -- name: GetChirp :one
 SELECT *
@@ -27,18 +56,43 @@ DELETE FROM chirps
 WHERE id = $1;
 
 -- name: GetUserChirps :many
+-- Leaves out the chirps viewer_id, if set, may not see and those of the users
+-- hidden from them.
 SELECT *
 FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
+WHERE chirps.user_id = sqlc.arg(user_id)
+  AND NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = sqlc.narg(viewer_id) AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, sqlc.narg(viewer_id))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.narg(max_results) OFFSET sqlc.arg(skip);
 
 -- name: GetAllChirpsDESC :many
+-- Leaves out the chirps viewer_id, if set, may not see and those of the users
+-- hidden from them. Unlisted chirps are only listed for their author.
 SELECT *
 FROM chirps
-ORDER BY created_at DESC;
+WHERE NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = sqlc.narg(viewer_id) AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, sqlc.narg(viewer_id))
+  AND (chirps.visibility <> 'unlisted' OR chirps.user_id = sqlc.narg(viewer_id))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.narg(max_results) OFFSET sqlc.arg(skip);
 
 -- name: GetUserChirpsDESC :many
+-- Leaves out the chirps viewer_id, if set, may not see and those of the users
+-- hidden from them.
 SELECT *
 FROM chirps
-WHERE user_id = $1
-ORDER BY created_at DESC;
+WHERE chirps.user_id = sqlc.arg(user_id)
+  AND NOT EXISTS (
+    SELECT 1 FROM hidden_users
+    WHERE hidden_users.user_id = sqlc.narg(viewer_id) AND hidden_users.hidden_id = chirps.user_id
+  )
+  AND chirp_visible_to(chirps.id, sqlc.narg(viewer_id))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.narg(max_results) OFFSET sqlc.arg(skip);
@@ -0,0 +1,18 @@
+-- name: NotifyEvent :exec
+SELECT pg_notify(sqlc.arg(channel)::TEXT, sqlc.arg(payload)::TEXT);
+
+-- name: CreateEventPayload :exec
+INSERT INTO event_payloads (id, created_at, payload)
+VALUES ($1, NOW(), $2);
+
+-- name: GetEventPayload :one
+SELECT payload
+FROM event_payloads
+WHERE id = $1;
+
+-- name: DeleteExpiredEventPayloads :execrows
+DELETE FROM event_payloads
+WHERE created_at < NOW() - INTERVAL '1 hour';
+
+-- name: NextEventID :one
+SELECT nextval('stream_event_ids')::BIGINT AS id;
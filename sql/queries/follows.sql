@@ -0,0 +1,81 @@
+-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (follower_id, followee_id) DO NOTHING;
+
+-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1
+  AND followee_id = $2;
+
+-- name: ListFolloweeIDs :many
+-- Returns the users the user follows, including the ones they muted, which
+-- ListHiddenUsers returns.
+SELECT followee_id
+FROM follows
+WHERE follower_id = $1;
+
+-- name: IsFollowing :one
+SELECT EXISTS (
+    SELECT 1
+    FROM follows
+    WHERE follower_id = $1
+      AND followee_id = $2
+);
+
+-- name: CreateFollowRequest :execrows
+INSERT INTO follow_requests (requester_id, target_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (requester_id, target_id) DO NOTHING;
+
+-- name: DeleteFollowRequest :execrows
+DELETE FROM follow_requests
+WHERE requester_id = $1
+  AND target_id = $2;
+
+-- name: ApproveFollowRequest :execrows
+-- Turns the request into a follow.
+WITH request AS (
+    DELETE FROM follow_requests
+    WHERE requester_id = $1
+      AND target_id = $2
+    RETURNING requester_id, target_id
+)
+INSERT INTO follows (follower_id, followee_id, created_at)
+SELECT requester_id, target_id, NOW()
+FROM request
+ON CONFLICT (follower_id, followee_id) DO NOTHING;
+
+-- name: ApproveAllFollowRequests :many
+-- Turns every request to follow the user into a follow, and returns the IDs
+-- of the new followers.
+WITH requests AS (
+    DELETE FROM follow_requests
+    WHERE target_id = $1
+    RETURNING requester_id, target_id
+)
+INSERT INTO follows (follower_id, followee_id, created_at)
+SELECT requester_id, target_id, NOW()
+FROM requests
+ON CONFLICT (follower_id, followee_id) DO NOTHING
+RETURNING follower_id;
+
+-- name: ListFollowRequests :many
+SELECT users.id, users.username, users.display_name, follow_requests.created_at
+FROM follow_requests
+JOIN users ON users.id = follow_requests.requester_id
+WHERE follow_requests.target_id = $1
+ORDER BY follow_requests.created_at DESC, users.id;
+
+-- name: AnyProtectedNotFollowed :one
+-- Reports whether any of the others is protected and not followed by the user.
+SELECT EXISTS (
+    SELECT 1
+    FROM users
+    WHERE users.id = ANY(sqlc.arg(other_ids)::UUID[])
+      AND users.is_protected
+      AND NOT EXISTS (
+        SELECT 1 FROM follows
+        WHERE follows.follower_id = sqlc.arg(user_id) AND follows.followee_id = users.id
+      )
+);
@@ -0,0 +1,74 @@
+-- name: CreateMedia :one
+INSERT INTO media (id, created_at, user_id, content_type, size, width, height, storage_key, thumbnail_content_type, thumbnail_key)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9
+)
+RETURNING *;
+
+-- name: GetMedia :one
+SELECT *
+FROM media
+WHERE id = $1;
+
+-- name: GetVisibleMedia :one
+-- Returns the media if the viewer, who is NULL when logged out, may see them:
+-- avatars are visible to everyone, the images of a chirp to whoever may see
+-- the chirp and other uploads only to their uploader. is_public tells whether
+-- everyone may see them.
+SELECT media.*,
+    (avatars.is_avatar OR (media.chirp_id IS NOT NULL AND chirp_visible_to(media.chirp_id, NULL)))::BOOLEAN AS is_public
+FROM media
+CROSS JOIN LATERAL (
+    SELECT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id) AS is_avatar
+) AS avatars
+WHERE media.id = sqlc.arg(id)
+  AND (avatars.is_avatar
+    OR (media.chirp_id IS NOT NULL AND chirp_visible_to(media.chirp_id, sqlc.narg(viewer_id)))
+    OR (media.chirp_id IS NULL AND media.user_id = sqlc.narg(viewer_id)));
+
+-- name: CountAttachableMedia :one
+SELECT COUNT(*)
+FROM media
+WHERE id = ANY(sqlc.arg(ids)::UUID[])
+  AND user_id = sqlc.arg(user_id)
+  AND chirp_id IS NULL
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id);
+
+-- name: AttachMedia :execrows
+UPDATE media
+SET chirp_id = sqlc.arg(chirp_id)
+WHERE id = ANY(sqlc.arg(ids)::UUID[])
+  AND user_id = sqlc.arg(user_id)
+  AND chirp_id IS NULL
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id);
+
+-- name: ListChirpMedia :many
+SELECT *
+FROM media
+WHERE chirp_id = ANY(sqlc.arg(chirp_ids)::UUID[])
+ORDER BY created_at ASC, id ASC;
+
+-- name: DeleteUnattachedMedia :execrows
+DELETE FROM media
+WHERE chirp_id IS NULL
+  AND created_at < NOW() - sqlc.arg(max_age_seconds)::int * INTERVAL '1 second'
+  AND NOT EXISTS (SELECT 1 FROM users WHERE users.avatar_media_id = media.id);
+
+-- name: ListMediaBlobDeletions :many
+SELECT storage_key
+FROM media_blob_deletions
+ORDER BY created_at ASC, storage_key ASC
+LIMIT sqlc.arg(batch_size);
+
+-- name: DeleteMediaBlobDeletions :exec
+DELETE FROM media_blob_deletions
+WHERE storage_key = ANY(sqlc.arg(storage_keys)::TEXT[]);
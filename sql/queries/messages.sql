@@ -0,0 +1,84 @@
+-- name: CreateConversation :one
+-- Creates a conversation with its members in a single statement.
+WITH conversation AS (
+    INSERT INTO conversations (id, created_at, updated_at, direct_key)
+    VALUES (gen_random_uuid(), NOW(), NOW(), sqlc.narg(direct_key))
+    RETURNING *
+), members AS (
+    INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+    SELECT conversation.id, UNNEST(sqlc.arg(member_ids)::UUID[]), NOW()
+    FROM conversation
+)
+SELECT * FROM conversation;
+
+-- name: GetConversationByDirectKey :one
+SELECT *
+FROM conversations
+WHERE direct_key = $1;
+
+-- name: GetConversationForMember :one
+-- Returns the conversation only if the user is one of its members.
+SELECT conversations.*
+FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversations.id = sqlc.arg(id)
+  AND conversation_members.user_id = sqlc.arg(user_id);
+
+-- name: ListConversationsForMember :many
+SELECT conversations.*,
+    (SELECT COUNT(*)
+     FROM messages
+     WHERE messages.conversation_id = conversations.id
+       AND messages.sender_id <> conversation_members.user_id
+       AND (conversation_members.last_read_at IS NULL OR messages.created_at > conversation_members.last_read_at)) AS unread_count
+FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversation_members.user_id = $1
+ORDER BY conversations.updated_at DESC, conversations.id DESC;
+
+-- name: ListConversationMembers :many
+SELECT conversation_members.conversation_id, conversation_members.last_read_at,
+    users.id, users.username, users.display_name
+FROM conversation_members
+JOIN users ON users.id = conversation_members.user_id
+WHERE conversation_members.conversation_id = ANY(sqlc.arg(conversation_ids)::UUID[])
+ORDER BY conversation_members.conversation_id, conversation_members.joined_at, users.id;
+
+-- name: CreateMessage :one
+WITH message AS (
+    INSERT INTO messages (id, created_at, conversation_id, sender_id, body)
+    VALUES (gen_random_uuid(), NOW(), $1, $2, $3)
+    RETURNING *
+), touched AS (
+    UPDATE conversations
+    SET updated_at = message.created_at
+    FROM message
+    WHERE conversations.id = message.conversation_id
+)
+SELECT * FROM message;
+
+-- name: ListMessages :many
+SELECT *
+FROM messages
+WHERE conversation_id = sqlc.arg(conversation_id)
+  AND (sqlc.narg(before_created_at)::TIMESTAMP IS NULL
+    OR (created_at, id) < (sqlc.narg(before_created_at)::TIMESTAMP, sqlc.narg(before_id)::UUID))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: GetMessage :one
+SELECT *
+FROM messages
+WHERE id = $1;
+
+-- name: MarkConversationRead :execrows
+-- Moves the member's read position forward to read_at, never back.
+UPDATE conversation_members
+SET last_read_at = GREATEST(COALESCE(last_read_at, sqlc.arg(read_at)::TIMESTAMP), sqlc.arg(read_at)::TIMESTAMP)
+WHERE conversation_id = sqlc.arg(conversation_id)
+  AND user_id = sqlc.arg(user_id);
+
+-- name: CountExistingUsers :one
+SELECT COUNT(*)
+FROM users
+WHERE id = ANY(sqlc.arg(ids)::UUID[]);
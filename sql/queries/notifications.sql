@@ -0,0 +1,87 @@
+-- name: CreateNotification :one
+-- Adds the actor to the user's unread notification with the same group key,
+-- or to a new notification if there is none. Returns no rows if the actor is
+-- hidden from the user.
+WITH notification AS (
+    INSERT INTO notifications (id, created_at, updated_at, user_id, type, chirp_id, group_key)
+    SELECT gen_random_uuid(), NOW(), NOW(), sqlc.arg(user_id)::UUID, sqlc.arg(type)::TEXT, sqlc.narg(chirp_id)::UUID, sqlc.arg(group_key)::TEXT
+    WHERE NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = sqlc.arg(user_id)::UUID AND hidden_users.hidden_id = sqlc.arg(actor_id)::UUID
+    )
+    ON CONFLICT (user_id, group_key) WHERE read_at IS NULL
+    DO UPDATE SET updated_at = NOW()
+    RETURNING id
+)
+INSERT INTO notification_actors (notification_id, actor_id, created_at)
+SELECT notification.id, sqlc.arg(actor_id), NOW()
+FROM notification
+ON CONFLICT (notification_id, actor_id) DO UPDATE SET created_at = EXCLUDED.created_at
+RETURNING notification_id;
+
+-- name: ListNotifications :many
+-- Leaves out the actors hidden from the user, and the notifications that only
+-- have such actors.
+SELECT notifications.*, visible.actor_count
+FROM notifications
+CROSS JOIN LATERAL (
+    SELECT COUNT(*) AS actor_count
+    FROM notification_actors
+    WHERE notification_actors.notification_id = notifications.id
+      AND NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = notifications.user_id AND hidden_users.hidden_id = notification_actors.actor_id
+      )
+) AS visible
+WHERE notifications.user_id = sqlc.arg(user_id)
+  AND visible.actor_count > 0
+  AND (sqlc.narg(before_updated_at)::TIMESTAMP IS NULL
+    OR (notifications.updated_at, notifications.id) < (sqlc.narg(before_updated_at)::TIMESTAMP, sqlc.narg(before_id)::UUID))
+ORDER BY notifications.updated_at DESC, notifications.id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ListNotificationActors :many
+-- Returns the most recent actors of each notification, up to max_actors,
+-- except the ones hidden from the user.
+SELECT ranked.notification_id, users.id, users.username, users.display_name
+FROM (
+    SELECT notification_id, actor_id, created_at,
+        ROW_NUMBER() OVER (PARTITION BY notification_id ORDER BY created_at DESC, actor_id) AS position
+    FROM notification_actors
+    WHERE notification_id = ANY(sqlc.arg(notification_ids)::UUID[])
+      AND NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = sqlc.arg(user_id) AND hidden_users.hidden_id = notification_actors.actor_id
+      )
+) AS ranked
+JOIN users ON users.id = ranked.actor_id
+WHERE ranked.position <= sqlc.arg(max_actors)::BIGINT
+ORDER BY ranked.notification_id, ranked.position;
+
+-- name: MarkNotificationRead :execrows
+UPDATE notifications
+SET read_at = COALESCE(read_at, NOW())
+WHERE id = $1
+  AND user_id = $2;
+
+-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = $1
+  AND read_at IS NULL;
+
+-- name: CountUnreadNotifications :one
+-- Counts the unread notifications that have an actor not hidden from the user.
+SELECT COUNT(*)
+FROM notifications
+WHERE notifications.user_id = $1
+  AND notifications.read_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM notification_actors
+    WHERE notification_actors.notification_id = notifications.id
+      AND NOT EXISTS (
+        SELECT 1 FROM hidden_users
+        WHERE hidden_users.user_id = notifications.user_id AND hidden_users.hidden_id = notification_actors.actor_id
+      )
+  );
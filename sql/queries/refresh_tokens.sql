@@ -19,3 +19,10 @@ WHERE token = $1;
 SELECT *
 FROM refresh_tokens
 WHERE token = $1;
+
+-- name: RevokeUserRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(),
+    updated_at = NOW()
+WHERE user_id = $1
+    AND revoked_at IS NULL;
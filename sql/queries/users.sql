@@ -31,8 +31,79 @@ SET email = COALESCE($2, email),
 WHERE id = $1
 RETURNING *;
 
--- name: UpgradeUserToChirpyRed :exec
+-- name: UpgradeUserToChirpyRed :execrows
 UPDATE users
 SET is_chirpy_red = TRUE,
     updated_at = NOW()
 WHERE id = $1;
+
+-- name: GetUser :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: ListUsers :many
+SELECT *
+FROM users
+ORDER BY created_at ASC;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: SetUserChirpyRed :execrows
+UPDATE users
+SET is_chirpy_red = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdateUserPassword :execrows
+UPDATE users
+SET hashed_password = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id)
+  AND hashed_password = sqlc.arg(old_hash);
+
+-- name: UpdateUserProfile :one
+-- Updates the profile fields that are not null, and the username and avatar
+-- when set_username and set_avatar are true, where null removes them. It
+-- returns whether the user was protected before and is after the update.
+UPDATE users
+SET username = CASE WHEN sqlc.arg(set_username)::BOOLEAN THEN sqlc.narg(username) ELSE users.username END,
+    display_name = COALESCE(sqlc.narg(display_name), users.display_name),
+    bio = COALESCE(sqlc.narg(bio), users.bio),
+    location = COALESCE(sqlc.narg(location), users.location),
+    website = COALESCE(sqlc.narg(website), users.website),
+    avatar_media_id = CASE WHEN sqlc.arg(set_avatar)::BOOLEAN THEN sqlc.narg(avatar_media_id) ELSE users.avatar_media_id END,
+    is_protected = COALESCE(sqlc.narg(is_protected), users.is_protected),
+    updated_at = NOW()
+FROM (SELECT locked.id, locked.is_protected FROM users AS locked WHERE locked.id = sqlc.arg(id) FOR UPDATE) AS old
+WHERE users.id = old.id
+RETURNING old.is_protected AS was_protected, users.is_protected;
+
+-- name: GetUserProfile :one
+SELECT users.id,
+    users.created_at,
+    users.username,
+    users.display_name,
+    users.bio,
+    users.location,
+    users.website,
+    users.avatar_media_id,
+    users.is_chirpy_red,
+    users.is_protected,
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = users.id) AS chirp_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.followee_id = users.id) AS follower_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.follower_id = users.id) AS following_count
+FROM users
+WHERE users.id = $1;
+
+-- name: GetUserIDsByUsernames :many
+SELECT id, username
+FROM users
+WHERE username = ANY(sqlc.arg(usernames)::TEXT[]);
@@ -0,0 +1,65 @@
+-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, created_at, updated_at, provider, event_id, event_type, payload, status, attempts)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    'pending',
+    0
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT *
+FROM webhook_events
+WHERE id = $1;
+
+-- name: GetWebhookEventByEventID :one
+SELECT *
+FROM webhook_events
+WHERE provider = $1 AND event_id = $2;
+
+-- name: ClaimWebhookEvent :one
+-- Events left processing for longer than the lease, by a crash or a timeout,
+-- can be claimed again.
+UPDATE webhook_events
+SET status = 'processing',
+    attempts = attempts + 1,
+    updated_at = NOW()
+WHERE id = sqlc.arg(id)
+  AND (
+    status IN ('pending', 'failed')
+    OR (status = 'processing' AND updated_at < NOW() - sqlc.arg(lease_seconds)::int * INTERVAL '1 second')
+  )
+RETURNING *;
+
+-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events
+SET status = $2,
+    last_error = NULL,
+    processed_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkWebhookEventFailed :exec
+UPDATE webhook_events
+SET status = 'failed',
+    last_error = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT *
+FROM webhook_events
+ORDER BY created_at DESC;
+
+-- name: ListWebhookEventsByStatus :many
+SELECT *
+FROM webhook_events
+WHERE status = $1
+ORDER BY created_at DESC;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE event_payloads (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    -- JSON rather than JSONB keeps the event data byte for byte
+    payload JSON NOT NULL
+);
+
+CREATE INDEX event_payloads_created_at_idx ON event_payloads (created_at);
+
+-- +goose Down
+DROP TABLE event_payloads;
@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE blocks (
+    blocker_id UUID NOT NULL,
+    blocked_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (blocker_id, blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_blocks_blocked_id ON blocks (blocked_id);
+
+CREATE TABLE mutes (
+    muter_id UUID NOT NULL,
+    muted_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (muter_id, muted_id),
+    FOREIGN KEY (muter_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (muted_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- The users whose chirps and actions are hidden from each user: the users
+-- they blocked or muted and the users who blocked them
+CREATE VIEW hidden_users AS
+SELECT blocker_id AS user_id, blocked_id AS hidden_id FROM blocks
+UNION
+SELECT blocked_id AS user_id, blocker_id AS hidden_id FROM blocks
+UNION
+SELECT muter_id AS user_id, muted_id AS hidden_id FROM mutes;
+
+-- +goose Down
+DROP VIEW IF EXISTS hidden_users;
+DROP TABLE IF EXISTS mutes;
+DROP TABLE IF EXISTS blocks;
@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN is_protected BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE follow_requests (
+    requester_id UUID NOT NULL,
+    target_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (requester_id, target_id),
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (target_id) REFERENCES users(id) ON DELETE CASCADE,
+    CHECK (requester_id <> target_id)
+);
+
+CREATE INDEX idx_follow_requests_target_id ON follow_requests (target_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS follow_requests;
+ALTER TABLE users
+DROP COLUMN is_protected;
@@ -0,0 +1,51 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public'
+    CHECK (visibility IN ('public', 'unlisted', 'followers', 'mentioned')),
+ADD COLUMN content_warning TEXT NOT NULL DEFAULT '',
+ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE chirp_mentions (
+    chirp_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    PRIMARY KEY (chirp_id, user_id),
+    FOREIGN KEY (chirp_id) REFERENCES chirps(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_chirp_mentions_user_id ON chirp_mentions (user_id);
+
+-- chirp_visible_to reports whether a viewer, who is NULL when logged out, may
+-- see a chirp. Authors always see their own chirps and mentioned users see the
+-- chirps that mention them. Otherwise only followers see followers-only chirps
+-- and the chirps of protected users, and public and unlisted chirps are
+-- visible to everyone.
+-- +goose StatementBegin
+CREATE FUNCTION chirp_visible_to(chirp_id UUID, viewer_id UUID) RETURNS BOOLEAN
+LANGUAGE sql STABLE AS $$
+    SELECT EXISTS (
+        SELECT 1
+        FROM chirps
+        JOIN users ON users.id = chirps.user_id
+        WHERE chirps.id = chirp_visible_to.chirp_id
+          AND (chirps.user_id = chirp_visible_to.viewer_id
+            OR EXISTS (
+              SELECT 1 FROM chirp_mentions
+              WHERE chirp_mentions.chirp_id = chirps.id AND chirp_mentions.user_id = chirp_visible_to.viewer_id
+            )
+            OR (chirps.visibility <> 'mentioned' AND EXISTS (
+              SELECT 1 FROM follows
+              WHERE follows.follower_id = chirp_visible_to.viewer_id AND follows.followee_id = chirps.user_id
+            ))
+            OR (chirps.visibility IN ('public', 'unlisted') AND NOT users.is_protected))
+    );
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION IF EXISTS chirp_visible_to(UUID, UUID);
+DROP TABLE IF EXISTS chirp_mentions;
+ALTER TABLE chirps
+DROP COLUMN sensitive,
+DROP COLUMN content_warning,
+DROP COLUMN visibility;
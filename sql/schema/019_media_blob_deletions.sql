@@ -0,0 +1,32 @@
+-- +goose Up
+-- media_blob_deletions queues the blobs of deleted media rows, which are also
+-- deleted by cascade when their chirp or uploader is, until the media sweeper
+-- removes them from the blob store.
+CREATE TABLE media_blob_deletions (
+    storage_key TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION queue_media_blob_deletions() RETURNS TRIGGER
+LANGUAGE plpgsql AS $$
+BEGIN
+    INSERT INTO media_blob_deletions (storage_key, created_at)
+    VALUES (OLD.storage_key, NOW()), (OLD.thumbnail_key, NOW())
+    ON CONFLICT (storage_key) DO NOTHING;
+    RETURN OLD;
+END;
+$$;
+-- +goose StatementEnd
+
+CREATE TRIGGER media_blob_deletions
+AFTER DELETE ON media
+FOR EACH ROW EXECUTE FUNCTION queue_media_blob_deletions();
+
+CREATE INDEX idx_media_unattached_created_at ON media (created_at) WHERE chirp_id IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_media_unattached_created_at;
+DROP TRIGGER IF EXISTS media_blob_deletions ON media;
+DROP FUNCTION IF EXISTS queue_media_blob_deletions();
+DROP TABLE IF EXISTS media_blob_deletions;
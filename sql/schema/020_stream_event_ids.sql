@@ -0,0 +1,7 @@
+-- +goose Up
+-- stream_event_ids numbers the events of the real-time APIs across instances,
+-- so that a stream resumes with the same Last-Event-ID on any of them.
+CREATE SEQUENCE stream_event_ids;
+
+-- +goose Down
+DROP SEQUENCE IF EXISTS stream_event_ids;
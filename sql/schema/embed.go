@@ -0,0 +1,9 @@
+// Package schema embeds the goose migrations so that they ship with the binary.
+package schema
+
+import "embed"
+
+// FS holds the goose migrations in this directory.
+//
+//go:embed *.sql
+var FS embed.FS